@@ -4,13 +4,17 @@ import (
 	"cofin/controllers"
 	"cofin/core"
 	"cofin/internal/amplitude"
+	"cofin/internal/google_pki"
+	"cofin/internal/quote_feed"
 	"cofin/internal/retrieval"
 	"cofin/internal/stripe_api"
 	"cofin/models"
+	"context"
 	"os"
 
 	"github.com/gin-gonic/gin"
 	"github.com/joho/godotenv"
+	"go.uber.org/zap"
 	"gorm.io/gorm"
 )
 
@@ -23,23 +27,20 @@ func main() {
 		panic(err)
 	}
 
-	// auto migrate the database
-	err = db.Debug().AutoMigrate(
-		&models.User{},
-		&models.Company{},
-		&models.Document{},
-		&models.AccessToken{},
-		&models.Message{},
-	)
+	logger, err := core.NewLogger()
 	if err != nil {
 		panic(err)
 	}
 
-	server := createServer(db)
+	if err := models.Migrate(db); err != nil {
+		panic(err)
+	}
+
+	server := createServer(db, logger)
 	server.Run()
 }
 
-func createServer(db *gorm.DB) *gin.Engine {
+func createServer(db *gorm.DB, logger *zap.SugaredLogger) *gin.Engine {
 	// set up http server
 	engine := gin.Default()
 	err := engine.SetTrustedProxies(nil)
@@ -66,17 +67,35 @@ func createServer(db *gorm.DB) *gin.Engine {
 		panic(err)
 	}
 
-	logger, err := core.NewLogger()
+	embedder, err := retrieval.NewEmbedder()
 	if err != nil {
 		panic(err)
 	}
 
+	splitter, err := retrieval.NewSplitter(retrieval.DefaultChunkLength, retrieval.DefaultChunkOverlap)
+	if err != nil {
+		panic(err)
+	}
+
+	// ID tokens are verified for the Firebase project, and none verifies
+	// without one.
+	firebaseProjectID, err := core.GetEnvRequired("FIREBASE_PROJECT_ID")
+	if err != nil {
+		panic(err)
+	}
+
+	// Quote updates are pushed to clients as the fetchers store them.
+	feed := quote_feed.NewFeed(db, logger.With("component", "quote_feed"))
+	go feed.Listen(context.Background())
+
 	router := controllers.Router{
 		AuthController: &controllers.AuthController{
-			DB:        db,
-			Logger:    logger.With("controller", "auth"),
-			StripeAPI: stripe_api.NewStripeAPI(),
-			Amplitude: amplitude.Initialize(),
+			DB:                db,
+			Logger:            logger.With("controller", "auth"),
+			StripeAPI:         stripe_api.NewStripeAPI(),
+			Amplitude:         amplitude.Initialize(),
+			GooglePKI:         google_pki.NewGooglePKI(),
+			FirebaseProjectID: firebaseProjectID,
 		},
 		HealthController: &controllers.HealthController{
 			DB:     db,
@@ -93,8 +112,9 @@ func createServer(db *gorm.DB) *gin.Engine {
 			Amplitude: amplitude.Initialize(),
 		},
 		UsersController: &controllers.UsersController{
-			DB:     db,
-			Logger: logger.With("controller", "users"),
+			DB:        db,
+			Logger:    logger.With("controller", "users"),
+			StripeAPI: stripe_api.NewStripeAPI(),
 		},
 		PaymentsController: &controllers.PaymentsController{
 			DB:        db,
@@ -102,6 +122,45 @@ func createServer(db *gorm.DB) *gin.Engine {
 			StripeAPI: stripe_api.NewStripeAPI(),
 			Amplitude: amplitude.Initialize(),
 		},
+		DocumentsController: &controllers.DocumentsController{
+			DB:     db,
+			Logger: logger.With("controller", "documents"),
+		},
+		TranscriptsController: &controllers.TranscriptsController{
+			DB:       db,
+			Logger:   logger.With("controller", "transcripts"),
+			Embedder: embedder,
+			Splitter: splitter,
+		},
+		IngestionsController: &controllers.IngestionsController{
+			DB:     db,
+			Logger: logger.With("controller", "ingestions"),
+		},
+		QuotesController: &controllers.QuotesController{
+			DB:     db,
+			Logger: logger.With("controller", "quotes"),
+			Feed:   feed,
+		},
+		WatchlistsController: &controllers.WatchlistsController{
+			DB:     db,
+			Logger: logger.With("controller", "watchlists"),
+		},
+		AlertsController: &controllers.AlertsController{
+			DB:     db,
+			Logger: logger.With("controller", "alerts"),
+		},
+		NotificationsController: &controllers.NotificationsController{
+			DB:     db,
+			Logger: logger.With("controller", "notifications"),
+		},
+		SessionsController: &controllers.SessionsController{
+			DB:     db,
+			Logger: logger.With("controller", "sessions"),
+		},
+		APIKeysController: &controllers.APIKeysController{
+			DB:     db,
+			Logger: logger.With("controller", "api_keys"),
+		},
 	}
 
 	router.RegisterRoutes(engine)
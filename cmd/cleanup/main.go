@@ -0,0 +1,40 @@
+package main
+
+import (
+	"cofin/core"
+	"cofin/models"
+
+	"github.com/joho/godotenv"
+)
+
+func main() {
+	godotenv.Load()
+
+	db, err := core.InitDB()
+	if err != nil {
+		panic(err)
+	}
+
+	logger, err := core.NewLogger()
+	if err != nil {
+		panic(err)
+	}
+
+	if err := models.Migrate(db); err != nil {
+		panic(err)
+	}
+
+	deleted, err := models.DeleteExpiredAccessTokens(db)
+	if err != nil {
+		logger.Errorf("Failed to delete expired access tokens: %v", err)
+		panic(err)
+	}
+	logger.Infof("Deleted %v expired access tokens", deleted)
+
+	deleted, err = models.DeleteExpiredAPIKeys(db)
+	if err != nil {
+		logger.Errorf("Failed to delete expired API keys: %v", err)
+		panic(err)
+	}
+	logger.Infof("Deleted %v expired API keys", deleted)
+}
@@ -0,0 +1,377 @@
+package main
+
+import (
+	"cofin/internal/sec_api"
+	"cofin/models"
+	"flag"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// The filing kinds the fetcher ingests, in the order they are processed.
+// Amendments are processed after the filings they might amend. Domestic
+// companies file 10-Ks and 10-Qs; foreign private issuers file 20-Fs, 40-Fs
+// and 6-Ks instead.
+var (
+	domesticKinds = []models.SourceKind{models.K10, models.Q10, models.K10A, models.Q10A}
+	foreignKinds  = []models.SourceKind{models.F20, models.F40, models.K6, models.F20A, models.F40A}
+	filingKinds   = append(append([]models.SourceKind{}, domesticKinds...), foreignKinds...)
+)
+
+// Rough averages used to estimate the cost of a backfill before running it.
+// Lengths are of the text we extract from a filing, in characters.
+var estimatedFilingLength = map[models.SourceKind]int{
+	models.K10:  300000,
+	models.Q10:  120000,
+	models.K10A: 60000,
+	models.Q10A: 30000,
+	models.F20:  400000,
+	models.F40:  300000,
+	models.K6:   20000,
+	models.F20A: 60000,
+	models.F40A: 60000,
+}
+
+// About four characters make a token. Embeddings cost $0.0001 per thousand
+// tokens.
+const CHARACTERS_PER_TOKEN = 4
+const EMBEDDING_COST_PER_1K_TOKENS = 0.0001
+
+// Number of chunks stored per call to the vector store, see
+// retrieval.StoreChunks.
+const CHUNKS_PER_STORE_CALL = 50
+
+// backfillOptions selects the filings a backfill fetches.
+type backfillOptions struct {
+	tickers []string
+	ciks    []string
+	kinds   []models.SourceKind
+	from    time.Time
+	to      time.Time
+	dryRun  bool
+}
+
+// runBackfill parses the flags of the backfill command and runs it.
+func (f *documentFetcher) runBackfill(args []string) error {
+	var kindNames []string
+	for _, kind := range filingKinds {
+		kindNames = append(kindNames, string(kind))
+	}
+
+	flags := flag.NewFlagSet("backfill", flag.ExitOnError)
+	tickers := flags.String("tickers", "", "comma-separated tickers of the companies to backfill")
+	ciks := flags.String("ciks", "", "comma-separated CIKs of the companies to backfill")
+	forms := flags.String("forms", strings.Join(kindNames, ","), "comma-separated form types to backfill")
+	from := flags.String("from", time.Now().Add(-f.config.lookback).Format("2006-01-02"), "first filing date to backfill, YYYY-MM-DD")
+	to := flags.String("to", time.Now().Format("2006-01-02"), "last filing date to backfill, YYYY-MM-DD")
+	dryRun := flags.Bool("dry-run", false, "print what would be fetched and its estimated cost without fetching it")
+	flags.IntVar(&f.config.batchSize, "batch-size", f.config.batchSize, "number of filings to list per request")
+	flags.Parse(args)
+
+	options := backfillOptions{
+		tickers: splitList(*tickers),
+		ciks:    splitList(*ciks),
+		dryRun:  *dryRun,
+	}
+
+	if len(options.tickers) == 0 && len(options.ciks) == 0 {
+		return fmt.Errorf("at least one of -tickers and -ciks is required")
+	}
+
+	if f.config.batchSize < 1 {
+		return fmt.Errorf("-batch-size must be positive")
+	}
+
+	for _, form := range splitList(*forms) {
+		kind := models.SourceKind(strings.ToUpper(form))
+		if !isFilingKind(kind) {
+			return fmt.Errorf("unknown form type %q, expected one of %v", form, strings.Join(kindNames, ", "))
+		}
+		if isForeignKind(kind) && f.foreignFilings == nil {
+			return fmt.Errorf("form type %v requires EDGAR_USER_AGENT to be set", kind)
+		}
+		options.kinds = append(options.kinds, kind)
+	}
+
+	var err error
+	if options.from, err = time.Parse("2006-01-02", *from); err != nil {
+		return fmt.Errorf("invalid -from: %w", err)
+	}
+	if options.to, err = time.Parse("2006-01-02", *to); err != nil {
+		return fmt.Errorf("invalid -to: %w", err)
+	}
+	// Include filings made during the last day.
+	options.to = options.to.Add(24*time.Hour - time.Second)
+	if options.to.Before(options.from) {
+		return fmt.Errorf("-to is before -from")
+	}
+
+	return f.backfill(options)
+}
+
+// backfillEstimate counts the work a backfill does.
+type backfillEstimate struct {
+	filings int
+	// Calls made to the filings provider to list filings.
+	listCalls int
+	// Calls to the filings provider to extract sections, to the embedding API
+	// and to the vector store.
+	extractCalls  int
+	embedCalls    int
+	storeCalls    int
+	embeddingCost float64
+}
+
+func (e *backfillEstimate) add(kind models.SourceKind, filings int) {
+	chunks := (estimatedFilingLength[kind] + CHUNK_SIZE - 1) / CHUNK_SIZE
+	batches := (chunks + CHUNKS_PER_STORE_CALL - 1) / CHUNKS_PER_STORE_CALL
+	tokens := estimatedFilingLength[kind] / CHARACTERS_PER_TOKEN
+
+	e.filings += filings
+	e.extractCalls += filings * len(models.SectionsOfKind(kind))
+	e.embedCalls += filings * batches
+	// One more call per filing deletes chunks left behind by earlier
+	// attempts.
+	e.storeCalls += filings * (batches + 1)
+	e.embeddingCost += float64(filings*tokens) / 1000 * EMBEDDING_COST_PER_1K_TOKENS
+}
+
+// backfill fetches the filings of the given kinds filed in the given date range
+// for the given companies, skipping filings that are already ingested. With
+// dryRun, nothing is fetched or stored; the filings are listed and the work to
+// fetch them is estimated instead.
+func (f *documentFetcher) backfill(options backfillOptions) error {
+	logger := f.logger
+
+	companies, err := f.resolveCompanies(options.tickers, options.ciks, !options.dryRun)
+	if err != nil {
+		return err
+	}
+
+	if !options.dryRun {
+		finish, err := f.beginIngestionRun()
+		if err != nil {
+			return err
+		}
+		defer finish()
+	}
+
+	var estimate backfillEstimate
+	for _, company := range companies {
+		company := company
+		for _, kind := range options.kinds {
+			filings, calls, err := f.listFilings(company.CIK, kind, options.from, options.to)
+			estimate.listCalls += calls
+			if err != nil {
+				return fmt.Errorf("failed to list %v filings for %v (%v): %w", kind, company.Name, company.Ticker, err)
+			}
+
+			pending, err := f.getPending(filings)
+			if err != nil {
+				return fmt.Errorf("failed to check ingested filings for %v (%v): %w", company.Name, company.Ticker, err)
+			}
+
+			if options.dryRun {
+				fmt.Printf("%-8v %-6v %4v filings, %4v already ingested or failed\n", company.Ticker, kind, len(pending), len(filings)-len(pending))
+				estimate.add(kind, len(pending))
+				continue
+			}
+
+			logger.Infow(fmt.Sprintf("Backfilling %v %v filings", len(pending), kind), "companyID", company.ID)
+			store, err := f.newStore(company.ID)
+			if err != nil {
+				return err
+			}
+
+			if err := f.ingestFilings(&company, store, kind, pending); err != nil {
+				return err
+			}
+		}
+	}
+
+	if options.dryRun {
+		fmt.Printf("\n%v filings to fetch\n", estimate.filings)
+		fmt.Printf("%v filings provider calls made to list filings\n", estimate.listCalls)
+		fmt.Printf("About %v filings provider calls, %v embedding calls and %v vector store calls to fetch them\n", estimate.extractCalls, estimate.embedCalls, estimate.storeCalls)
+		fmt.Printf("Estimated embedding cost: $%.2f\n", estimate.embeddingCost)
+	}
+
+	return nil
+}
+
+// listFilings lists all filings of a kind of a company filed between from and
+// to, a page at a time. It returns the filings and the number of calls made.
+func (f *documentFetcher) listFilings(cik string, kind models.SourceKind, from, to time.Time) (filings []sec_api.Filing, calls int, err error) {
+	seen := map[string]bool{}
+	since := from
+	for {
+		page, err := f.filings.GetFilingsSince(cik, kind, since, f.config.batchSize)
+		calls++
+		if err != nil {
+			return nil, calls, err
+		}
+
+		// Pages overlap by the filings filed at the same time as the last
+		// filing of the previous page.
+		var added int
+		var last time.Time
+		for _, filing := range page {
+			filedAt, err := time.Parse(time.RFC3339, filing.FiledAt)
+			if err != nil {
+				return nil, calls, fmt.Errorf("failed to parse filing time (accession number %v): %w", filing.AccessionNo, err)
+			}
+
+			if filedAt.After(to) {
+				return filings, calls, nil
+			}
+
+			last = filedAt
+			if seen[filing.AccessionNo] {
+				continue
+			}
+			seen[filing.AccessionNo] = true
+			filings = append(filings, filing)
+			added++
+		}
+
+		if len(page) < f.config.batchSize || added == 0 {
+			return filings, calls, nil
+		}
+
+		since = last
+	}
+}
+
+// resolveCompanies finds the companies with the given tickers and CIKs.
+// Companies we do not have yet are looked up in the exchange listings and, if
+// create is set, created.
+func (f *documentFetcher) resolveCompanies(tickers, ciks []string, create bool) ([]models.Company, error) {
+	var listings []sec_api.Listing
+	findListing := func(matches func(sec_api.Listing) bool) (*sec_api.Listing, error) {
+		if listings == nil {
+			for _, exchange := range sec_api.StockExchanges {
+				exchangeListings, err := f.filings.GetTradedCompanies(exchange)
+				if err != nil {
+					return nil, fmt.Errorf("failed to get companies traded on %v: %w", exchange, err)
+				}
+				listings = append(listings, exchangeListings...)
+			}
+		}
+
+		for i := range listings {
+			if matches(listings[i]) {
+				return &listings[i], nil
+			}
+		}
+
+		return nil, nil
+	}
+
+	resolve := func(company *models.Company, listing *sec_api.Listing, name string) (*models.Company, error) {
+		if company != nil {
+			return company, nil
+		} else if listing == nil {
+			return nil, fmt.Errorf("unknown company %v", name)
+		}
+
+		// A ticker we do not know may be another share class of a company
+		// we have.
+		if listing.CIK != "" {
+			existing, err := models.GetCompanyByCIK(f.db, listing.CIK)
+			if err != nil {
+				return nil, err
+			} else if existing != nil {
+				return existing, nil
+			}
+		}
+
+		if !create {
+			return &models.Company{Name: listing.Name, Ticker: strings.ToUpper(listing.Ticker), CIK: listing.CIK}, nil
+		}
+
+		f.logger.Infof("Creating company: %v", listing.Ticker)
+		return models.CreateCompany(f.db, listing.Name, listing.Ticker, listing.CIK, time.Time{}, listing.Profile())
+	}
+
+	var companies []models.Company
+	for _, ticker := range tickers {
+		company, err := models.GetCompanyByTicker(f.db, ticker)
+		if err != nil {
+			return nil, err
+		}
+
+		var listing *sec_api.Listing
+		if company == nil {
+			listing, err = findListing(func(listing sec_api.Listing) bool {
+				return strings.EqualFold(listing.Ticker, ticker) && !listing.IsDelisted
+			})
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		company, err = resolve(company, listing, ticker)
+		if err != nil {
+			return nil, err
+		}
+		companies = append(companies, *company)
+	}
+
+	for _, cik := range ciks {
+		company, err := models.GetCompanyByCIK(f.db, cik)
+		if err != nil {
+			return nil, err
+		}
+
+		var listing *sec_api.Listing
+		if company == nil {
+			listing, err = findListing(func(listing sec_api.Listing) bool {
+				return strings.TrimLeft(listing.CIK, "0") == strings.TrimLeft(cik, "0") && !listing.IsDelisted
+			})
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		company, err = resolve(company, listing, "with CIK "+cik)
+		if err != nil {
+			return nil, err
+		}
+		companies = append(companies, *company)
+	}
+
+	return companies, nil
+}
+
+func isForeignKind(kind models.SourceKind) bool {
+	for _, foreignKind := range foreignKinds {
+		if kind == foreignKind {
+			return true
+		}
+	}
+
+	return false
+}
+
+func isFilingKind(kind models.SourceKind) bool {
+	for _, filingKind := range filingKinds {
+		if kind == filingKind {
+			return true
+		}
+	}
+
+	return false
+}
+
+// splitList splits a comma-separated flag value, dropping empty items.
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+
+	return items
+}
@@ -2,28 +2,38 @@ package main
 
 import (
 	"cofin/core"
-	"cofin/internal/real_stonks"
+	"cofin/internal/alerts"
+	"cofin/internal/edgar"
+	"cofin/internal/market_data"
+	"cofin/internal/ratelimit"
 	"cofin/internal/retrieval"
 	"cofin/internal/sec_api"
+	"cofin/internal/transcripts"
 	"cofin/models"
 	"context"
+	"encoding/json"
+	"flag"
 	"fmt"
 	"os"
-	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/joho/godotenv"
 	"github.com/tmc/langchaingo/documentloaders"
 	"github.com/tmc/langchaingo/embeddings"
+	"github.com/tmc/langchaingo/schema"
 	"github.com/tmc/langchaingo/vectorstores"
 	"go.uber.org/zap"
+	"golang.org/x/sync/errgroup"
 	"gorm.io/gorm"
 )
 
-const CHUNK_SIZE = 3000
-const CHUNK_OVERLAP = 100
+const CHUNK_SIZE = retrieval.DefaultChunkLength
+const CHUNK_OVERLAP = retrieval.DefaultChunkOverlap
 const MAX_FILINGS_PER_COMPANY_PER_BATCH = 20
+const MAX_RETRIES_PER_RUN = 100
 
 var SEC_API_KEY = ""
 
@@ -38,147 +48,429 @@ func main() {
 		panic(err)
 	}
 
-	// auto migrate the database
-	err = db.Debug().AutoMigrate(
-		&models.User{},
-		&models.Company{},
-		&models.Document{},
-		&models.AccessToken{},
-		&models.Message{},
-	)
+	logger, err := core.NewLogger()
 	if err != nil {
 		panic(err)
 	}
 
-	fetcher, err := newDocumentFetcher(db)
+	// The subcommand defaults to fetch, so that running the fetcher without
+	// arguments keeps working.
+	command, args := "fetch", os.Args[1:]
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		command, args = args[0], args[1:]
+	}
+
+	// Companies sharing a CIK are merged before migrating, which fails to
+	// create the unique index on CIKs while there are any.
+	if command == "merge-ciks" {
+		if err := mergeDuplicateCIKs(db, logger); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if err := models.Migrate(db); err != nil {
+		panic(err)
+	}
+
+	fetcher, err := newDocumentFetcher(db, logger)
 	if err != nil {
 		panic(err)
 	}
 
-	fetcher.run()
+	switch command {
+	case "fetch":
+		err = fetcher.runFetch(args)
+	case "backfill":
+		err = fetcher.runBackfill(args)
+	case "backfill-sections":
+		fetcher.backfillSections()
+	case "backfill-accession-numbers":
+		fetcher.backfillAccessionNos()
+	default:
+		fmt.Fprint(os.Stderr, USAGE)
+		os.Exit(2)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+const USAGE = `Usage: document_fetcher [command] [flags]
+
+Commands:
+  fetch                       Fetch new filings of all listed companies (default)
+  backfill                    Fetch filings of chosen companies, forms and dates
+  backfill-sections           Store sections of documents fetched before sections were stored
+  backfill-accession-numbers  Store accession numbers of documents fetched before they were stored
+  merge-ciks                  Merge companies sharing a CIK, once before the unique index on CIKs
+
+Run "document_fetcher <command> -h" for the flags of a command.
+`
+
+// runFetch parses the flags of the fetch command and runs it.
+func (f *documentFetcher) runFetch(args []string) error {
+	maxCompanies, err := core.GetEnvInt("MAX_COMPANIES", 0)
+	if err != nil {
+		return err
+	}
+
+	flags := flag.NewFlagSet("fetch", flag.ExitOnError)
+	lookbackDays := flags.Int("lookback-days", int(f.config.lookback/(24*time.Hour)), "days of filings to fetch for companies without filings")
+	flags.IntVar(&f.config.batchSize, "batch-size", f.config.batchSize, "maximum filings of a form to fetch per company per run")
+	flags.IntVar(&maxCompanies, "max-companies", maxCompanies, "maximum companies to process, 0 for all (defaults to MAX_COMPANIES)")
+	flags.Parse(args)
+
+	if *lookbackDays < 1 || f.config.batchSize < 1 || maxCompanies < 0 {
+		return fmt.Errorf("-lookback-days and -batch-size must be positive and -max-companies must not be negative")
+	}
+	f.config.lookback = time.Duration(*lookbackDays) * 24 * time.Hour
+
+	f.fetchDocuments(maxCompanies)
+	return nil
+}
+
+// newFilingsProvider returns the paid sec-api.io provider by default, or the
+// public EDGAR provider when name is "edgar".
+func newFilingsProvider(name string) (sec_api.Provider, error) {
+	switch name {
+	case "", "sec_api":
+		return sec_api.NewClient(SEC_API_KEY), nil
+	case "edgar":
+		return edgar.NewClient(os.Getenv("EDGAR_USER_AGENT"))
+	default:
+		return nil, fmt.Errorf("unknown filings provider %q", name)
+	}
+}
+
+// pipelineConfig sets the concurrency of each stage of the fetching pipeline
+// and the rates at which external services are called. All workers share the
+// same rate limiters.
+type pipelineConfig struct {
+	// Number of companies processed at the same time.
+	listingWorkers int
+	// Number of sections of a filing extracted at the same time.
+	sectionWorkers int
+	// Number of chunk batches of a document stored at the same time.
+	storeWorkers int
+
+	// How far back to fetch filings of a kind we have no documents of.
+	lookback time.Duration
+	// Maximum number of filings of a kind fetched per company per run. This
+	// guarantees that no company hogs the fetching pipeline for too long.
+	batchSize int
+
+	secAPIRequestsPerSecond   float64
+	openAIRequestsPerSecond   float64
+	pineconeRequestsPerSecond float64
+}
+
+func newPipelineConfig() (config pipelineConfig, err error) {
+	if config.listingWorkers, err = core.GetEnvInt("LISTING_WORKERS", 4); err != nil {
+		return config, err
+	}
+	if config.sectionWorkers, err = core.GetEnvInt("SECTION_WORKERS", 4); err != nil {
+		return config, err
+	}
+	if config.storeWorkers, err = core.GetEnvInt("STORE_WORKERS", 4); err != nil {
+		return config, err
+	}
+	if config.secAPIRequestsPerSecond, err = core.GetEnvFloat("SEC_API_REQUESTS_PER_SECOND", 5); err != nil {
+		return config, err
+	}
+	if config.openAIRequestsPerSecond, err = core.GetEnvFloat("OPENAI_REQUESTS_PER_SECOND", 10); err != nil {
+		return config, err
+	}
+	if config.pineconeRequestsPerSecond, err = core.GetEnvFloat("PINECONE_REQUESTS_PER_SECOND", 20); err != nil {
+		return config, err
+	}
+
+	if config.listingWorkers < 1 || config.sectionWorkers < 1 || config.storeWorkers < 1 {
+		return config, fmt.Errorf("worker counts must be positive")
+	}
+
+	config.lookback = 365 * 24 * time.Hour
+	config.batchSize = MAX_FILINGS_PER_COMPANY_PER_BATCH
+
+	return config, nil
 }
 
 type documentFetcher struct {
-	db       *gorm.DB
-	embedder embeddings.Embedder
-	splitter *retrieval.Splitter
-	logger   *zap.SugaredLogger
+	db          *gorm.DB
+	embedder    embeddings.Embedder
+	splitter    *retrieval.Splitter
+	logger      *zap.SugaredLogger
+	transcripts transcripts.Provider
+	quotes      market_data.Provider
+	// filings lists companies and fetches their filings. It is chosen with
+	// the FILINGS_PROVIDER environment variable.
+	filings sec_api.Provider
+	// foreignFilings extracts sections of 20-F, 40-F and 6-K filings, which
+	// sec-api.io does not extract. It is an EDGAR client. Foreign filings
+	// are fetched only when EDGAR_USER_AGENT is set; otherwise it is nil.
+	foreignFilings sec_api.Provider
+	// fundamentals fetches the XBRL figures of filings to compute valuation
+	// metrics. It is an EDGAR client, and nil unless EDGAR_USER_AGENT is set
+	// or EDGAR is the filings provider.
+	fundamentals    *edgar.Client
+	pineconeLimiter *ratelimit.Limiter
+	config          pipelineConfig
+	// secAPIMetrics counts the calls made to sec-api.io, if it is the filings
+	// provider.
+	secAPIMetrics *sec_api.Metrics
+
+	// The current run and the number of filings it ingested and failed to
+	// ingest.
+	ingestionRun *models.IngestionRun
+	succeeded    atomic.Int64
+	failed       atomic.Int64
 }
 
-func newDocumentFetcher(db *gorm.DB) (*documentFetcher, error) {
-	embedder, err := retrieval.NewEmbedder()
+func newDocumentFetcher(db *gorm.DB, logger *zap.SugaredLogger) (*documentFetcher, error) {
+	config, err := newPipelineConfig()
 	if err != nil {
 		return nil, err
 	}
 
-	splitter, err := retrieval.NewSplitter(CHUNK_SIZE, CHUNK_OVERLAP)
+	filings, err := newFilingsProvider(os.Getenv("FILINGS_PROVIDER"))
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
 
-	logger, err := core.NewLogger()
+	var secAPIMetrics *sec_api.Metrics
+	if client, ok := filings.(*sec_api.Client); ok {
+		secAPIMetrics = client.Metrics
+	}
+
+	var edgarClient *edgar.Client
+	if client, ok := filings.(*edgar.Client); ok {
+		edgarClient = client
+	} else if userAgent := os.Getenv("EDGAR_USER_AGENT"); userAgent != "" {
+		if edgarClient, err = edgar.NewClient(userAgent); err != nil {
+			return nil, err
+		}
+	}
+
+	var foreignFilings sec_api.Provider
+	if edgarClient != nil {
+		foreignFilings = edgarClient
+	}
+
+	quotes, err := market_data.NewProvider()
+	if err != nil {
+		return nil, err
+	}
+
+	embedder, err := retrieval.NewEmbedder()
+	if err != nil {
+		return nil, err
+	}
+
+	retrieval.MaxConcurrentBatches = config.storeWorkers
+
+	splitter, err := retrieval.NewSplitter(CHUNK_SIZE, CHUNK_OVERLAP)
 	if err != nil {
 		panic(err)
 	}
 
 	return &documentFetcher{
-		db:       db,
-		embedder: embedder,
-		splitter: splitter,
-		logger:   logger,
+		db:              db,
+		embedder:        retrieval.NewLimitedEmbedder(embedder, ratelimit.NewLimiter(config.openAIRequestsPerSecond, 1)),
+		splitter:        splitter,
+		logger:          logger,
+		transcripts:     transcripts.NewProvider(),
+		quotes:          quotes,
+		filings:         sec_api.NewLimitedProvider(filings, ratelimit.NewLimiter(config.secAPIRequestsPerSecond, 1)),
+		foreignFilings:  foreignFilings,
+		fundamentals:    edgarClient,
+		pineconeLimiter: ratelimit.NewLimiter(config.pineconeRequestsPerSecond, 1),
+		config:          config,
+		secAPIMetrics:   secAPIMetrics,
 	}, nil
 }
 
-func (f *documentFetcher) run() {
+// beginIngestionRun records the start of a run. The returned function records
+// its end with the number of filings ingested and failed in the meantime.
+func (f *documentFetcher) beginIngestionRun() (finish func(), err error) {
 	logger := f.logger
-	embedder := f.embedder
-	splitter := f.splitter
-	db := f.db
 
-	fetchDocuments(db, logger, embedder, splitter)
+	run, err := models.CreateIngestionRun(f.db)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create ingestion run: %w", err)
+	}
+	f.ingestionRun = run
+
+	return func() {
+		succeeded, failed := f.succeeded.Load(), f.failed.Load()
+		logger.Infow(fmt.Sprintf("Finished ingestion run: %v filings ingested, %v failed", succeeded, failed), "ingestionRunID", run.ID)
+		if f.secAPIMetrics != nil {
+			logger.Infow(fmt.Sprintf("sec-api.io calls: %v", f.secAPIMetrics), "ingestionRunID", run.ID)
+		}
+		if err := models.FinishIngestionRun(f.db, run, int(succeeded), int(failed)); err != nil {
+			logger.Errorf("failed to finish ingestion run: %v", err)
+		}
+	}, nil
 }
 
-func fetchDocuments(db *gorm.DB, logger *zap.SugaredLogger, embedder embeddings.Embedder, splitter *retrieval.Splitter) {
+// fetchDocuments retries filings that failed on earlier runs, then lists
+// companies on all exchanges and feeds them to a pool of workers, each of which
+// processes one company at a time. At most maxCompanies companies are
+// processed, or all of them if maxCompanies is 0.
+func (f *documentFetcher) fetchDocuments(maxCompanies int) {
+	logger := f.logger
 	logger.Info("Running fetching job...")
 
-	var allListings []sec_api.Listing
+	finish, err := f.beginIngestionRun()
+	if err != nil {
+		logger.Error(err.Error())
+		return
+	}
+	defer finish()
+
+	f.retryFailedFilings()
+
+	// Listings are grouped by issuer, so that share classes of a company are
+	// processed together.
+	var allIssuers [][]sec_api.Listing
+	issuerIndex := map[string]int{}
 	// Go over all stock exchanges.
 	for _, exchange := range sec_api.StockExchanges {
 		// Get listings for the exchange.
-		listings, err := sec_api.GetTradedCompanies(SEC_API_KEY, exchange)
+		listings, err := f.filings.GetTradedCompanies(exchange)
 		if err != nil {
 			logger.Errorw(fmt.Errorf("failed to get companies traded on an exchange: %v", err).Error(), "exchange", exchange)
 			continue
 		}
 
 		for _, listing := range listings {
-			// Skip delisted companies.
-			if listing.IsDelisted {
-				logger.Infof("Skipping delisted company: %v", listing.Ticker)
-				continue
-			}
-
 			if strings.ToUpper(listing.Exchange) != strings.ToUpper(string(exchange)) {
 				logger.Infof("Skipping company on the wrong exchange: $%v (%v)", listing.Ticker, listing.Exchange)
 				continue
 			}
 
-			allListings = append(allListings, listing)
+			// Listings without a CIK are their own issuer.
+			key := "cik:" + strings.TrimLeft(listing.CIK, "0")
+			if listing.CIK == "" {
+				key = "ticker:" + strings.ToUpper(listing.Ticker)
+			}
+
+			if i, ok := issuerIndex[key]; ok {
+				allIssuers[i] = append(allIssuers[i], listing)
+				continue
+			}
+			issuerIndex[key] = len(allIssuers)
+			allIssuers = append(allIssuers, []sec_api.Listing{listing})
 		}
 	}
 
 	// Allow limiting the number of companies to process. Useful in staging.
-	if maxCompanies := os.Getenv("MAX_COMPANIES"); maxCompanies != "" {
-		limit, err := strconv.Atoi(maxCompanies)
-		if err != nil {
-			logger.Errorf("failed to parse MAX_COMPANIES: %w", err)
-			return
-		}
+	if maxCompanies > 0 && maxCompanies < len(allIssuers) {
+		allIssuers = allIssuers[:maxCompanies]
+	}
 
-		if limit < len(allListings) {
-			allListings = allListings[:limit]
-		}
+	issuers := make(chan []sec_api.Listing)
+	var workers sync.WaitGroup
+	for i := 0; i < f.config.listingWorkers; i++ {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			for listings := range issuers {
+				logger.Infof("Processing company: %v", listings[0].Ticker)
+
+				// Create the company if it doesn't exist, fetchDocuments
+				// documents, and store them.
+				err := f.processIssuer(listings)
+				if err != nil {
+					logger.Errorw(fmt.Errorf("failed to process a listing: %v", err).Error(), "ticker", listings[0].Ticker)
+					continue
+				}
+			}
+		}()
+	}
+
+	for _, listings := range allIssuers {
+		issuers <- listings
 	}
+	close(issuers)
+	workers.Wait()
+}
 
-	for _, listing := range allListings {
-		logger.Infof("Processing company: %v", listing.Ticker)
+// processIssuer creates a company if it doesn't exist, syncs its securities
+// with its listings, fetches documents, and stores them. listings are the
+// listings of all securities of the company.
+func (f *documentFetcher) processIssuer(listings []sec_api.Listing) error {
+	db := f.db
+	logger := f.logger
 
-		// Create the company if it doesn't exist, fetchDocuments documents, and
-		// store them.
-		err := processListing(db, logger, listing, embedder, splitter)
-		if err != nil {
-			logger.Errorw(fmt.Errorf("failed to process a listing: %v", err).Error(), "ticker", listing.Ticker)
-			continue
+	// The company is named after its first active listing.
+	listing := listings[0]
+	for _, l := range listings {
+		if !l.IsDelisted {
+			listing = l
+			break
 		}
 	}
-}
 
-// processListing creates a company if it doesn't exist, fetches documents, and
-// stores them.
-func processListing(db *gorm.DB, logger *zap.SugaredLogger, listing sec_api.Listing, embedder embeddings.Embedder, splitter *retrieval.Splitter) error {
+	listed := make([]models.ListedSecurity, len(listings))
+	for i, l := range listings {
+		listed[i] = l.Security()
+	}
+
 	// Create or get a company in a transaction.
 	var company *models.Company
 	err := db.Transaction(func(tx *gorm.DB) (err error) {
-		company, err = models.GetCompanyByTicker(tx, listing.Ticker)
+		if listing.CIK != "" {
+			company, err = models.GetCompanyByCIK(tx, listing.CIK)
+		} else {
+			company, err = models.GetCompanyByTicker(tx, listing.Ticker)
+		}
 		if err != nil {
 			company = nil
 			return err
 		}
 
+		// Keep the profile of companies we have current with the listing.
 		if company != nil {
+			if err := models.UpdateCompanyProfile(tx, company, listing.Profile()); err != nil {
+				return err
+			}
+
+			return models.SyncSecurities(tx, company, listed)
+		}
+
+		// There is nothing to fetch for a company we have never seen that is
+		// no longer listed.
+		if listing.IsDelisted {
 			return nil
 		}
 
 		logger.Infof("Creating company: %v", listing.Ticker)
-		company, err = models.CreateCompany(tx, listing.Name, listing.Ticker, listing.CIK, time.Time{})
-		return err
+		company, err = models.CreateCompany(tx, listing.Name, listing.Ticker, listing.CIK, time.Time{}, listing.Profile())
+		if err != nil {
+			return err
+		}
+
+		return models.SyncSecurities(tx, company, listed)
 	})
 	if err != nil {
 		return fmt.Errorf("could not create company for %v (%v): %w\n", listing.Name, listing.Ticker, err)
 	}
 
+	if company == nil {
+		logger.Infof("Skipping delisted company: %v", listing.Ticker)
+		return nil
+	}
+
+	// Delisted companies file no more documents.
+	if !company.Active {
+		logger.Infow(fmt.Sprintf("Skipping inactive company %v", company.Ticker), "companyID", company.ID)
+		return nil
+	}
+
 	// Initialize the vector store.
-	store, err := retrieval.NewPinecone(context.Background(), embedder, company.ID)
+	store, err := f.newStore(company.ID)
 	if err != nil {
 		panic(err)
 	}
@@ -186,158 +478,700 @@ func processListing(db *gorm.DB, logger *zap.SugaredLogger, listing sec_api.List
 	// If the company documents were fetched in the past 72 hours, don't fetch
 	// documents for the company again.
 	if !company.LastFetchedAt.IsZero() && company.LastFetchedAt.Add(72*time.Hour).After(time.Now()) {
-		logger.Infow(fmt.Sprintf("Skipping company %v because it has been fetched in the past 72 hours", listing.Ticker), "companyID", company.ID)
+		logger.Infow(fmt.Sprintf("Skipping company %v because it has been fetched in the past 72 hours", company.Ticker), "companyID", company.ID)
 		return nil
 	}
 
-	for _, filingKind := range []models.SourceKind{models.K10, models.Q10} {
+	kinds, err := f.kindsOf(company)
+	if err != nil {
+		return fmt.Errorf("failed to choose filing kinds for %v (%v): %w", company.Name, company.Ticker, err)
+	}
+
+	for _, filingKind := range kinds {
 		logger.Infof("Processing filing kind: %v", filingKind)
-		if err := processFilingKind(db, logger, company, splitter, store, filingKind); err != nil {
+		if err := f.processFilingKind(company, store, filingKind); err != nil {
 			logger.Errorw(fmt.Errorf("failed to process a filing kind for a company: %v", err).Error(), "companyID", company.ID, "filingKind", filingKind)
 			continue
 		}
 	}
 
-	realStonks := real_stonks.RealStonks{}
-	marketInformation, err := realStonks.GetMarketData(company.Ticker)
-	if err == nil {
-		company.Currency = marketInformation.Currency
-		company.Price = marketInformation.Price
-		company.Change = marketInformation.Change
-		company.TotalVolume = marketInformation.TotalVolume
-
-		tx := db.Save(company)
-		if tx.Error != nil {
-			logger.Infof("Unable to update market data for %v: %v", listing.Ticker, tx.Error)
+	// Transcripts are optional and only fetched when a provider is configured.
+	if f.transcripts != nil {
+		logger.Infof("Processing transcripts")
+		if err := f.processTranscripts(company, store); err != nil {
+			logger.Errorw(fmt.Errorf("failed to process transcripts for a company: %v", err).Error(), "companyID", company.ID)
 		}
+	}
 
-	} else {
-		logger.Infof("Unable to fetchDocuments market data for %v", listing.Ticker)
+	if _, err := market_data.UpdateCompanyQuote(db, f.quotes, company); err != nil {
+		logger.Infof("Unable to update market data for %v: %v", company.Ticker, err)
+	} else if err := alerts.EvaluateQuote(db, company); err != nil {
+		logger.Errorw(fmt.Errorf("failed to evaluate price alerts: %v", err).Error(), "companyID", company.ID)
+	}
+
+	if f.fundamentals != nil {
+		if err := f.processFundamentals(company); err != nil {
+			logger.Errorw(fmt.Errorf("failed to process fundamentals for a company: %v", err).Error(), "companyID", company.ID)
+		}
 	}
 
 	return nil
 }
 
+// processFundamentals fetches the fundamentals of a company if it filed a
+// 10-K or 10-Q since the latest filing they report, and refreshes its
+// valuation.
+func (f *documentFetcher) processFundamentals(company *models.Company) error {
+	db := f.db
+
+	fundamentals, err := models.GetFundamentals(db, company.ID)
+	if err != nil {
+		return err
+	}
+
+	// Amendments are left out. Many, such as those that only add Part III,
+	// carry no financial facts, so fundamentals would never catch up with
+	// them and would be fetched again on every run.
+	document, err := models.GetLatestCompanyDocumentOfKinds(db, company.ID, []models.SourceKind{models.K10, models.Q10})
+	if err != nil {
+		return err
+	}
+
+	// Only domestic filers report in US GAAP.
+	if document == nil {
+		return nil
+	}
+
+	// Fundamentals record the date a filing was filed, not the time.
+	if fundamentals == nil || document.FiledAt.After(fundamentals.FiledAt.AddDate(0, 0, 1)) {
+		f.logger.Infof("Fetching fundamentals of %v", company.Ticker)
+		latest, err := f.fundamentals.GetFundamentals(company.CIK)
+		if err != nil {
+			return err
+		}
+
+		latest.CompanyID = company.ID
+		if err := models.SaveFundamentals(db, latest); err != nil {
+			return err
+		}
+	}
+
+	return models.RefreshValuation(db, company)
+}
+
+// kindsOf returns the filing kinds to fetch for a company. Companies file
+// either as domestic companies or as foreign private issuers, so once a company
+// has documents of one group only that group is fetched. Both are fetched
+// again if the latest document of the group is over a year and a half old, as
+// the company may have switched.
+func (f *documentFetcher) kindsOf(company *models.Company) ([]models.SourceKind, error) {
+	// Foreign filings are only fetched when they can be extracted.
+	if f.foreignFilings == nil {
+		return domesticKinds, nil
+	}
+
+	stale := time.Now().Add(-548 * 24 * time.Hour)
+	for _, group := range [][]models.SourceKind{domesticKinds, foreignKinds} {
+		document, err := models.GetLatestCompanyDocumentOfKinds(f.db, company.ID, group)
+		if err != nil {
+			return nil, err
+		}
+
+		if document != nil && document.FiledAt.After(stale) {
+			return group, nil
+		}
+	}
+
+	return filingKinds, nil
+}
+
 // processFilingKind fetches filings of a particular kind for a company,
 // processes and stores them.
-func processFilingKind(db *gorm.DB, logger *zap.SugaredLogger, company *models.Company, splitter *retrieval.Splitter, store vectorstores.VectorStore, filingKind models.SourceKind) error {
+func (f *documentFetcher) processFilingKind(company *models.Company, store vectorstores.VectorStore, filingKind models.SourceKind) error {
+	db := f.db
+	logger := f.logger
+
 	// Get the most recent document of the kind for the company.
 	document, err := models.GetCompanyDocumentsOfKindInverseChronological(db, company.ID, filingKind)
 	if err != nil {
 		return fmt.Errorf("failed to fetchDocuments most recent document for %v (%v): %w\n", company.Name, company.Ticker, err)
 	}
 
-	// Query for the last one year of documents if we have no documents for the
-	// company. Otherwise query for documents since the last document.
-	var lastFiledAt = time.Now().Add(-365 * 1 * 24 * time.Hour)
+	// Query for the lookback period of documents if we have no documents for
+	// the company. Otherwise query for documents since the last document,
+	// including the last document's filing time itself, as other filings may
+	// have been filed in the same second. Filings we already have are skipped.
+	var lastFiledAt = time.Now().Add(-f.config.lookback)
 	if document != nil {
-		lastFiledAt = document.FiledAt.Add(1 * time.Second)
+		lastFiledAt = document.FiledAt
 	} else {
 		logger.Infow(fmt.Sprintf("No documents found for %v (%v) of kind %v, fetching all documents since %v", company.Name, company.Ticker, filingKind, lastFiledAt), "companyID", company.ID, "filingKind", filingKind)
 	}
 
 	// Get filings since the last filed time.
-	filings, err := sec_api.GetFilingsSince(SEC_API_KEY, company.CIK, filingKind, lastFiledAt, MAX_FILINGS_PER_COMPANY_PER_BATCH)
+	filings, err := f.filings.GetFilingsSince(company.CIK, filingKind, lastFiledAt, f.config.batchSize)
 	if err != nil {
 		return fmt.Errorf("failed to fetchDocuments filings for %v (%v): %w\n", company.Name, company.Ticker, err)
 	}
 
-	// Process filings. We only process up to MAX_FILINGS_PER_COMPANY_PER_BATCH
-	// at a time. This guarantees that no company hogs the fetching pipeline for
-	// too long. If not all documents are fetched, next time the company is due
-	// for re-fetching we will continue where we left off by checking most
-	// recent document's filing time.
-	if len(filings) > MAX_FILINGS_PER_COMPANY_PER_BATCH {
-		logger.Infof("Company %v has %v filings, processing only %v", company.Ticker, len(filings), MAX_FILINGS_PER_COMPANY_PER_BATCH)
-		filings = filings[:MAX_FILINGS_PER_COMPANY_PER_BATCH]
+	// Process filings. We only process up to the batch size at a time. If not
+	// all documents are fetched, next time the company is due for re-fetching
+	// we will continue where we left off by checking most recent document's
+	// filing time.
+	if len(filings) > f.config.batchSize {
+		logger.Infof("Company %v has %v filings, processing only %v", company.Ticker, len(filings), f.config.batchSize)
+		filings = filings[:f.config.batchSize]
 	}
 
-	for _, filing := range filings {
-		// Process the filing in a transaction. Processing a filing is atomic
-		// and involves three things: storing the file in the DB, storing the
-		// chunks in vector store, and updating the company. If any of these
-		// suboperations fail, we revert and abort.
-		if err := db.Transaction(func(tx *gorm.DB) error {
-			if err := processFiling(tx, logger, company, splitter, store, filingKind, filing); err != nil {
-				return fmt.Errorf("failed to process a filing with accession number %v: %v", filing.AccessionNo, err.Error())
-			}
+	pending, err := f.getPending(filings)
+	if err != nil {
+		return fmt.Errorf("failed to check ingested filings for %v (%v): %w", company.Name, company.Ticker, err)
+	}
 
-			// Update the company's last fetched time after successfully
-			// processing a filing for it.
-			company.LastFetchedAt = time.Now()
-			logger.Infof("Updating company %v (%v) last fetched time to %v", company.Name, company.Ticker, company.LastFetchedAt)
-			err = tx.Save(&company).Error
-			if err != nil {
-				return fmt.Errorf("failed to update company for %v (%v): %w\n", company.Name, company.Ticker, err)
-			}
+	return f.ingestFilings(company, store, filingKind, pending)
+}
 
-			return nil
-		}); err != nil {
-			return fmt.Errorf("failed to process a filing for a company: %v", err.Error())
+// ingestFilings ingests filings of a kind of a company. The filings are
+// expected to be filtered with getPending.
+func (f *documentFetcher) ingestFilings(company *models.Company, store vectorstores.VectorStore, filingKind models.SourceKind, pending []sec_api.Filing) error {
+	logger := f.logger
+
+	// Record every filing as pending first, so that the run shows what it
+	// found even for filings it does not get to.
+	for _, filing := range pending {
+		marshalledFiling, err := json.Marshal(filing)
+		if err != nil {
+			return fmt.Errorf("failed to marshal filing with accession number %v: %w", filing.AccessionNo, err)
+		}
+
+		if err := models.QueueFilingIngestion(f.db, f.ingestionRun.ID, company.ID, filing.AccessionNo, filingKind, marshalledFiling); err != nil {
+			return fmt.Errorf("failed to queue filing with accession number %v: %w", filing.AccessionNo, err)
+		}
+	}
+
+	// A failed filing does not hold up the filings after it. It is recorded
+	// and retried on a later run.
+	for _, filing := range pending {
+		if err := f.ingestFiling(company, store, filingKind, filing); err != nil {
+			logger.Errorw(err.Error(), "companyID", company.ID, "filingKind", filingKind, "accessionNo", filing.AccessionNo)
+			continue
 		}
 	}
 
 	return nil
 }
 
-// processFiling processes a filing and stores it.
-func processFiling(db *gorm.DB, logger *zap.SugaredLogger, company *models.Company, splitter *retrieval.Splitter, store vectorstores.VectorStore, filingKind models.SourceKind, filing sec_api.Filing) error {
-	var sections []models.Section
-	if filingKind == models.Q10 {
-		sections = models.Q10Sections
-	} else if filingKind == models.K10 {
-		sections = models.K10Sections
+// getPending returns the filings that are not ingested or failed yet, so that
+// reruns and overlapping windows do not ingest filings twice, and failed
+// filings are only retried on their backoff schedule by retryFailedFilings.
+func (f *documentFetcher) getPending(filings []sec_api.Filing) ([]sec_api.Filing, error) {
+	accessionNos := make([]string, len(filings))
+	for i, filing := range filings {
+		accessionNos[i] = filing.AccessionNo
 	}
 
-	var rawContent string
-	originURL := sec_api.GetFilingOriginURL(filing)
-	for _, section := range sections {
-		// Get the filing file from the SEC.
-		sectionContent, err := sec_api.ExtractSectionContent(SEC_API_KEY, originURL, section)
+	skipped, err := models.GetSkippedAccessionNos(f.db, accessionNos)
+	if err != nil {
+		return nil, err
+	}
+
+	var pending []sec_api.Filing
+	for _, filing := range filings {
+		if !skipped[filing.AccessionNo] {
+			pending = append(pending, filing)
+		}
+	}
+
+	return pending, nil
+}
+
+// newStore returns the company's vector store, rate limited.
+func (f *documentFetcher) newStore(companyID uint) (vectorstores.VectorStore, error) {
+	pinecone, err := retrieval.NewPinecone(context.Background(), f.embedder, companyID)
+	if err != nil {
+		return nil, err
+	}
+
+	return retrieval.NewLimitedStore(pinecone, f.pineconeLimiter), nil
+}
+
+// retryFailedFilings ingests filings that failed on earlier runs and are due
+// for another attempt.
+func (f *documentFetcher) retryFailedFilings() {
+	logger := f.logger
+
+	ingestions, err := models.GetRetriableFilingIngestions(f.db, MAX_RETRIES_PER_RUN)
+	if err != nil {
+		logger.Errorf("failed to get failed filings: %v", err)
+		return
+	}
+
+	for _, ingestion := range ingestions {
+		logger.Infow(fmt.Sprintf("Retrying filing with accession number %v (attempt %v)", ingestion.AccessionNo, ingestion.Attempts+1), "companyID", ingestion.CompanyID)
+
+		var filing sec_api.Filing
+		if err := json.Unmarshal(ingestion.Filing, &filing); err != nil {
+			logger.Errorw(fmt.Errorf("failed to unmarshal filing: %v", err).Error(), "accessionNo", ingestion.AccessionNo)
+			continue
+		}
+
+		store, err := f.newStore(ingestion.CompanyID)
 		if err != nil {
-			return fmt.Errorf("failed to fetchDocuments filing file (accession number %v) for %v (%v): %w\n", filing.AccessionNo, company.Name, company.Ticker, err)
+			logger.Errorw(fmt.Errorf("failed to create vector store: %v", err).Error(), "companyID", ingestion.CompanyID)
+			continue
+		}
+
+		company := ingestion.Company
+		if err := f.ingestFiling(&company, store, ingestion.Kind, filing); err != nil {
+			logger.Errorw(err.Error(), "companyID", company.ID, "filingKind", ingestion.Kind, "accessionNo", filing.AccessionNo)
 		}
+	}
+}
 
-		if sectionContent != "" {
-			rawContent += "\n\n" + sectionContent
+// ingestFiling processes a filing and records the attempt, so that the filing
+// can be retried on a later run if it fails.
+func (f *documentFetcher) ingestFiling(company *models.Company, store vectorstores.VectorStore, filingKind models.SourceKind, filing sec_api.Filing) error {
+	logger := f.logger
+
+	marshalledFiling, err := json.Marshal(filing)
+	if err != nil {
+		return fmt.Errorf("failed to marshal filing with accession number %v: %w", filing.AccessionNo, err)
+	}
+
+	ingestion, err := models.BeginFilingIngestion(f.db, f.ingestionRun.ID, company.ID, filing.AccessionNo, filingKind, marshalledFiling)
+	if err != nil {
+		return fmt.Errorf("failed to record ingestion of filing with accession number %v: %w", filing.AccessionNo, err)
+	}
+
+	fail := func(err error) error {
+		f.failed.Add(1)
+		if err := models.FailFilingIngestion(f.db, ingestion, err); err != nil {
+			logger.Errorw(fmt.Errorf("failed to record failed ingestion: %v", err).Error(), "accessionNo", filing.AccessionNo)
 		}
+
+		return fmt.Errorf("failed to process a filing for a company: %v", err.Error())
+	}
+
+	// Fetch and split the filing before touching the DB, so that no
+	// transaction is held open while waiting on the SEC.
+	prepared, err := f.prepareFiling(company, filingKind, filing)
+	if err != nil {
+		return fail(fmt.Errorf("failed to process a filing with accession number %v: %v", filing.AccessionNo, err.Error()))
+	}
+
+	// Store the filing in a transaction. Storing a filing is atomic and
+	// involves storing the document and its sections, and updating the
+	// company. If any of these suboperations fail, we revert and abort.
+	var document *models.Document
+	var created bool
+	err = f.db.Transaction(func(tx *gorm.DB) (err error) {
+		if prepared != nil {
+			document, created, err = storeFiling(tx, logger, company, filingKind, filing, prepared)
+			if err != nil {
+				return fmt.Errorf("failed to process a filing with accession number %v: %v", filing.AccessionNo, err.Error())
+			}
+		}
+
+		// Update the company's last fetched time after successfully
+		// processing a filing for it. Only the time is written, so that
+		// market data updated meanwhile is not overwritten.
+		company.LastFetchedAt = time.Now()
+		logger.Infof("Updating company %v (%v) last fetched time to %v", company.Name, company.Ticker, company.LastFetchedAt)
+		err = tx.Model(company).Update("last_fetched_at", company.LastFetchedAt).Error
+		if err != nil {
+			return fmt.Errorf("failed to update company for %v (%v): %w\n", company.Name, company.Ticker, err)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return fail(err)
+	}
+
+	// Alerts are only evaluated for new documents. Those of an existing
+	// document were evaluated when it was created.
+	if created {
+		if err := alerts.EvaluateFiling(f.db, company, document); err != nil {
+			logger.Errorw(fmt.Errorf("failed to evaluate filing alerts: %v", err).Error(), "companyID", company.ID, "documentID", document.ID)
+		}
+	}
+
+	// Index the document once it is stored. A document stored by an earlier
+	// attempt is indexed again, as the attempt may have failed to index it.
+	var documentID *uint
+	if document != nil {
+		if err := f.indexFiling(company, store, filing, ingestion, document, prepared.chunks); err != nil {
+			return fail(fmt.Errorf("failed to process a filing with accession number %v: %v", filing.AccessionNo, err.Error()))
+		}
+		documentID = &document.ID
+	}
+
+	f.succeeded.Add(1)
+	return models.FinishFilingIngestion(f.db, ingestion, documentID)
+}
+
+// preparedFiling is a filing fetched from the SEC and ready to be stored.
+type preparedFiling struct {
+	originURL      string
+	sections       []extractedSection
+	rawContent     string
+	chunks         []schema.Document
+	filedAt        time.Time
+	periodOfReport time.Time
+}
+
+// prepareFiling fetches the sections of a filing and splits its content into
+// chunks. It returns nil if the filing has no content.
+func (f *documentFetcher) prepareFiling(company *models.Company, filingKind models.SourceKind, filing sec_api.Filing) (*preparedFiling, error) {
+	logger := f.logger
+	splitter := f.splitter
+
+	originURL := sec_api.GetFilingOriginURL(filing)
+	sections, err := f.extractSections(originURL, filingKind)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetchDocuments filing file (accession number %v) for %v (%v): %w\n", filing.AccessionNo, company.Name, company.Ticker, err)
+	}
+
+	var rawContent string
+	for _, section := range sections {
+		rawContent += "\n\n" + section.content
 	}
 
 	if rawContent == "" {
 		logger.Infow(fmt.Sprintf("failed to fetchDocuments filing file (accession number %v) for %v (%v): no content (%v)\n", filing.AccessionNo, company.Name, company.Ticker, originURL), "companyID", company.ID, "filingKind", filingKind)
-		return nil
+		return nil, nil
 	}
 
-	// Create the document.
 	filedAt, err := time.Parse(time.RFC3339, filing.FiledAt)
 	if err != nil {
-		return fmt.Errorf("failed to parse filing time (accession number %v) for %v (%v): %w\n", filing.AccessionNo, company.Name, company.Ticker, err)
+		return nil, fmt.Errorf("failed to parse filing time (accession number %v) for %v (%v): %w\n", filing.AccessionNo, company.Name, company.Ticker, err)
 	}
 
-	// Wrap document creation and semantic indexing into a single transaction.
-	if err = db.Transaction(func(tx *gorm.DB) error {
-		logger.Infof("Creating document (accession number %v) for %v (%v) filed at %v", filing.AccessionNo, company.Name, company.Ticker, filedAt)
-		document, err := models.CreateDocument(tx, company, filedAt, filingKind, originURL, rawContent)
+	var periodOfReport time.Time
+	if filing.PeriodOfReport != "" {
+		periodOfReport, err = time.Parse("2006-01-02", filing.PeriodOfReport)
 		if err != nil {
-			return fmt.Errorf("failed to create document (accession number %v) for %v (%v): %w\n", filing.AccessionNo, company.Name, company.Ticker, err)
+			return nil, fmt.Errorf("failed to parse period of report (accession number %v) for %v (%v): %w\n", filing.AccessionNo, company.Name, company.Ticker, err)
+		}
+	}
+
+	text := documentloaders.NewText(strings.NewReader(rawContent))
+	chunks, err := text.LoadAndSplit(context.Background(), splitter)
+	if err != nil {
+		return nil, fmt.Errorf("failed to split document (accession number %v) for %v (%v): %w\n", filing.AccessionNo, company.Name, company.Ticker, err)
+	}
+	for i := range chunks {
+		chunks[i].Metadata["accession_no"] = filing.AccessionNo
+	}
+
+	return &preparedFiling{
+		originURL:      originURL,
+		sections:       sections,
+		rawContent:     rawContent,
+		chunks:         chunks,
+		filedAt:        filedAt,
+		periodOfReport: periodOfReport,
+	}, nil
+}
+
+// storeFiling stores the document of a prepared filing and its sections. It
+// returns the document, and whether it was created rather than stored by an
+// earlier attempt.
+func storeFiling(db *gorm.DB, logger *zap.SugaredLogger, company *models.Company, filingKind models.SourceKind, filing sec_api.Filing, prepared *preparedFiling) (document *models.Document, created bool, err error) {
+	// A document with the accession number means an earlier attempt
+	// succeeded, e.g. when a filing is retried after its ingestion status
+	// failed to update. The unique index on the accession number guards
+	// against concurrent attempts.
+	document, err = models.GetDocumentByAccessionNo(db, filing.AccessionNo)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to get document (accession number %v) for %v (%v): %w\n", filing.AccessionNo, company.Name, company.Ticker, err)
+	} else if document != nil {
+		logger.Infow(fmt.Sprintf("Document for accession number %v already exists", filing.AccessionNo), "companyID", company.ID, "documentID", document.ID)
+		return document, false, nil
+	}
+
+	logger.Infof("Creating document (accession number %v) for %v (%v) filed at %v", filing.AccessionNo, company.Name, company.Ticker, prepared.filedAt)
+	document, err = models.CreateDocument(db, company, filing.AccessionNo, prepared.filedAt, filingKind, prepared.originURL, prepared.rawContent, prepared.periodOfReport)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to create document (accession number %v) for %v (%v): %w\n", filing.AccessionNo, company.Name, company.Ticker, err)
+	}
+
+	if err := storeSections(db, document, prepared.sections); err != nil {
+		return nil, false, fmt.Errorf("failed to store sections (accession number %v) for %v (%v): %w\n", filing.AccessionNo, company.Name, company.Ticker, err)
+	}
+
+	if filingKind.IsAmendment() {
+		if err := linkAmendment(db, logger, document); err != nil {
+			return nil, false, fmt.Errorf("failed to link amendment (accession number %v) for %v (%v): %w\n", filing.AccessionNo, company.Name, company.Ticker, err)
+		}
+	} else if err := linkEarlierAmendments(db, logger, document); err != nil {
+		return nil, false, fmt.Errorf("failed to link amendments (accession number %v) for %v (%v): %w\n", filing.AccessionNo, company.Name, company.Ticker, err)
+	}
+
+	return document, true, nil
+}
+
+// indexFiling stores the chunks of a stored filing in the vector store.
+// Chunks are tagged with the accession number, and chunks left behind by an
+// earlier attempt are deleted first.
+func (f *documentFetcher) indexFiling(company *models.Company, store vectorstores.VectorStore, filing sec_api.Filing, ingestion *models.FilingIngestion, document *models.Document, chunks []schema.Document) error {
+	if err := models.SetFilingIngestionState(f.db, ingestion, models.IngestionEmbedding); err != nil {
+		f.logger.Errorw(fmt.Errorf("failed to record ingestion state: %v", err).Error(), "accessionNo", filing.AccessionNo)
+	}
+
+	if err := f.pineconeLimiter.Wait(context.Background()); err != nil {
+		return err
+	}
+	err := retrieval.DeleteChunks(context.Background(), company.ID, map[string]interface{}{"accession_no": filing.AccessionNo})
+	if err != nil {
+		return fmt.Errorf("failed to delete stale chunks (accession number %v) for %v (%v): %w\n", filing.AccessionNo, company.Name, company.Ticker, err)
+	}
+	err = retrieval.StoreChunks(store, document.ID, chunks)
+	if err != nil {
+		return fmt.Errorf("failed to store chunks (accession number %v) for %v (%v): %w\n", filing.AccessionNo, company.Name, company.Ticker, err)
+	}
+
+	return nil
+}
+
+// extractedSection is the content of a section of a filing.
+type extractedSection struct {
+	section models.Section
+	content string
+}
+
+// extractSections gets the content of the sections of the filing of a kind at
+// originURL. Sections are extracted in parallel and returned in document order.
+// Empty sections are left out.
+func (f *documentFetcher) extractSections(originURL string, kind models.SourceKind) ([]extractedSection, error) {
+	extractor := f.filings
+	if isForeignKind(kind) {
+		if f.foreignFilings == nil {
+			return nil, fmt.Errorf("cannot extract %v filings without EDGAR_USER_AGENT", kind)
+		}
+		extractor = f.foreignFilings
+	}
+
+	sections := models.SectionsOfKind(kind)
+	contents := make([]string, len(sections))
+	var errs errgroup.Group
+	errs.SetLimit(f.config.sectionWorkers)
+	for i, section := range sections {
+		i, section := i, section
+		errs.Go(func() (err error) {
+			// Get the filing file from the SEC.
+			contents[i], err = extractor.ExtractSectionContent(originURL, section)
+			return err
+		})
+	}
+
+	if err := errs.Wait(); err != nil {
+		return nil, err
+	}
+
+	var extracted []extractedSection
+	for i, section := range sections {
+		if contents[i] != "" {
+			extracted = append(extracted, extractedSection{section: section, content: contents[i]})
 		}
+	}
+
+	return extracted, nil
+}
+
+// storeSections stores extracted sections of a document in document order.
+func storeSections(db *gorm.DB, document *models.Document, sections []extractedSection) error {
+	for i, section := range sections {
+		if _, err := models.CreateDocumentSection(db, document, section.section, i+1, section.content); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// backfillSections stores sections of documents fetched before sections were
+// persisted separately, by extracting them again from the document's origin.
+// The vector store is left untouched.
+func (f *documentFetcher) backfillSections() {
+	const BATCH_SIZE = 100
+
+	db := f.db
+	logger := f.logger
+
+	logger.Info("Running section backfill job...")
 
-		text := documentloaders.NewText(strings.NewReader(rawContent))
-		chunks, err := text.LoadAndSplit(context.Background(), splitter)
+	// Foreign filings were fetched after sections were persisted.
+	kinds := domesticKinds
+	var afterID uint
+	for {
+		documents, err := models.GetDocumentsWithoutSections(db, kinds, afterID, BATCH_SIZE)
 		if err != nil {
-			return fmt.Errorf("failed to split document (accession number %v) for %v (%v): %w\n", filing.AccessionNo, company.Name, company.Ticker, err)
+			logger.Errorf("failed to get documents without sections: %v", err)
+			return
+		}
+
+		if len(documents) == 0 {
+			return
 		}
 
-		// Store chunks in the vector store.
-		err = retrieval.StoreChunks(store, document.ID, chunks)
+		for _, document := range documents {
+			afterID = document.ID
+
+			sections, err := f.extractSections(document.OriginURL, document.Kind)
+			if err != nil {
+				logger.Errorw(fmt.Errorf("failed to extract sections: %v", err).Error(), "documentID", document.ID, "companyID", document.CompanyID)
+				continue
+			}
+
+			if len(sections) == 0 {
+				logger.Infow("No sections found", "documentID", document.ID, "companyID", document.CompanyID)
+				continue
+			}
+
+			if err := db.Transaction(func(tx *gorm.DB) error {
+				return storeSections(tx, &document, sections)
+			}); err != nil {
+				logger.Errorw(fmt.Errorf("failed to store sections: %v", err).Error(), "documentID", document.ID, "companyID", document.CompanyID)
+				continue
+			}
+
+			logger.Infow(fmt.Sprintf("Stored %v sections", len(sections)), "documentID", document.ID, "companyID", document.CompanyID)
+		}
+	}
+}
+
+// backfillAccessionNos stores accession numbers of documents fetched before
+// documents were keyed on them, by recovering them from the document's origin
+// URL. A document whose accession number is already taken is a duplicate and
+// is logged, not changed.
+func (f *documentFetcher) backfillAccessionNos() {
+	const BATCH_SIZE = 500
+
+	db := f.db
+	logger := f.logger
+
+	logger.Info("Running accession number backfill job...")
+
+	kinds := filingKinds
+	var afterID uint
+	for {
+		documents, err := models.GetDocumentsWithoutAccessionNo(db, kinds, afterID, BATCH_SIZE)
 		if err != nil {
-			return fmt.Errorf("failed to store chunks (accession number %v) for %v (%v): %w\n", filing.AccessionNo, company.Name, company.Ticker, err)
+			logger.Errorf("failed to get documents without accession numbers: %v", err)
+			return
+		}
+
+		if len(documents) == 0 {
+			return
+		}
+
+		for _, document := range documents {
+			afterID = document.ID
+
+			accessionNo, ok := sec_api.GetAccessionNoFromOriginURL(document.OriginURL)
+			if !ok {
+				logger.Infow(fmt.Sprintf("No accession number in origin URL %v", document.OriginURL), "documentID", document.ID, "companyID", document.CompanyID)
+				continue
+			}
+
+			existing, err := models.GetDocumentByAccessionNo(db, accessionNo)
+			if err != nil {
+				logger.Errorw(fmt.Errorf("failed to get document by accession number: %v", err).Error(), "documentID", document.ID, "companyID", document.CompanyID)
+				continue
+			} else if existing != nil {
+				logger.Warnw(fmt.Sprintf("Document is a duplicate of document %v (accession number %v)", existing.ID, accessionNo), "documentID", document.ID, "companyID", document.CompanyID)
+				continue
+			}
+
+			if err := models.SetDocumentAccessionNo(db, &document, accessionNo); err != nil {
+				logger.Errorw(fmt.Errorf("failed to set accession number: %v", err).Error(), "documentID", document.ID, "companyID", document.CompanyID)
+				continue
+			}
 		}
+	}
+}
+
+// linkAmendment marks the document an amendment amends as superseded by it.
+// Amendments of documents we have not fetched are stored unlinked until the
+// original is ingested.
+func linkAmendment(db *gorm.DB, logger *zap.SugaredLogger, amendment *models.Document) error {
+	original, err := models.GetAmendedDocument(db, amendment)
+	if err != nil {
+		return err
+	}
 
+	if original == nil {
+		logger.Infow("No amended document found for amendment", "documentID", amendment.ID, "companyID", amendment.CompanyID)
 		return nil
-	}); err != nil {
+	}
+
+	logger.Infow(fmt.Sprintf("Document %v supersedes document %v", amendment.ID, original.ID), "companyID", amendment.CompanyID)
+	return models.SupersedeDocument(db, original, amendment)
+}
+
+// linkEarlierAmendments links a document to its amendments that were stored
+// before it, e.g. when a backfill reaches the original after the amendment was
+// ingested. The document ends up superseded by the most recent one.
+func linkEarlierAmendments(db *gorm.DB, logger *zap.SugaredLogger, original *models.Document) error {
+	amendments, err := models.GetUnlinkedAmendments(db, original)
+	if err != nil {
 		return err
 	}
 
+	for i := range amendments {
+		logger.Infow(fmt.Sprintf("Document %v supersedes document %v", amendments[i].ID, original.ID), "companyID", original.CompanyID)
+		if err := models.SupersedeDocument(db, original, &amendments[i]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// processTranscripts fetches earnings call transcripts for a company since the
+// most recent transcript we fetched, and stores them. Uploaded transcripts do
+// not move the start forward, so that calls published after an upload of a
+// later call are still fetched, but calls that were uploaded are skipped.
+func (f *documentFetcher) processTranscripts(company *models.Company, store vectorstores.VectorStore) error {
+	db := f.db
+	logger := f.logger
+	splitter := f.splitter
+
+	document, err := models.GetLatestFetchedTranscript(db, company.ID)
+	if err != nil {
+		return fmt.Errorf("failed to fetch most recent transcript for %v (%v): %w", company.Name, company.Ticker, err)
+	}
+
+	var lastHeldAt = time.Now().Add(-365 * 1 * 24 * time.Hour)
+	if document != nil {
+		lastHeldAt = document.FiledAt
+	}
+
+	calls, err := f.transcripts.GetTranscriptsSince(company.Ticker, lastHeldAt)
+	if err != nil {
+		return fmt.Errorf("failed to fetch transcripts for %v (%v): %w", company.Name, company.Ticker, err)
+	}
+
+	for _, call := range calls {
+		if len(call.Turns) == 0 {
+			logger.Infow(fmt.Sprintf("Skipping empty transcript %q for %v (%v)", call.Title, company.Name, company.Ticker), "companyID", company.ID)
+			continue
+		}
+
+		existing, err := models.GetTranscriptHeldAt(db, company.ID, call.HeldAt)
+		if err != nil {
+			return fmt.Errorf("failed to check for existing transcript %q for %v (%v): %w", call.Title, company.Name, company.Ticker, err)
+		} else if existing != nil {
+			logger.Infow(fmt.Sprintf("Skipping transcript %q for %v (%v) we already have", call.Title, company.Name, company.Ticker), "companyID", company.ID, "documentID", existing.ID)
+			continue
+		}
+
+		logger.Infof("Creating transcript document %q for %v (%v) held at %v", call.Title, company.Name, company.Ticker, call.HeldAt)
+		if _, err := transcripts.Ingest(db, store, splitter, company, call); err != nil {
+			return fmt.Errorf("failed to ingest transcript %q for %v (%v): %w", call.Title, company.Name, company.Ticker, err)
+		}
+	}
+
 	return nil
 }
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"cofin/internal/retrieval"
+	"cofin/models"
+	"fmt"
+
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+// mergeDuplicateCIKs merges companies sharing a CIK, deletes the chunks of the
+// documents dropped as duplicates, and migrates the database, which creates
+// the unique index on CIKs. It is run once on databases from before the index,
+// while no other binary is running.
+func mergeDuplicateCIKs(db *gorm.DB, logger *zap.SugaredLogger) error {
+	logger.Info("Merging companies sharing a CIK...")
+
+	merges, err := models.MergeDuplicateCIKCompanies(db.Debug())
+	if err != nil {
+		return fmt.Errorf("failed to merge companies sharing a CIK: %w", err)
+	}
+
+	for _, merge := range merges {
+		logger.Infow("Merged company into company with the same CIK", "companyID", merge.FromID, "mergedIntoID", merge.IntoID, "cik", merge.CIK, "duplicateDocuments", len(merge.DuplicateDocumentIDs))
+		if len(merge.DuplicateDocumentIDs) == 0 {
+			continue
+		}
+
+		// The merge is committed, so chunks that fail to delete are only
+		// left behind. No document refers to them any more.
+		if err := retrieval.DeleteDocumentChunks(merge.FromID, merge.DuplicateDocumentIDs); err != nil {
+			logger.Errorw(fmt.Errorf("failed to delete chunks of duplicate documents: %v", err).Error(), "companyID", merge.FromID)
+		}
+	}
+
+	logger.Infof("Merged %v companies", len(merges))
+	return models.Migrate(db)
+}
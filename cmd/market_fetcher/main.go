@@ -2,8 +2,17 @@ package main
 
 import (
 	"cofin/core"
-	"cofin/internal/real_stonks"
+	"cofin/internal/alerts"
+	"cofin/internal/market_data"
+	"cofin/internal/ratelimit"
+	"cofin/internal/yahoo_finance"
 	"cofin/models"
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
 
 	"github.com/joho/godotenv"
 	"go.uber.org/zap"
@@ -19,40 +28,99 @@ func main() {
 		panic(err)
 	}
 
-	// auto migrate the database
-	err = db.Debug().AutoMigrate(
-		&models.User{},
-		&models.Company{},
-		&models.Document{},
-		&models.AccessToken{},
-		&models.Message{},
-	)
+	logger, err := core.NewLogger()
 	if err != nil {
 		panic(err)
 	}
 
-	fetcher, err := newMarketFetcher(db)
+	if err := models.Migrate(db); err != nil {
+		panic(err)
+	}
+
+	fetcher, err := newMarketFetcher(db, logger)
 	if err != nil {
 		panic(err)
 	}
 
-	fetcher.Run()
+	// The subcommand defaults to fetch, so that running the fetcher without
+	// arguments keeps working.
+	command, args := "fetch", os.Args[1:]
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		command, args = args[0], args[1:]
+	}
+
+	switch command {
+	case "fetch":
+		fetcher.Run()
+	case "backfill":
+		err = fetcher.runBackfill(args)
+	case "schedule":
+		err = fetcher.runSchedule(args)
+	default:
+		fmt.Fprint(os.Stderr, USAGE)
+		os.Exit(2)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
 }
 
+const USAGE = `Usage: market_fetcher [command] [flags]
+
+Commands:
+  fetch     Fetch the latest quotes and daily prices of all active companies (default)
+  backfill  Fetch daily prices of chosen companies and dates
+  schedule  Keep fetching quotes while the market is open, and daily prices after it closes
+
+Run "market_fetcher <command> -h" for the flags of a command.
+`
+
+// Days of daily prices fetched for companies that have none yet. Longer
+// histories are fetched with the backfill command.
+const PRICE_LOOKBACK_DAYS = 30
+
+// Number of companies loaded from the database and quoted at a time.
+const COMPANIES_PER_PAGE = 500
+
+// How long after the close the last quotes and daily prices of the day are
+// fetched, so that providers have settled closing prices.
+const MARKET_CLOSE_DELAY = 15 * time.Minute
+
 type marketFetcher struct {
 	db     *gorm.DB
 	logger *zap.SugaredLogger
+	quotes market_data.Provider
+	prices *yahoo_finance.YahooFinance
+	// Limits calls for daily prices together with quotes from Yahoo Finance.
+	pricesLimiter *ratelimit.Limiter
+	// Number of quote requests made at the same time.
+	workers int
 }
 
-func newMarketFetcher(db *gorm.DB) (*marketFetcher, error) {
-	logger, err := core.NewLogger()
+func newMarketFetcher(db *gorm.DB, logger *zap.SugaredLogger) (*marketFetcher, error) {
+	quotes, err := market_data.NewProvider()
+	if err != nil {
+		return nil, err
+	}
+
+	pricesLimiter, err := market_data.YahooFinanceLimiter()
+	if err != nil {
+		return nil, err
+	}
+
+	workers, err := core.GetEnvInt("MARKET_WORKERS", 4)
 	if err != nil {
 		return nil, err
 	}
 
 	return &marketFetcher{
-		db:     db,
-		logger: logger,
+		db:            db,
+		logger:        logger,
+		quotes:        quotes,
+		prices:        yahoo_finance.NewYahooFinance(),
+		pricesLimiter: pricesLimiter,
+		workers:       workers,
 	}, nil
 }
 
@@ -60,37 +128,214 @@ func (f *marketFetcher) Run() {
 	logger := f.logger
 	db := f.db
 
-	fetchMarket(db, logger)
+	fetchMarket(db, f.quotes, f.workers, logger)
+	f.fetchPrices()
 }
 
-func fetchMarket(db *gorm.DB, logger *zap.SugaredLogger) {
+// fetchMarket fetches the latest quotes of all active companies, a page of
+// companies at a time, with up to workers requests at once.
+func fetchMarket(db *gorm.DB, quotes market_data.Provider, workers int, logger *zap.SugaredLogger) {
 	logger.Info("Running fetching job...")
 
+	var updated, failed int
 	var companies []models.Company
-	result := db.Find(&companies)
+	// Delisted companies have no market data.
+	result := db.Where("active = ?", true).FindInBatches(&companies, COMPANIES_PER_PAGE, func(tx *gorm.DB, batch int) error {
+		tickers := make([]string, len(companies))
+		for i, company := range companies {
+			tickers[i] = company.Ticker
+		}
+
+		found, errs := market_data.GetQuotes(quotes, tickers, workers)
+		for i := range companies {
+			company := &companies[i]
+			quote, ok := found[strings.ToUpper(company.Ticker)]
+			if !ok {
+				logger.Errorf("Unable to fetch market data for %v: %v", company.Ticker, errs[strings.ToUpper(company.Ticker)])
+				failed++
+				continue
+			}
+
+			if err := market_data.SaveCompanyQuote(db, company, quote); err != nil {
+				logger.Errorf("Unable to update market data for %v: %v", company.Ticker, err)
+				failed++
+				continue
+			}
+			updated++
+
+			if err := alerts.EvaluateQuote(db, company); err != nil {
+				logger.Errorf("Unable to evaluate price alerts of %v: %v", company.Ticker, err)
+			}
+		}
+
+		return nil
+	})
 	if result.Error != nil {
 		logger.Errorf("Failed to fetch list of companies from database: %v", result.Error)
-		return
 	}
 
-	for _, company := range companies {
-		logger.Infof("Fetching data for %v", company.Ticker)
+	logger.Infof("Updated market data of %v companies, %v failed", updated, failed)
+}
+
+// runSchedule parses the flags of the schedule command and keeps market data
+// fresh until the process is stopped. Quotes are refreshed every interval while
+// the US market is open. Once it closes, quotes and daily prices are fetched
+// one last time, and nothing is fetched until it opens again, skipping
+// weekends and holidays.
+func (f *marketFetcher) runSchedule(args []string) error {
+	flags := flag.NewFlagSet("schedule", flag.ExitOnError)
+	interval := flags.Duration("interval", 5*time.Minute, "how often to refresh quotes while the market is open")
+	flags.Parse(args)
+
+	if *interval <= 0 {
+		return fmt.Errorf("-interval must be positive")
+	}
+
+	logger := f.logger
+
+	// Catch up on start, in case the last run was missed.
+	f.Run()
+	for {
+		now := time.Now()
+		if market_data.IsMarketOpen(now) {
+			time.Sleep(*interval)
+
+			// The last refresh of the day comes after the close.
+			if !market_data.IsMarketOpen(time.Now()) {
+				_, close, _ := market_data.MarketHours(now)
+				time.Sleep(time.Until(close.Add(MARKET_CLOSE_DELAY)))
+				f.Run()
+				continue
+			}
+
+			fetchMarket(f.db, f.quotes, f.workers, logger)
+			continue
+		}
+
+		open := market_data.NextMarketOpen(now)
+		logger.Infof("Market closed, sleeping until %v", open)
+		time.Sleep(time.Until(open))
+		fetchMarket(f.db, f.quotes, f.workers, logger)
+	}
+}
+
+// fetchPrices fetches the daily prices of active companies since their latest
+// stored day. That day is fetched again, as it may have been stored before the
+// market closed.
+func (f *marketFetcher) fetchPrices() {
+	logger := f.logger
+	logger.Info("Fetching daily prices...")
 
-		realStonks := real_stonks.RealStonks{}
-		marketInformation, err := realStonks.GetMarketData(company.Ticker)
-		if err == nil {
-			company.Currency = marketInformation.Currency
-			company.Price = marketInformation.Price
-			company.Change = marketInformation.Change
-			company.TotalVolume = marketInformation.TotalVolume
+	now := time.Now().UTC()
+	var companies []models.Company
+	result := f.db.Where("active = ?", true).FindInBatches(&companies, COMPANIES_PER_PAGE, func(tx *gorm.DB, batch int) error {
+		for i := range companies {
+			company := &companies[i]
+			from := now.AddDate(0, 0, -PRICE_LOOKBACK_DAYS)
+			latest, err := models.GetLatestPriceBarDate(f.db, company.ID)
+			if err != nil {
+				logger.Errorf("Failed to get latest price of %v: %v", company.Ticker, err)
+				continue
+			} else if latest != nil {
+				from = *latest
+			}
 
-			tx := db.Save(company)
-			if tx.Error != nil {
-				logger.Errorf("Unable to update market data for %v: %v", company.Ticker, tx.Error)
+			if err := f.fetchCompanyPrices(company, from, now); err != nil {
+				logger.Errorf("Unable to fetch daily prices for %v: %v", company.Ticker, err)
 			}
-		} else {
-			logger.Errorf("Unable to fetch market data for %v: %w", company.Ticker, err)
+		}
+
+		return nil
+	})
+	if result.Error != nil {
+		logger.Errorf("Failed to fetch list of companies from database: %v", result.Error)
+	}
+}
+
+// runBackfill parses the flags of the backfill command and fetches the daily
+// prices of the chosen companies between the chosen dates, replacing stored
+// ones.
+func (f *marketFetcher) runBackfill(args []string) error {
+	flags := flag.NewFlagSet("backfill", flag.ExitOnError)
+	tickers := flags.String("tickers", "", "comma-separated tickers of the companies to backfill, all active companies if empty")
+	from := flags.String("from", time.Now().AddDate(-5, 0, 0).Format("2006-01-02"), "first day to backfill, YYYY-MM-DD")
+	to := flags.String("to", time.Now().Format("2006-01-02"), "last day to backfill, YYYY-MM-DD")
+	flags.Parse(args)
+
+	fromDate, err := time.Parse("2006-01-02", *from)
+	if err != nil {
+		return fmt.Errorf("invalid -from: %w", err)
+	}
+	toDate, err := time.Parse("2006-01-02", *to)
+	if err != nil {
+		return fmt.Errorf("invalid -to: %w", err)
+	}
+	if toDate.Before(fromDate) {
+		return fmt.Errorf("-to is before -from")
+	}
+
+	var companies []models.Company
+	for _, ticker := range strings.Split(*tickers, ",") {
+		if ticker = strings.TrimSpace(ticker); ticker == "" {
+			continue
+		}
+
+		company, err := models.GetCompanyByTicker(f.db, ticker)
+		if err != nil {
+			return err
+		} else if company == nil {
+			return fmt.Errorf("unknown company %v", ticker)
+		}
+		companies = append(companies, *company)
+	}
+	if len(companies) > 0 {
+		return f.backfillPrices(companies, fromDate, toDate)
+	}
+
+	return f.db.Where("active = ?", true).FindInBatches(&companies, COMPANIES_PER_PAGE, func(tx *gorm.DB, batch int) error {
+		return f.backfillPrices(companies, fromDate, toDate)
+	}).Error
+}
+
+// backfillPrices fetches the daily prices of companies between from and to,
+// replacing stored ones.
+func (f *marketFetcher) backfillPrices(companies []models.Company, from, to time.Time) error {
+	for i := range companies {
+		company := &companies[i]
+		f.logger.Infof("Backfilling daily prices for %v", company.Ticker)
+		if err := f.fetchCompanyPrices(company, from, to); err != nil {
+			return fmt.Errorf("failed to backfill daily prices for %v: %w", company.Ticker, err)
+		}
+	}
+
+	return nil
+}
+
+// fetchCompanyPrices fetches and stores the daily prices of a company from from
+// to to, inclusive.
+func (f *marketFetcher) fetchCompanyPrices(company *models.Company, from, to time.Time) error {
+	if err := f.pricesLimiter.Wait(context.Background()); err != nil {
+		return err
+	}
+
+	bars, err := f.prices.GetDailyBars(company.Ticker, from, to)
+	if err != nil {
+		return err
+	}
+
+	priceBars := make([]models.PriceBar, len(bars))
+	for i, bar := range bars {
+		priceBars[i] = models.PriceBar{
+			CompanyID:     company.ID,
+			Date:          bar.Date,
+			Open:          bar.Open,
+			High:          bar.High,
+			Low:           bar.Low,
+			Close:         bar.Close,
+			AdjustedClose: bar.AdjustedClose,
+			Volume:        bar.Volume,
 		}
 	}
 
+	return models.SavePriceBars(f.db, priceBars)
 }
@@ -0,0 +1,143 @@
+package controllers
+
+import (
+	"cofin/models"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+// MAX_ALERTS is the most alerts a user can have.
+const MAX_ALERTS = 100
+
+type AlertsController struct {
+	DB     *gorm.DB
+	Logger *zap.SugaredLogger
+}
+
+func (ac AlertsController) GetAlerts(c *gin.Context) {
+	alerts, err := models.GetUserAlerts(ac.DB, CurrentUserID(c))
+	if err != nil {
+		ac.Logger.Errorf("Error querying alerts: %v", err)
+		RespondInternalErr(c)
+		return
+	}
+
+	RespondOK(c, alerts)
+}
+
+// PostAlert creates an alert of the current user. Filing alerts take an
+// optional filing kind, price alerts a direction and a price threshold, and
+// change alerts a threshold in percent. Notifications are delivered in the app
+// unless another channel is given.
+func (ac AlertsController) PostAlert(c *gin.Context) {
+	type alertParams struct {
+		CompanyID  uint                  `json:"company_id" binding:"required"`
+		Kind       models.AlertKind      `json:"kind" binding:"required,oneof=filing price change"`
+		FilingKind models.SourceKind     `json:"filing_kind"`
+		Direction  models.AlertDirection `json:"direction" binding:"omitempty,oneof=above below"`
+		Threshold  float64               `json:"threshold" binding:"gte=0"`
+		Channel    models.AlertChannel   `json:"channel" binding:"omitempty,oneof=in_app"`
+	}
+
+	var payload alertParams
+	if err := c.BindJSON(&payload); err != nil {
+		RespondBadRequestErr(c, []error{err})
+		return
+	}
+
+	alert := models.Alert{
+		UserID:    CurrentUserID(c),
+		CompanyID: payload.CompanyID,
+		Kind:      payload.Kind,
+		Channel:   payload.Channel,
+	}
+	if alert.Channel == "" {
+		alert.Channel = models.InAppChannel
+	}
+
+	switch payload.Kind {
+	case models.FilingAlert:
+		if payload.FilingKind != "" && !isFilingKind(payload.FilingKind) {
+			RespondBadRequestErr(c, []error{errors.New("Unknown filing kind")})
+			return
+		}
+		alert.FilingKind = payload.FilingKind
+	case models.PriceAlert:
+		if payload.Direction == "" || payload.Threshold <= 0 {
+			RespondBadRequestErr(c, []error{errors.New("Price alerts require a direction and a positive threshold")})
+			return
+		}
+		alert.Direction = payload.Direction
+		alert.Threshold = payload.Threshold
+	case models.ChangeAlert:
+		if payload.Threshold <= 0 {
+			RespondBadRequestErr(c, []error{errors.New("Change alerts require a positive threshold")})
+			return
+		}
+		alert.Threshold = payload.Threshold
+	}
+
+	count, err := models.CountUserAlerts(ac.DB, alert.UserID)
+	if err != nil {
+		ac.Logger.Errorf("Error counting alerts: %v", err)
+		RespondInternalErr(c)
+		return
+	} else if count >= MAX_ALERTS {
+		RespondBadRequestErr(c, []error{ErrTooManyAlerts})
+		return
+	}
+
+	company, err := models.GetCompanyByID(ac.DB, payload.CompanyID)
+	if err != nil {
+		ac.Logger.Errorf("Error querying company: %v", err)
+		RespondInternalErr(c)
+		return
+	} else if company == nil {
+		RespondCustomStatusErr(c, http.StatusNotFound, []error{ErrUnknownCompany})
+		return
+	}
+
+	if err := models.CreateAlert(ac.DB, &alert); err != nil {
+		ac.Logger.Errorf("Error creating alert: %v", err)
+		RespondInternalErr(c)
+		return
+	}
+
+	RespondOK(c, alert)
+}
+
+func (ac AlertsController) DeleteAlert(c *gin.Context) {
+	alertID, err := strconv.ParseUint(c.Param("alert_id"), 10, 32)
+	if err != nil {
+		RespondBadRequestErr(c, []error{err})
+		return
+	}
+
+	deleted, err := models.DeleteUserAlert(ac.DB, CurrentUserID(c), uint(alertID))
+	if err != nil {
+		ac.Logger.Errorf("Error deleting alert: %v", err)
+		RespondInternalErr(c)
+		return
+	} else if !deleted {
+		RespondCustomStatusErr(c, http.StatusNotFound, []error{ErrUnknownAlert})
+		return
+	}
+
+	RespondOK(c, nil)
+}
+
+// isFilingKind reports whether documents of the kind are SEC filings, which
+// the fetcher ingests as they are filed.
+func isFilingKind(kind models.SourceKind) bool {
+	switch kind.BaseKind() {
+	case models.K10, models.Q10, models.F20, models.F40, models.K6:
+		return true
+	default:
+		return false
+	}
+}
@@ -8,13 +8,34 @@ import (
 )
 
 var (
-	ErrInvalidToken   = errors.New("Invalid token")
-	ErrInternalError  = errors.New("Internal error")
-	ErrUnknownCompany = errors.New("Unknown company")
-	ErrAccessDenied   = errors.New("Access denied")
-	ErrUnpaidUser     = errors.New("Unpaid user")
-	ErrUnknownUser    = errors.New("Unknown user")
-	ErrBadInput       = errors.New("Bad input")
+	ErrInvalidToken    = errors.New("Invalid token")
+	ErrInternalError   = errors.New("Internal error")
+	ErrUnknownCompany  = errors.New("Unknown company")
+	ErrUnknownDocument = errors.New("Unknown document")
+	ErrUnknownSection  = errors.New("Unknown section")
+	ErrAccessDenied    = errors.New("Access denied")
+	ErrUnpaidUser      = errors.New("Unpaid user")
+	ErrUnknownUser     = errors.New("Unknown user")
+	ErrBadInput        = errors.New("Bad input")
+	ErrBadDateRange    = errors.New("The to date is before the from date")
+	// Documents can only be compared with documents of the same company and
+	// kind.
+	ErrIncomparableDocuments = errors.New("Incomparable documents")
+	ErrSectionTooLarge       = errors.New("Section is too large to compare")
+	ErrUnknownWatchlist      = errors.New("Unknown watchlist")
+	ErrWatchlistFull         = errors.New("Watchlist is full")
+	ErrTooManyWatchlists     = errors.New("Too many watchlists")
+	ErrUnknownAlert          = errors.New("Unknown alert")
+	ErrTooManyAlerts         = errors.New("Too many alerts")
+	ErrUnknownNotification   = errors.New("Unknown notification")
+	ErrUnknownSession        = errors.New("Unknown session")
+	ErrInsufficientScope     = errors.New("API key lacks the scope")
+	ErrUnknownAPIKey         = errors.New("Unknown API key")
+	ErrTooManyAPIKeys        = errors.New("Too many API keys")
+	ErrUnknownScope          = errors.New("Unknown scope")
+	ErrDuplicateTranscript   = errors.New("Transcript of this call already exists")
+	// Some actions require the user to have signed in recently.
+	ErrSignInRequired = errors.New("Sign in again to continue")
 )
 
 type apiResponse struct {
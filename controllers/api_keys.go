@@ -0,0 +1,112 @@
+package controllers
+
+import (
+	"cofin/models"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+// MAX_API_KEYS is the most API keys a user can have.
+const MAX_API_KEYS = 20
+
+type APIKeysController struct {
+	DB     *gorm.DB
+	Logger *zap.SugaredLogger
+}
+
+// GetAPIKeys returns the current user's API keys. The keys themselves are
+// never listed.
+func (akc APIKeysController) GetAPIKeys(c *gin.Context) {
+	apiKeys, err := models.GetUserAPIKeys(akc.DB, CurrentUserID(c))
+	if err != nil {
+		akc.Logger.Errorf("Error querying API keys: %v", err)
+		RespondInternalErr(c)
+		return
+	}
+
+	RespondOK(c, apiKeys)
+}
+
+// PostAPIKey creates an API key of the current user with the scopes, valid
+// until expires_at if given. The key is only returned in this response.
+func (akc APIKeysController) PostAPIKey(c *gin.Context) {
+	type apiKeyParams struct {
+		Name      string            `json:"name" binding:"required,max=100"`
+		Scopes    []models.APIScope `json:"scopes" binding:"required,min=1"`
+		ExpiresAt *time.Time        `json:"expires_at"`
+	}
+
+	var payload apiKeyParams
+	if err := c.BindJSON(&payload); err != nil {
+		RespondBadRequestErr(c, []error{err})
+		return
+	}
+
+	var scopes []models.APIScope
+	for _, scope := range payload.Scopes {
+		if !models.IsAPIScope(scope) {
+			RespondBadRequestErr(c, []error{ErrUnknownScope})
+			return
+		}
+		if !models.APIScopeList(scopes).Has(scope) {
+			scopes = append(scopes, scope)
+		}
+	}
+
+	if payload.ExpiresAt != nil && !payload.ExpiresAt.After(time.Now()) {
+		RespondBadRequestErr(c, []error{ErrBadInput})
+		return
+	}
+
+	count, err := models.CountUserAPIKeys(akc.DB, CurrentUserID(c))
+	if err != nil {
+		akc.Logger.Errorf("Error counting API keys: %v", err)
+		RespondInternalErr(c)
+		return
+	} else if count >= MAX_API_KEYS {
+		RespondBadRequestErr(c, []error{ErrTooManyAPIKeys})
+		return
+	}
+
+	secret, err := generateRandomString(40)
+	if err != nil {
+		akc.Logger.Errorf("Error generating API key: %v", err)
+		RespondInternalErr(c)
+		return
+	}
+
+	apiKey, err := models.CreateAPIKey(akc.DB, CurrentUserID(c), payload.Name, models.API_KEY_PREFIX+secret, scopes, payload.ExpiresAt)
+	if err != nil {
+		akc.Logger.Errorf("Error creating API key: %v", err)
+		RespondInternalErr(c)
+		return
+	}
+
+	RespondOK(c, apiKey)
+}
+
+// DeleteAPIKey revokes an API key of the current user.
+func (akc APIKeysController) DeleteAPIKey(c *gin.Context) {
+	apiKeyID, err := strconv.ParseUint(c.Param("api_key_id"), 10, 32)
+	if err != nil {
+		RespondBadRequestErr(c, []error{err})
+		return
+	}
+
+	revoked, err := models.RevokeAPIKey(akc.DB, CurrentUserID(c), uint(apiKeyID))
+	if err != nil {
+		akc.Logger.Errorf("Error revoking API key: %v", err)
+		RespondInternalErr(c)
+		return
+	} else if !revoked {
+		RespondCustomStatusErr(c, http.StatusNotFound, []error{ErrUnknownAPIKey})
+		return
+	}
+
+	RespondOK(c, nil)
+}
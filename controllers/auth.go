@@ -5,15 +5,10 @@ import (
 	"cofin/internal/google_pki"
 	"cofin/internal/stripe_api"
 	"cofin/models"
-	"crypto/rsa"
-	"encoding/base64"
-	"fmt"
+	"crypto/rand"
 	"math/big"
-	"math/rand"
-	"time"
 
 	"github.com/gin-gonic/gin"
-	"github.com/golang-jwt/jwt/v5"
 	"go.uber.org/zap"
 	"gorm.io/gorm"
 )
@@ -23,6 +18,9 @@ type AuthController struct {
 	Logger    *zap.SugaredLogger
 	StripeAPI stripe_api.StripeAPI
 	Amplitude amplitude.Amplitude
+	// Firebase ID tokens are verified with Google's keys for the project.
+	GooglePKI         *google_pki.GooglePKI
+	FirebaseProjectID string
 }
 
 func (ac AuthController) SignIn(c *gin.Context) {
@@ -36,52 +34,22 @@ func (ac AuthController) SignIn(c *gin.Context) {
 		return
 	}
 
-	token, err := jwt.Parse(payload.JWTToken, func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodRSA); !ok {
-			return nil, fmt.Errorf("Unexpected JWT signing method: %v", token.Header["alg"])
-		}
-
-		kid := token.Header["kid"].(string)
-
-		googlePki := google_pki.GooglePKI{}
-		key, err := googlePki.GetPublicKeyForKid(kid)
-		if err != nil {
-			return nil, err
-		}
-
-		n, err := base64.RawURLEncoding.DecodeString(key.N)
-		if err != nil {
-			return nil, err
-		}
-
-		e, err := base64.RawURLEncoding.DecodeString(key.E)
-		if err != nil {
-			return nil, err
-		}
-
-		modulus := new(big.Int).SetBytes(n)
-		exponent := big.NewInt(0).SetBytes(e).Uint64()
-
-		rsaPubKey := &rsa.PublicKey{
-			N: modulus,
-			E: int(exponent),
-		}
-
-		return rsaPubKey, nil
-	})
+	claims, err := ac.GooglePKI.VerifyIDToken(payload.JWTToken, ac.FirebaseProjectID)
 	if err != nil {
-		RespondBadRequestErr(c, []error{err})
+		ac.Logger.Infof("Rejected ID token: %v", err)
+		RespondBadRequestErr(c, []error{ErrInvalidToken})
 		return
 	}
+	email := claims.Email
+	fullName := claims.Name
+	firebaseSubjectID := claims.Subject
 
-	claims, ok := token.Claims.(jwt.MapClaims)
-	if !ok || !token.Valid {
-		RespondBadRequestErr(c, []error{ErrInvalidToken})
+	rawToken, err := generateRandomString(128)
+	if err != nil {
+		ac.Logger.Errorf("Error generating access token: %v", err)
+		RespondInternalErr(c)
 		return
 	}
-	email := claims["email"].(string)
-	fullName := claims["name"].(string)
-	firebaseSubjectID := claims["sub"].(string)
 
 	var user *models.User
 	var accessToken *models.AccessToken
@@ -90,7 +58,7 @@ func (ac AuthController) SignIn(c *gin.Context) {
 		if err != nil {
 			return err
 		} else if user != nil {
-			accessToken, err = models.CreateAccessToken(tx, user.ID, generateRandomString(128))
+			accessToken, err = models.CreateAccessToken(tx, user.ID, rawToken, c.Request.UserAgent(), c.ClientIP())
 			if err != nil {
 				return err
 			}
@@ -114,7 +82,7 @@ func (ac AuthController) SignIn(c *gin.Context) {
 		ac.Amplitude.TrackEvent(user.FirebaseSubjectID, "user_created", nil)
 
 		ac.Logger.Infow("Creating access token", "userID", user.ID)
-		accessToken, err = models.CreateAccessToken(tx, user.ID, generateRandomString(128))
+		accessToken, err = models.CreateAccessToken(tx, user.ID, rawToken, c.Request.UserAgent(), c.ClientIP())
 		if err != nil {
 			return err
 		}
@@ -131,14 +99,31 @@ func (ac AuthController) SignIn(c *gin.Context) {
 	RespondOK(c, accessToken)
 }
 
-func generateRandomString(l int) string {
+// SignOut revokes the access token of the request.
+func (ac AuthController) SignOut(c *gin.Context) {
+	if _, err := models.RevokeAccessToken(ac.DB, CurrentUserID(c), CurrentAccessTokenID(c)); err != nil {
+		ac.Logger.Errorf("Error revoking access token: %v", err)
+		RespondInternalErr(c)
+		return
+	}
+
+	RespondOK(c, nil)
+}
+
+// generateRandomString returns a random alphanumeric string from a
+// cryptographically secure source.
+func generateRandomString(l int) (string, error) {
 	var charset = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
-	var seededRand = rand.New(rand.NewSource(time.Now().UnixNano()))
+	max := big.NewInt(int64(len(charset)))
 
 	b := make([]byte, l)
 	for i := range b {
-		b[i] = charset[seededRand.Intn(len(charset))]
+		n, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return "", err
+		}
+		b[i] = charset[n.Int64()]
 	}
 
-	return string(b)
+	return string(b), nil
 }
@@ -4,12 +4,23 @@ import (
 	"cofin/models"
 	"net/http"
 	"strconv"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"go.uber.org/zap"
 	"gorm.io/gorm"
 )
 
+// CompanyList is a page of companies with facet counts of all matching
+// companies.
+type CompanyList struct {
+	Companies []models.Company               `json:"companies"`
+	Facets    map[string][]models.FacetCount `json:"facets"`
+}
+
+// Price bars are returned for at most this many years at a time.
+const MAX_PRICE_YEARS = 10
+
 type CompaniesController struct {
 	DB     *gorm.DB
 	Logger *zap.SugaredLogger
@@ -32,6 +43,20 @@ func (cc CompaniesController) GetCompany(c *gin.Context) {
 		return
 	}
 
+	company.Securities, err = models.GetCompanySecurities(cc.DB, company.ID)
+	if err != nil {
+		cc.Logger.Errorf("Error querying company securities: %v", err)
+		RespondInternalErr(c)
+		return
+	}
+
+	company.Valuation, err = models.GetValuation(cc.DB, company.ID)
+	if err != nil {
+		cc.Logger.Errorf("Error querying company valuation: %v", err)
+		RespondInternalErr(c)
+		return
+	}
+
 	RespondOK(c, company)
 }
 
@@ -49,6 +74,13 @@ func (cc CompaniesController) GetCompanies(c *gin.Context) {
 			return
 		}
 
+		company.Securities, err = models.GetCompanySecurities(cc.DB, company.ID)
+		if err != nil {
+			cc.Logger.Errorf("Error querying company securities: %v", err)
+			RespondInternalErr(c)
+			return
+		}
+
 		RespondOK(c, company)
 		return
 	}
@@ -65,15 +97,37 @@ func (cc CompaniesController) GetCompanies(c *gin.Context) {
 		return
 	}
 
-	query := c.Query("query")
-	companies, err := models.FindCompanies(cc.DB, query, offset, limit)
+	filter := models.CompanyFilter{
+		Query:    c.Query("query"),
+		Sector:   c.Query("sector"),
+		Industry: c.Query("industry"),
+		Exchange: c.Query("exchange"),
+		Location: c.Query("location"),
+	}
+	companies, err := models.FindCompanies(cc.DB, filter, offset, limit)
 	if err != nil {
 		cc.Logger.Errorf("Error querying companies: %w", err)
 		RespondInternalErr(c)
 		return
 	}
 
-	RespondOK(c, companies)
+	// Facet counts are opt-in, as they change the shape of the response.
+	if c.Query("facets") != "true" {
+		RespondOK(c, companies)
+		return
+	}
+
+	facets, err := models.GetCompanyFacets(cc.DB, filter)
+	if err != nil {
+		cc.Logger.Errorf("Error querying company facets: %v", err)
+		RespondInternalErr(c)
+		return
+	}
+
+	RespondOK(c, CompanyList{
+		Companies: companies,
+		Facets:    facets,
+	})
 }
 
 func (cc CompaniesController) GetCompanyDocuments(c *gin.Context) {
@@ -114,3 +168,64 @@ func (cc CompaniesController) GetCompanyDocuments(c *gin.Context) {
 
 	RespondOK(c, documents)
 }
+
+// GetCompanyPrices returns the price bars of a company between the from and to
+// dates, inclusive, of the given interval: day, week or month. By default, it
+// returns daily bars of the past year. Ranges longer than MAX_PRICE_YEARS years
+// are cut to the years before the to date.
+func (cc CompaniesController) GetCompanyPrices(c *gin.Context) {
+	companyID, err := strconv.ParseUint(c.Param("company_id"), 10, 32)
+	if err != nil {
+		RespondBadRequestErr(c, []error{err})
+		return
+	}
+
+	company, err := models.GetCompanyByID(cc.DB, uint(companyID))
+	if err != nil {
+		cc.Logger.Errorf("Error querying company: %v", err)
+		RespondInternalErr(c)
+		return
+	} else if company == nil {
+		RespondCustomStatusErr(c, http.StatusNotFound, []error{ErrUnknownCompany})
+		return
+	}
+
+	to := time.Now().UTC()
+	if c.Query("to") != "" {
+		if to, err = time.Parse("2006-01-02", c.Query("to")); err != nil {
+			RespondBadRequestErr(c, []error{err})
+			return
+		}
+	}
+
+	from := to.AddDate(-1, 0, 0)
+	if c.Query("from") != "" {
+		if from, err = time.Parse("2006-01-02", c.Query("from")); err != nil {
+			RespondBadRequestErr(c, []error{err})
+			return
+		}
+	}
+
+	if to.Before(from) {
+		RespondBadRequestErr(c, []error{ErrBadDateRange})
+		return
+	}
+	if earliest := to.AddDate(-MAX_PRICE_YEARS, 0, 0); from.Before(earliest) {
+		from = earliest
+	}
+
+	interval := models.PriceInterval(c.DefaultQuery("interval", string(models.Daily)))
+	if interval != models.Daily && interval != models.Weekly && interval != models.Monthly {
+		RespondBadRequestErr(c, []error{ErrBadInput})
+		return
+	}
+
+	bars, err := models.GetPriceBars(cc.DB, company.ID, from, to)
+	if err != nil {
+		cc.Logger.Errorf("Error querying company prices: %v", err)
+		RespondInternalErr(c)
+		return
+	}
+
+	RespondOK(c, models.AggregatePriceBars(bars, interval))
+}
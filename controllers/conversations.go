@@ -2,8 +2,10 @@ package controllers
 
 import (
 	"cofin/internal/amplitude"
+	"cofin/internal/diff"
 	"cofin/internal/retrieval"
 	"cofin/models"
+	"errors"
 	"fmt"
 	"net/http"
 	"strconv"
@@ -56,6 +58,14 @@ func (cc ConversationsController) PostConversation(c *gin.Context) {
 		return
 	}
 
+	// The valuation gives the generator context on the company's price.
+	company.Valuation, err = models.GetValuation(cc.DB, company.ID)
+	if err != nil {
+		cc.Logger.Errorf("Error getting company valuation: %v", err)
+		RespondInternalErr(c)
+		return
+	}
+
 	messageHistory, err := models.GetMessagesForCompanyInverseChronological(cc.DB, user.ID, company.ID, 0, 6)
 	if err != nil {
 		cc.Logger.Errorf("Error getting messages: %w", err)
@@ -82,6 +92,15 @@ func (cc ConversationsController) PostConversation(c *gin.Context) {
 		return
 	}
 
+	// Frequent filings such as 6-Ks can crowd the latest annual report out of
+	// the most recent documents. Always offer it.
+	documents, err = withLatestAnnualDocument(cc.DB, company.ID, documents)
+	if err != nil {
+		cc.Logger.Errorf("Error getting annual document: %v", err)
+		RespondInternalErr(c)
+		return
+	}
+
 	if len(documents) == 0 {
 		var earlyResponse = "Sorry, I'm afraid no recent documents are available for this company."
 		cc.Logger.Infow(fmt.Sprintf("Early response \"%v\" for user messsage", earlyResponse), "userID", user.ID, "companyID", company.ID)
@@ -107,7 +126,7 @@ func (cc ConversationsController) PostConversation(c *gin.Context) {
 	}
 
 	documentIDs, documentList := makeDocumentList(company, documents)
-	earlyResponse, documentID, query, err := cc.Generator.CreateRetrieval(c.Request.Context(), user, company, documentIDs, documentList, conversation, userMessage.Text)
+	earlyResponse, documentID, query, diffSection, err := cc.Generator.CreateRetrieval(c.Request.Context(), user, company, documentIDs, documentList, conversation, userMessage.Text)
 	if err != nil {
 		cc.Logger.Errorf("Error creating retrieval: %w", err)
 		RespondInternalErr(c)
@@ -125,6 +144,21 @@ func (cc ConversationsController) PostConversation(c *gin.Context) {
 		RespondOK(c, aiMessage)
 		return
 	}
+	if diffSection != "" {
+		cc.Logger.Infow(fmt.Sprintf("Comparing section %v of document %v with the previous document", diffSection, documentID), "userID", user.ID, "companyID", company.ID)
+		aiMessage, err := cc.answerWithDiff(c, user, company, documentList, conversation, userMessage.Text, documentID, diffSection)
+		if err != nil {
+			cc.Logger.Errorf("Error answering with document diff: %v", err)
+			RespondInternalErr(c)
+			return
+		} else if aiMessage != nil {
+			RespondOK(c, aiMessage)
+			return
+		}
+
+		// There is nothing to compare with. Search the document instead.
+		query = userMessage.Text
+	}
 	cc.Logger.Infow(fmt.Sprintf("Created retrieval for document %v with query %v", documentID, query), "userID", user.ID, "companyID", company.ID)
 
 	document, err := models.GetDocumentByID(cc.DB, documentID)
@@ -134,6 +168,24 @@ func (cc ConversationsController) PostConversation(c *gin.Context) {
 		return
 	}
 
+	// Search superseded documents together with their amendment. Text of the
+	// original is only used where the amendment does not restate it.
+	searchedDocuments := []*models.Document{document}
+	if document.IsSuperseded() {
+		amendment, err := models.GetDocumentByID(cc.DB, *document.SupersededByID)
+		if err != nil {
+			cc.Logger.Errorf("Error getting amendment: %v", err)
+			RespondInternalErr(c)
+			return
+		}
+
+		if amendment != nil {
+			cc.Logger.Infow(fmt.Sprintf("Document %v is superseded by document %v, retrieving from both", document.ID, amendment.ID), "userID", user.ID, "companyID", company.ID)
+			searchedDocuments = []*models.Document{amendment, document}
+			document = amendment
+		}
+	}
+
 	var sources = make([]models.Source, 0, len(documents))
 	retriever, err := retrieval.NewRetriever(cc.DB, company.ID)
 	if err != nil {
@@ -141,19 +193,25 @@ func (cc ConversationsController) PostConversation(c *gin.Context) {
 		RespondInternalErr(c)
 		return
 	}
-	chunks, err := retriever.GetSemanticChunks(c.Request.Context(), company.ID, documentID, query)
+	searchedDocumentIDs := make([]uint, len(searchedDocuments))
+	for i, searchedDocument := range searchedDocuments {
+		searchedDocumentIDs[i] = searchedDocument.ID
+	}
+	chunks, err := retriever.GetSemanticChunksPreferring(c.Request.Context(), company.ID, searchedDocumentIDs, query)
 	if err != nil {
-		cc.Logger.Errorf("Error getting semantic chunks for namespace %v document %v: %w", company.ID, documentID, err)
+		cc.Logger.Errorf("Error getting semantic chunks for namespace %v documents %v: %w", company.ID, searchedDocumentIDs, err)
 		RespondInternalErr(c)
 		return
 	}
 
-	sources = append(sources, models.Source{
-		ID:        document.ID,
-		Kind:      document.Kind,
-		FiledAt:   document.FiledAt,
-		OriginURL: document.OriginURL,
-	})
+	for _, searchedDocument := range searchedDocuments {
+		sources = append(sources, models.Source{
+			ID:        searchedDocument.ID,
+			Kind:      searchedDocument.Kind,
+			FiledAt:   searchedDocument.FiledAt,
+			OriginURL: searchedDocument.OriginURL,
+		})
+	}
 
 	response, err := cc.Generator.Continue(c.Request.Context(), user, company, documentList, conversation, userMessage.Text, document, chunks)
 	if err != nil {
@@ -205,6 +263,48 @@ func (cc ConversationsController) GetConversation(c *gin.Context) {
 	})
 }
 
+// answerWithDiff answers the user's message with the changes in a section of a
+// document since the previous document of the same kind. It returns nil if
+// there is no previous document, either document lacks the section or the
+// section is too long to compare.
+func (cc ConversationsController) answerWithDiff(c *gin.Context, user *models.User, company *models.Company, documentList, conversation, lastMessage string, documentID uint, code models.Section) (*models.Message, error) {
+	document, err := models.GetDocumentByID(cc.DB, documentID)
+	if err != nil || document == nil {
+		return nil, err
+	}
+
+	previous, err := models.GetPreviousCompanyDocumentOfKind(cc.DB, document)
+	if err != nil || previous == nil {
+		return nil, err
+	}
+
+	// A section too long to compare is searched instead.
+	documentDiff, err := compareDocumentSections(cc.DB, previous, document, code)
+	if errors.Is(err, diff.ErrTooLarge) {
+		return nil, nil
+	} else if err != nil || documentDiff == nil {
+		return nil, err
+	}
+
+	response, err := cc.Generator.ContinueWithDiff(c.Request.Context(), user, company, documentList, conversation, lastMessage, document, previous, documentDiff.Title, documentDiff.Diff)
+	if err != nil {
+		return nil, err
+	}
+	cc.Logger.Infow(fmt.Sprintf("Generated response: %v", response), "userID", user.ID, "companyID", company.ID)
+
+	sources := []models.Source{}
+	for _, source := range []*models.Document{document, previous} {
+		sources = append(sources, models.Source{
+			ID:        source.ID,
+			Kind:      source.Kind,
+			FiledAt:   source.FiledAt,
+			OriginURL: source.OriginURL,
+		})
+	}
+
+	return models.CreateAIMessage(cc.DB, user.ID, company.ID, response, sources)
+}
+
 func reverseMessageArray(a []models.Message) (b []models.Message) {
 	for j := len(a) - 1; j >= 0; j-- {
 		b = append(b, a[j])
@@ -226,10 +326,43 @@ func mergeMessages(user *models.User, messages []models.Message) (conversation s
 	return conversation
 }
 
+// withLatestAnnualDocument appends the company's latest annual document to
+// documents unless they already contain an annual document.
+func withLatestAnnualDocument(db *gorm.DB, companyID uint, documents []models.Document) ([]models.Document, error) {
+	for _, document := range documents {
+		if document.Kind.IsAnnual() {
+			return documents, nil
+		}
+	}
+
+	annual, err := models.GetLatestCompanyDocumentOfKinds(db, companyID, models.AnnualKinds)
+	if err != nil || annual == nil {
+		return documents, err
+	}
+
+	return append(documents, *annual), nil
+}
+
 func makeDocumentList(company *models.Company, documents []models.Document) (documentIDs []uint, documentList string) {
 	for _, document := range documents {
 		documentIDs = append(documentIDs, document.ID)
-		documentList += fmt.Sprintf("%v: $%v %v %v\n", document.ID, company.Ticker, document.FiledAt.Format("2006-01-02"), document.Kind)
+		documentList += fmt.Sprintf("%v: $%v %v %v", document.ID, company.Ticker, document.FiledAt.Format("2006-01-02"), document.Kind)
+		// Fiscal periods do not follow filing dates, e.g. a 20-F for a fiscal
+		// year ending in March may be filed in June.
+		if !document.PeriodOfReport.IsZero() {
+			if document.Kind.IsAnnual() {
+				documentList += fmt.Sprintf(" for the fiscal year ended %v", document.PeriodOfReport.Format("2006-01-02"))
+			} else if document.Kind.IsQuarterly() {
+				documentList += fmt.Sprintf(" for the quarter ended %v", document.PeriodOfReport.Format("2006-01-02"))
+			}
+		}
+		if document.AmendsID != nil {
+			documentList += fmt.Sprintf(" (amends %v)", *document.AmendsID)
+		}
+		if document.IsSuperseded() {
+			documentList += fmt.Sprintf(" (superseded by %v)", *document.SupersededByID)
+		}
+		documentList += "\n"
 	}
 
 	return documentIDs, documentList
@@ -0,0 +1,178 @@
+package controllers
+
+import (
+	"cofin/internal/diff"
+	"cofin/models"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+type DocumentsController struct {
+	DB     *gorm.DB
+	Logger *zap.SugaredLogger
+}
+
+// DocumentDiff is how a section of a document changed since an earlier
+// document.
+type DocumentDiff struct {
+	DocumentID uint           `json:"document_id"`
+	AgainstID  uint           `json:"against_id"`
+	Section    models.Section `json:"section"`
+	Title      string         `json:"title"`
+	diff.Diff
+}
+
+func (dc DocumentsController) GetDocumentSections(c *gin.Context) {
+	document, ok := dc.getDocument(c)
+	if !ok {
+		return
+	}
+
+	sections, err := models.GetDocumentSections(dc.DB, document.ID)
+	if err != nil {
+		dc.Logger.Errorf("Error querying document sections: %v", err)
+		RespondInternalErr(c)
+		return
+	}
+
+	RespondOK(c, sections)
+}
+
+func (dc DocumentsController) GetDocumentSection(c *gin.Context) {
+	document, ok := dc.getDocument(c)
+	if !ok {
+		return
+	}
+
+	section, err := models.GetDocumentSection(dc.DB, document.ID, models.Section(c.Param("code")))
+	if err != nil {
+		dc.Logger.Errorf("Error querying document section: %v", err)
+		RespondInternalErr(c)
+		return
+	} else if section == nil {
+		RespondCustomStatusErr(c, http.StatusNotFound, []error{ErrUnknownSection})
+		return
+	}
+
+	RespondOK(c, section)
+}
+
+// GetDocumentDiff compares a section of a document with the same section of the
+// document given by the against query parameter. Without it, the document is
+// compared with the company's previous document of the same kind. Changes are
+// from the document filed earlier to the one filed later.
+func (dc DocumentsController) GetDocumentDiff(c *gin.Context) {
+	document, ok := dc.getDocument(c)
+	if !ok {
+		return
+	}
+
+	code := models.Section(c.Query("section"))
+	if code == "" {
+		RespondBadRequestErr(c, []error{ErrBadInput})
+		return
+	}
+
+	var against *models.Document
+	var err error
+	if c.Query("against") != "" {
+		againstID, parseErr := strconv.ParseUint(c.Query("against"), 10, 32)
+		if parseErr != nil {
+			RespondBadRequestErr(c, []error{parseErr})
+			return
+		}
+
+		against, err = models.GetDocumentByID(dc.DB, uint(againstID))
+	} else {
+		against, err = models.GetPreviousCompanyDocumentOfKind(dc.DB, document)
+	}
+	if err != nil {
+		dc.Logger.Errorf("Error querying document to compare with: %v", err)
+		RespondInternalErr(c)
+		return
+	} else if against == nil {
+		RespondCustomStatusErr(c, http.StatusNotFound, []error{ErrUnknownDocument})
+		return
+	}
+
+	if against.CompanyID != document.CompanyID || against.Kind.BaseKind() != document.Kind.BaseKind() {
+		RespondBadRequestErr(c, []error{ErrIncomparableDocuments})
+		return
+	}
+
+	// The document filed earlier is the old text, whichever was requested.
+	older, newer := against, document
+	if against.FiledAt.After(document.FiledAt) {
+		older, newer = document, against
+	}
+
+	documentDiff, err := compareDocumentSections(dc.DB, older, newer, code)
+	if errors.Is(err, diff.ErrTooLarge) {
+		RespondCustomStatusErr(c, http.StatusUnprocessableEntity, []error{ErrSectionTooLarge})
+		return
+	} else if err != nil {
+		dc.Logger.Errorf("Error comparing document sections: %v", err)
+		RespondInternalErr(c)
+		return
+	} else if documentDiff == nil {
+		RespondCustomStatusErr(c, http.StatusNotFound, []error{ErrUnknownSection})
+		return
+	}
+
+	RespondOK(c, documentDiff)
+}
+
+// compareDocumentSections compares a section of an older and a newer document.
+// It returns nil if either document lacks the section, and diff.ErrTooLarge if
+// the section is too long to compare.
+func compareDocumentSections(db *gorm.DB, older, newer *models.Document, code models.Section) (*DocumentDiff, error) {
+	oldSection, err := models.GetDocumentSection(db, older.ID, code)
+	if err != nil || oldSection == nil {
+		return nil, err
+	}
+
+	newSection, err := models.GetDocumentSection(db, newer.ID, code)
+	if err != nil || newSection == nil {
+		return nil, err
+	}
+
+	d, err := diff.Compare(oldSection.Content, newSection.Content)
+	if err != nil {
+		return nil, err
+	}
+
+	return &DocumentDiff{
+		DocumentID: newer.ID,
+		AgainstID:  older.ID,
+		Section:    newSection.Code,
+		Title:      newSection.Title,
+		Diff:       d,
+	}, nil
+}
+
+// getDocument loads the document named in the request path. If the document
+// cannot be loaded, an error response is sent and ok is false.
+func (dc DocumentsController) getDocument(c *gin.Context) (document *models.Document, ok bool) {
+	documentID, err := strconv.ParseUint(c.Param("document_id"), 10, 32)
+	if err != nil {
+		RespondBadRequestErr(c, []error{err})
+		return nil, false
+	}
+
+	document, err = models.GetDocumentByID(dc.DB, uint(documentID))
+	if err != nil {
+		dc.Logger.Errorf("Error querying document: %v", err)
+		RespondInternalErr(c)
+		return nil, false
+	} else if document == nil {
+		RespondCustomStatusErr(c, http.StatusNotFound, []error{ErrUnknownDocument})
+		return nil, false
+	}
+
+	return document, true
+}
@@ -0,0 +1,40 @@
+package controllers
+
+import (
+	"cofin/models"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+type IngestionsController struct {
+	DB     *gorm.DB
+	Logger *zap.SugaredLogger
+}
+
+// GetFailures returns the filings that failed to ingest, most recently failed
+// first.
+func (ic IngestionsController) GetFailures(c *gin.Context) {
+	limit, err := strconv.Atoi(c.Query("limit"))
+	if err != nil {
+		RespondBadRequestErr(c, []error{err})
+		return
+	}
+
+	offset, err := strconv.Atoi(c.Query("offset"))
+	if err != nil {
+		RespondBadRequestErr(c, []error{err})
+		return
+	}
+
+	ingestions, err := models.GetFailedFilingIngestions(ic.DB, offset, limit)
+	if err != nil {
+		ic.Logger.Errorf("Error querying failed ingestions: %v", err)
+		RespondInternalErr(c)
+		return
+	}
+
+	RespondOK(c, ingestions)
+}
@@ -4,33 +4,106 @@ import (
 	"cofin/core"
 	"cofin/models"
 	"net/http"
+	"strings"
 
 	"github.com/gin-gonic/gin"
 )
 
+// RequireAuth lets through requests with a valid access token in the
+// X-User-Token header, and renews the token. API keys are not accepted.
 func RequireAuth(c *gin.Context) {
-	token := c.GetHeader("X-User-Token")
-	if len(token) > 0 {
-
-		db, err := core.GetDB()
-		if err == nil {
-			var accessToken models.AccessToken
-			tx := db.First(&accessToken, "token = ?", token)
-			if tx.Error == nil {
-				c.Set("userID", accessToken.UserID)
-				c.Next()
-				return
+	if authenticateSession(c) {
+		c.Next()
+		return
+	}
+
+	RespondCustomStatusErr(c, http.StatusForbidden, []error{ErrAccessDenied})
+}
+
+// RequireScope lets through the requests RequireAuth does, and requests with a
+// valid API key granted the scope in an "Authorization: Bearer" header.
+func RequireScope(scope models.APIScope) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if authenticateSession(c) {
+			c.Next()
+			return
+		}
+
+		key, ok := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+		if ok && len(key) > 0 {
+			db, err := core.GetDB()
+			if err == nil {
+				apiKey, err := models.GetValidAPIKey(db, key)
+				if err == nil && apiKey != nil {
+					if !apiKey.Scopes.Has(scope) {
+						RespondCustomStatusErr(c, http.StatusForbidden, []error{ErrInsufficientScope})
+						return
+					}
+
+					// A failure to record the use does not fail the
+					// request.
+					models.RecordAPIKeyUse(db, apiKey)
+
+					c.Set("userID", apiKey.UserID)
+					c.Set("apiKeyID", apiKey.ID)
+					c.Next()
+					return
+				}
 			}
 		}
+
+		RespondCustomStatusErr(c, http.StatusForbidden, []error{ErrAccessDenied})
 	}
+}
 
-	RespondCustomStatusErr(c, http.StatusForbidden, []error{ErrAccessDenied})
+// authenticateSession sets the user of a request with a valid access token, and
+// renews the token. It reports whether the request had one.
+func authenticateSession(c *gin.Context) bool {
+	token := c.GetHeader("X-User-Token")
+	if len(token) == 0 {
+		return false
+	}
+
+	db, err := core.GetDB()
+	if err != nil {
+		return false
+	}
+
+	accessToken, err := models.GetValidAccessToken(db, token)
+	if err != nil || accessToken == nil {
+		return false
+	}
+
+	// A token that fails to renew is still valid until it expires.
+	models.RenewAccessToken(db, accessToken)
+
+	c.Set("userID", accessToken.UserID)
+	c.Set("accessTokenID", accessToken.ID)
+	return true
+}
+
+// RequireAdmin only lets through users flagged as administrators. It must run
+// after RequireAuth.
+func RequireAdmin(c *gin.Context) {
+	user := CurrentUser(c)
+	if user == nil || !user.IsAdmin {
+		RespondCustomStatusErr(c, http.StatusForbidden, []error{ErrAccessDenied})
+		return
+	}
+
+	c.Next()
 }
 
 func CurrentUserID(c *gin.Context) uint {
 	return c.GetUint("userID")
 }
 
+// CurrentAccessTokenID returns the ID of the access token that authorized the
+// request.
+func CurrentAccessTokenID(c *gin.Context) uint {
+	return c.GetUint("accessTokenID")
+}
+
 func CurrentUser(c *gin.Context) *models.User {
 	userID := CurrentUserID(c)
 	if userID == 0 {
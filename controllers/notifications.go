@@ -0,0 +1,91 @@
+package controllers
+
+import (
+	"cofin/models"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+type NotificationsController struct {
+	DB     *gorm.DB
+	Logger *zap.SugaredLogger
+}
+
+// Notifications is a page of a user's notification inbox.
+type Notifications struct {
+	Notifications []models.Notification `json:"notifications"`
+	// Number of unread notifications in the whole inbox.
+	Unread int64 `json:"unread"`
+}
+
+// GetNotifications returns the current user's notifications, newest first.
+// With unread=true, only unread notifications are returned.
+func (nc NotificationsController) GetNotifications(c *gin.Context) {
+	limit, err := strconv.Atoi(c.Query("limit"))
+	if err != nil {
+		RespondBadRequestErr(c, []error{err})
+		return
+	}
+
+	offset, err := strconv.Atoi(c.Query("offset"))
+	if err != nil {
+		RespondBadRequestErr(c, []error{err})
+		return
+	}
+
+	userID := CurrentUserID(c)
+	notifications, err := models.GetUserNotificationsInverseChronological(nc.DB, userID, c.Query("unread") == "true", offset, limit)
+	if err != nil {
+		nc.Logger.Errorf("Error querying notifications: %v", err)
+		RespondInternalErr(c)
+		return
+	}
+
+	unread, err := models.CountUnreadNotifications(nc.DB, userID)
+	if err != nil {
+		nc.Logger.Errorf("Error counting unread notifications: %v", err)
+		RespondInternalErr(c)
+		return
+	}
+
+	RespondOK(c, Notifications{
+		Notifications: notifications,
+		Unread:        unread,
+	})
+}
+
+// PostNotificationRead marks a notification read.
+func (nc NotificationsController) PostNotificationRead(c *gin.Context) {
+	notificationID, err := strconv.ParseUint(c.Param("notification_id"), 10, 32)
+	if err != nil {
+		RespondBadRequestErr(c, []error{err})
+		return
+	}
+
+	notification, err := models.MarkNotificationRead(nc.DB, CurrentUserID(c), uint(notificationID))
+	if err != nil {
+		nc.Logger.Errorf("Error marking notification read: %v", err)
+		RespondInternalErr(c)
+		return
+	} else if notification == nil {
+		RespondCustomStatusErr(c, http.StatusNotFound, []error{ErrUnknownNotification})
+		return
+	}
+
+	RespondOK(c, notification)
+}
+
+// PostNotificationsRead marks all of the current user's notifications read.
+func (nc NotificationsController) PostNotificationsRead(c *gin.Context) {
+	if err := models.MarkAllNotificationsRead(nc.DB, CurrentUserID(c)); err != nil {
+		nc.Logger.Errorf("Error marking notifications read: %v", err)
+		RespondInternalErr(c)
+		return
+	}
+
+	RespondOK(c, nil)
+}
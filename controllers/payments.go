@@ -85,6 +85,11 @@ func (pc PaymentsController) PostEvent(c *gin.Context) {
 			return
 		}
 
+		user, ok := pc.getSubscriptionUser(c, subscription.Customer.ID)
+		if !ok {
+			return
+		}
+
 		if err := models.SetUserSubscriptionByStripeCustomerID(pc.DB, subscription.Customer.ID, true); err != nil {
 			pc.Logger.Errorf("Could not set user subscription: %v", err)
 			RespondInternalErr(c)
@@ -92,7 +97,6 @@ func (pc PaymentsController) PostEvent(c *gin.Context) {
 		}
 
 		if event.Type == "customer.subscription.created" {
-			user, _ := models.GetUserByStripeClientID(pc.DB, subscription.Customer.ID)
 			pc.Amplitude.TrackEvent(user.FirebaseSubjectID, "user_purchase_complete", nil)
 		}
 
@@ -112,6 +116,11 @@ func (pc PaymentsController) PostEvent(c *gin.Context) {
 			return
 		}
 
+		user, ok := pc.getSubscriptionUser(c, subscription.Customer.ID)
+		if !ok {
+			return
+		}
+
 		if err := models.SetUserSubscriptionByStripeCustomerID(pc.DB, subscription.Customer.ID, false); err != nil {
 			pc.Logger.Errorf("Could not set user subscription: %v", err)
 			RespondInternalErr(c)
@@ -119,7 +128,6 @@ func (pc PaymentsController) PostEvent(c *gin.Context) {
 		}
 
 		if event.Type == "customer.subscription.deleted" {
-			user, _ := models.GetUserByStripeClientID(pc.DB, subscription.Customer.ID)
 			pc.Amplitude.TrackEvent(user.FirebaseSubjectID, "user_purchase_exipire", nil)
 		}
 	default:
@@ -129,6 +137,24 @@ func (pc PaymentsController) PostEvent(c *gin.Context) {
 	RespondOK(c, nil)
 }
 
+// getSubscriptionUser loads the user of a Stripe customer a subscription event
+// is about. Customers without a user, e.g. of deleted accounts, are answered
+// with success, so that Stripe does not retry the event, and ok is false.
+func (pc PaymentsController) getSubscriptionUser(c *gin.Context, stripeCustomerID string) (user *models.User, ok bool) {
+	user, err := models.GetUserByStripeClientID(pc.DB, stripeCustomerID)
+	if err != nil {
+		pc.Logger.Errorf("Could not query user of Stripe customer: %v", err)
+		RespondInternalErr(c)
+		return nil, false
+	} else if user == nil {
+		pc.Logger.Infof("Received a webhook for Stripe customer %s without a user", stripeCustomerID)
+		RespondOK(c, nil)
+		return nil, false
+	}
+
+	return user, true
+}
+
 func (pc PaymentsController) PostBillingPortal(c *gin.Context) {
 	user := CurrentUser(c)
 	portalURL, err := pc.StripeAPI.CreatePortal(user)
@@ -0,0 +1,131 @@
+package controllers
+
+import (
+	"bytes"
+	"cofin/core"
+	"cofin/internal/stripe_api"
+	"cofin/models"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stripe/stripe-go/v74"
+	"github.com/stripe/stripe-go/v74/webhook"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+const testWebhookSecret = "whsec_test"
+
+// testDB returns a transaction on the database at TEST_DATABASE_URL that is
+// rolled back after the test. Tests needing a database are skipped without it.
+func testDB(t *testing.T) *gorm.DB {
+	t.Helper()
+
+	databaseURL := os.Getenv("TEST_DATABASE_URL")
+	if databaseURL == "" {
+		t.Skip("TEST_DATABASE_URL is not set")
+	}
+	t.Setenv("DATABASE_URL", databaseURL)
+
+	db, err := core.InitDB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := models.Migrate(db); err != nil {
+		t.Fatal(err)
+	}
+
+	tx := db.Begin()
+	t.Cleanup(func() { tx.Rollback() })
+
+	return tx
+}
+
+// postSubscriptionEvent sends a signed Stripe webhook event about a
+// subscription of a customer to the product and returns the response.
+func postSubscriptionEvent(t *testing.T, pc PaymentsController, eventType, stripeCustomerID string) *httptest.ResponseRecorder {
+	t.Helper()
+
+	payload, err := json.Marshal(map[string]interface{}{
+		"id":          "evt_test",
+		"object":      "event",
+		"api_version": stripe.APIVersion,
+		"type":        eventType,
+		"data": map[string]interface{}{
+			"object": map[string]interface{}{
+				"id":       "sub_test",
+				"object":   "subscription",
+				"customer": stripeCustomerID,
+				"items": map[string]interface{}{
+					"object": "list",
+					"data": []interface{}{
+						map[string]interface{}{
+							"id":    "si_test",
+							"price": map[string]interface{}{"id": "price_test", "product": pc.StripeAPI.ProductID},
+						},
+					},
+				},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	signed := webhook.GenerateTestSignedPayload(&webhook.UnsignedPayload{Payload: payload, Secret: testWebhookSecret})
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.POST("/payments/event", pc.PostEvent)
+
+	req := httptest.NewRequest(http.MethodPost, "/payments/event", bytes.NewReader(payload))
+	req.Header.Set("Stripe-Signature", signed.Header)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	return w
+}
+
+func TestPostEventForDeletedUser(t *testing.T) {
+	db := testDB(t)
+
+	t.Setenv("STRIPE_WEBHOOK_SIGNING_SECRET", testWebhookSecret)
+	t.Setenv("STRIPE_PRODUCT_ID", "prod_test")
+	pc := PaymentsController{DB: db, Logger: zap.NewNop().Sugar(), StripeAPI: stripe_api.NewStripeAPI()}
+
+	suffix := time.Now().UnixNano()
+	stripeCustomerID := fmt.Sprintf("cus_test_%v", suffix)
+	user, err := models.CreateUser(db, fmt.Sprintf("test-%v@cofin.ai", suffix), "Test User", stripeCustomerID, fmt.Sprintf("firebase-%v", suffix))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w := postSubscriptionEvent(t, pc, "customer.subscription.created", stripeCustomerID); w.Code != http.StatusOK {
+		t.Fatalf("subscription created = %v, want %v", w.Code, http.StatusOK)
+	}
+
+	if err := models.DeleteUserAccount(db, user); err != nil {
+		t.Fatal(err)
+	}
+
+	// Deleting the account cancels the subscription, and Stripe sends the
+	// cancellation for a customer that no longer has a user.
+	for _, eventType := range []string{"customer.subscription.deleted", "customer.subscription.paused", "customer.subscription.created"} {
+		if w := postSubscriptionEvent(t, pc, eventType, stripeCustomerID); w.Code != http.StatusOK {
+			t.Errorf("%v for deleted user = %v, want %v", eventType, w.Code, http.StatusOK)
+		}
+	}
+
+	var deleted models.User
+	if err := db.Unscoped().First(&deleted, user.ID).Error; err != nil {
+		t.Fatal(err)
+	}
+	if deleted.IsSubscribed {
+		t.Error("deleted user is subscribed")
+	}
+}
@@ -0,0 +1,196 @@
+package controllers
+
+import (
+	"cofin/internal/quote_feed"
+	"cofin/internal/websocket"
+	"cofin/models"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+// MAX_QUOTE_SUBSCRIPTIONS is the most tickers a connection can subscribe to.
+const MAX_QUOTE_SUBSCRIPTIONS = 100
+
+// QUOTE_PING_INTERVAL is how often idle connections are pinged, so that proxies
+// keep them open.
+const QUOTE_PING_INTERVAL = 30 * time.Second
+
+type QuotesController struct {
+	DB     *gorm.DB
+	Logger *zap.SugaredLogger
+	Feed   *quote_feed.Feed
+}
+
+// QuoteCommand is a message from a client, subscribing to or unsubscribing
+// from the quotes of tickers.
+type QuoteCommand struct {
+	// "subscribe" or "unsubscribe".
+	Action  string   `json:"action"`
+	Tickers []string `json:"tickers"`
+}
+
+// QuoteEvent is a message to a client: a quote, or an error in a command.
+type QuoteEvent struct {
+	// "quote" or "error".
+	Type  string              `json:"type"`
+	Quote *models.QuoteUpdate `json:"quote,omitempty"`
+	Error string              `json:"error,omitempty"`
+}
+
+// StreamQuotes upgrades the request to a WebSocket connection that pushes the
+// quotes of the tickers the client subscribes to as they are updated. Tickers
+// in the tickers query parameter, comma-separated, are subscribed to right
+// away. The current quote of each subscribed ticker is sent first.
+func (qc QuotesController) StreamQuotes(c *gin.Context) {
+	// Browsers do not apply CORS to WebSocket connections, so other sites
+	// are turned away here.
+	if origin := c.GetHeader("Origin"); origin != "" && origin != "https://"+os.Getenv("UI_DOMAIN") {
+		RespondCustomStatusErr(c, http.StatusForbidden, []error{ErrAccessDenied})
+		return
+	}
+
+	conn, err := websocket.Upgrade(c.Writer, c.Request)
+	if err != nil {
+		qc.Logger.Infof("Failed to upgrade to WebSocket: %v", err)
+		c.Abort()
+		return
+	}
+	defer conn.Close()
+
+	subscription := qc.Feed.Subscribe()
+	defer subscription.Close()
+
+	if tickers := c.Query("tickers"); tickers != "" {
+		qc.subscribe(conn, subscription, strings.Split(tickers, ","))
+	}
+
+	// Commands are read on their own goroutine, and handled on this one
+	// between updates. stopped tells the reader the connection is done.
+	commands := make(chan QuoteCommand)
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	defer close(stopped)
+	go func() {
+		defer close(done)
+		for {
+			message, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+
+			var command QuoteCommand
+			if err := json.Unmarshal(message, &command); err != nil {
+				conn.WriteJSON(QuoteEvent{Type: "error", Error: ErrBadInput.Error()})
+				continue
+			}
+			select {
+			case commands <- command:
+			case <-stopped:
+				return
+			}
+		}
+	}()
+
+	ping := time.NewTicker(QUOTE_PING_INTERVAL)
+	defer ping.Stop()
+
+	for {
+		select {
+		case command := <-commands:
+			switch command.Action {
+			case "subscribe":
+				qc.subscribe(conn, subscription, command.Tickers)
+			case "unsubscribe":
+				qc.unsubscribe(conn, subscription, command.Tickers)
+			default:
+				conn.WriteJSON(QuoteEvent{Type: "error", Error: fmt.Sprintf("Unknown action %q", command.Action)})
+			}
+		case update := <-subscription.Updates():
+			if err := conn.WriteJSON(QuoteEvent{Type: "quote", Quote: &update}); err != nil {
+				return
+			}
+		case <-ping.C:
+			if err := conn.Ping(); err != nil {
+				return
+			}
+		case <-done:
+			return
+		}
+	}
+}
+
+// subscribe subscribes to the companies of the tickers and sends their current
+// quotes. Updates are published under the companies' primary tickers, so
+// those are what is subscribed to.
+func (qc QuotesController) subscribe(conn *websocket.Conn, subscription *quote_feed.Subscription, tickers []string) {
+	companies, ok := qc.resolveTickers(conn, tickers)
+	if !ok {
+		return
+	}
+
+	if err := subscription.Add(primaryTickers(companies), MAX_QUOTE_SUBSCRIPTIONS); err != nil {
+		conn.WriteJSON(QuoteEvent{Type: "error", Error: err.Error()})
+		return
+	}
+
+	for _, company := range companies {
+		quote := models.NewQuoteUpdate(company)
+		conn.WriteJSON(QuoteEvent{Type: "quote", Quote: &quote})
+	}
+}
+
+// unsubscribe unsubscribes from the companies of the tickers. Tickers are
+// resolved as they are on subscribing, so that any ticker of a company
+// unsubscribes from it.
+func (qc QuotesController) unsubscribe(conn *websocket.Conn, subscription *quote_feed.Subscription, tickers []string) {
+	companies, ok := qc.resolveTickers(conn, tickers)
+	if !ok {
+		return
+	}
+
+	subscription.Remove(primaryTickers(companies))
+}
+
+// resolveTickers returns the companies of the tickers. Unknown tickers are
+// reported to the client and skipped. It returns false if the command fails
+// as a whole, which has been reported.
+func (qc QuotesController) resolveTickers(conn *websocket.Conn, tickers []string) ([]*models.Company, bool) {
+	if len(tickers) > MAX_QUOTE_SUBSCRIPTIONS {
+		conn.WriteJSON(QuoteEvent{Type: "error", Error: fmt.Sprintf("At most %v tickers can be subscribed to", MAX_QUOTE_SUBSCRIPTIONS)})
+		return nil, false
+	}
+
+	var companies []*models.Company
+	for _, ticker := range tickers {
+		company, err := models.GetCompanyByTicker(qc.DB, strings.TrimSpace(ticker))
+		if err != nil {
+			qc.Logger.Errorf("Error querying company by ticker: %v", err)
+			conn.WriteJSON(QuoteEvent{Type: "error", Error: ErrInternalError.Error()})
+			return nil, false
+		} else if company == nil {
+			conn.WriteJSON(QuoteEvent{Type: "error", Error: fmt.Sprintf("%v: %v", ErrUnknownCompany, ticker)})
+			continue
+		}
+
+		companies = append(companies, company)
+	}
+
+	return companies, true
+}
+
+func primaryTickers(companies []*models.Company) []string {
+	tickers := make([]string, len(companies))
+	for i, company := range companies {
+		tickers[i] = company.Ticker
+	}
+
+	return tickers
+}
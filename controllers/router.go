@@ -1,6 +1,8 @@
 package controllers
 
 import (
+	"cofin/models"
+
 	"github.com/gin-gonic/gin"
 )
 
@@ -11,6 +13,15 @@ type Router struct {
 	CompaniesController     *CompaniesController
 	PaymentsController      *PaymentsController
 	ConversationsController *ConversationsController
+	TranscriptsController   *TranscriptsController
+	DocumentsController     *DocumentsController
+	IngestionsController    *IngestionsController
+	QuotesController        *QuotesController
+	WatchlistsController    *WatchlistsController
+	AlertsController        *AlertsController
+	NotificationsController *NotificationsController
+	SessionsController      *SessionsController
+	APIKeysController       *APIKeysController
 }
 
 func (r Router) RegisterRoutes(router gin.IRouter) {
@@ -21,20 +32,67 @@ func (r Router) RegisterRoutes(router gin.IRouter) {
 	router.GET("/companies", r.CompaniesController.GetCompanies)
 	router.GET("/companies/:company_id", r.CompaniesController.GetCompany)
 	router.GET("/companies/:company_id/documents", r.CompaniesController.GetCompanyDocuments)
+	router.GET("/companies/:company_id/prices", r.CompaniesController.GetCompanyPrices)
+	router.GET("/quotes/stream", r.QuotesController.StreamQuotes)
+	router.GET("/documents/:document_id/sections", r.DocumentsController.GetDocumentSections)
+	router.GET("/documents/:document_id/sections/:code", r.DocumentsController.GetDocumentSection)
 	router.POST("/auth", r.AuthController.SignIn)
 	router.POST("/payments/webhook", r.PaymentsController.PostEvent)
 
 	//
 	// Authorized Requests
 	//
+	// Routes with a scope also accept API keys granted the scope. The others
+	// only accept signed-in sessions.
 	authorized := router.Group("/", RequireAuth)
-	authorized.GET("/users/me", r.UsersController.GetCurrentUser)
+	authorized.POST("/auth/signout", r.AuthController.SignOut)
+	router.GET("/users/me", RequireScope(models.ScopeUserRead), r.UsersController.GetCurrentUser)
+	authorized.DELETE("/users/me", r.UsersController.DeleteCurrentUser)
+	authorized.GET("/users/me/export", r.UsersController.GetExport)
+
+	authorized.GET("/users/me/sessions", r.SessionsController.GetSessions)
+	authorized.DELETE("/users/me/sessions", r.SessionsController.DeleteSessions)
+	authorized.DELETE("/users/me/sessions/:session_id", r.SessionsController.DeleteSession)
+
+	authorized.GET("/users/me/api-keys", r.APIKeysController.GetAPIKeys)
+	authorized.POST("/users/me/api-keys", r.APIKeysController.PostAPIKey)
+	authorized.DELETE("/users/me/api-keys/:api_key_id", r.APIKeysController.DeleteAPIKey)
+
+	authorized.GET("/documents/:document_id/diff", r.DocumentsController.GetDocumentDiff)
+
+	readWatchlists := RequireScope(models.ScopeWatchlistsRead)
+	writeWatchlists := RequireScope(models.ScopeWatchlistsWrite)
+	watchlists := router.Group("/users/me/watchlists")
+	watchlists.GET("", readWatchlists, r.WatchlistsController.GetWatchlists)
+	watchlists.POST("", writeWatchlists, r.WatchlistsController.PostWatchlist)
+	watchlists.GET("/:watchlist_id", readWatchlists, r.WatchlistsController.GetWatchlist)
+	watchlists.PUT("/:watchlist_id", writeWatchlists, r.WatchlistsController.PutWatchlist)
+	watchlists.DELETE("/:watchlist_id", writeWatchlists, r.WatchlistsController.DeleteWatchlist)
+	watchlists.POST("/:watchlist_id/companies", writeWatchlists, r.WatchlistsController.PostWatchlistCompany)
+	watchlists.DELETE("/:watchlist_id/companies/:company_id", writeWatchlists, r.WatchlistsController.DeleteWatchlistCompany)
 
-	conversations := authorized.Group("/conversations")
-	conversations.GET("/:company_id", r.ConversationsController.GetConversation)
-	conversations.POST("/:company_id", r.ConversationsController.PostConversation)
+	readAlerts := RequireScope(models.ScopeAlertsRead)
+	writeAlerts := RequireScope(models.ScopeAlertsWrite)
+	router.GET("/users/me/alerts", readAlerts, r.AlertsController.GetAlerts)
+	router.POST("/users/me/alerts", writeAlerts, r.AlertsController.PostAlert)
+	router.DELETE("/users/me/alerts/:alert_id", writeAlerts, r.AlertsController.DeleteAlert)
+
+	router.GET("/users/me/notifications", readAlerts, r.NotificationsController.GetNotifications)
+	router.POST("/users/me/notifications/read", writeAlerts, r.NotificationsController.PostNotificationsRead)
+	router.POST("/users/me/notifications/:notification_id/read", writeAlerts, r.NotificationsController.PostNotificationRead)
+
+	conversations := router.Group("/conversations")
+	conversations.GET("/:company_id", RequireScope(models.ScopeConversationsRead), r.ConversationsController.GetConversation)
+	conversations.POST("/:company_id", RequireScope(models.ScopeConversationsWrite), r.ConversationsController.PostConversation)
 
 	authorized.GET("/payments/prices", r.PaymentsController.GetPrices)
 	authorized.POST("/payments/checkout", r.PaymentsController.PostCheckout)
 	authorized.POST("/payments/portal", r.PaymentsController.PostBillingPortal)
+
+	//
+	// Administrator requests
+	//
+	admin := authorized.Group("/admin", RequireAdmin)
+	admin.POST("/companies/:company_id/transcripts", r.TranscriptsController.PostTranscript)
+	admin.GET("/ingestions/failures", r.IngestionsController.GetFailures)
 }
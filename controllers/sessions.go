@@ -0,0 +1,91 @@
+package controllers
+
+import (
+	"cofin/models"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+type SessionsController struct {
+	DB     *gorm.DB
+	Logger *zap.SugaredLogger
+}
+
+// Session is a signed-in client of a user, identified by its access token. The
+// token itself is never listed.
+type Session struct {
+	ID         uint      `json:"id"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastUsedAt time.Time `json:"last_used_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+	// First characters of the session's token, to tell it apart.
+	TokenPrefix string `json:"token_prefix"`
+	UserAgent   string `json:"user_agent"`
+	IPAddress   string `json:"ip_address"`
+	// Whether the session made this request.
+	Current bool `json:"current"`
+}
+
+// GetSessions returns the current user's sessions, most recently used first.
+func (sc SessionsController) GetSessions(c *gin.Context) {
+	accessTokens, err := models.GetUserAccessTokens(sc.DB, CurrentUserID(c))
+	if err != nil {
+		sc.Logger.Errorf("Error querying access tokens: %v", err)
+		RespondInternalErr(c)
+		return
+	}
+
+	sessions := make([]Session, len(accessTokens))
+	for i, accessToken := range accessTokens {
+		sessions[i] = Session{
+			ID:          accessToken.ID,
+			CreatedAt:   accessToken.CreatedAt,
+			LastUsedAt:  accessToken.LastUsedAt,
+			ExpiresAt:   accessToken.ExpiresAt,
+			TokenPrefix: accessToken.TokenPrefix,
+			UserAgent:   accessToken.UserAgent,
+			IPAddress:   accessToken.IPAddress,
+			Current:     accessToken.ID == CurrentAccessTokenID(c),
+		}
+	}
+
+	RespondOK(c, sessions)
+}
+
+// DeleteSessions signs the current user out of all sessions but the current
+// one.
+func (sc SessionsController) DeleteSessions(c *gin.Context) {
+	if _, err := models.RevokeOtherAccessTokens(sc.DB, CurrentUserID(c), CurrentAccessTokenID(c)); err != nil {
+		sc.Logger.Errorf("Error revoking access tokens: %v", err)
+		RespondInternalErr(c)
+		return
+	}
+
+	RespondOK(c, nil)
+}
+
+// DeleteSession signs the current user out of a session.
+func (sc SessionsController) DeleteSession(c *gin.Context) {
+	sessionID, err := strconv.ParseUint(c.Param("session_id"), 10, 32)
+	if err != nil {
+		RespondBadRequestErr(c, []error{err})
+		return
+	}
+
+	revoked, err := models.RevokeAccessToken(sc.DB, CurrentUserID(c), uint(sessionID))
+	if err != nil {
+		sc.Logger.Errorf("Error revoking access token: %v", err)
+		RespondInternalErr(c)
+		return
+	} else if !revoked {
+		RespondCustomStatusErr(c, http.StatusNotFound, []error{ErrUnknownSession})
+		return
+	}
+
+	RespondOK(c, nil)
+}
@@ -0,0 +1,105 @@
+package controllers
+
+import (
+	"cofin/internal/retrieval"
+	"cofin/internal/transcripts"
+	"cofin/models"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/tmc/langchaingo/embeddings"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+type TranscriptsController struct {
+	DB       *gorm.DB
+	Logger   *zap.SugaredLogger
+	Embedder embeddings.Embedder
+	Splitter *retrieval.Splitter
+}
+
+// PostTranscript uploads an earnings call transcript for a company. The
+// transcript is either plain text with one "Speaker: text" turn per line, or
+// a list of turns. Turns without a role get one inferred. A transcript of a
+// call the company already has a transcript for is rejected.
+func (tc TranscriptsController) PostTranscript(c *gin.Context) {
+	type transcriptParams struct {
+		HeldAt    time.Time          `json:"held_at" binding:"required"`
+		Title     string             `json:"title"`
+		OriginURL string             `json:"origin_url"`
+		Content   string             `json:"content"`
+		Turns     []transcripts.Turn `json:"turns" binding:"dive"`
+	}
+
+	companyID, err := strconv.ParseUint(c.Param("company_id"), 10, 32)
+	if err != nil {
+		RespondBadRequestErr(c, []error{err})
+		return
+	}
+
+	var payload transcriptParams
+	if err := c.BindJSON(&payload); err != nil {
+		RespondBadRequestErr(c, []error{err})
+		return
+	}
+
+	turns := payload.Turns
+	if len(turns) == 0 {
+		turns = transcripts.ParseTurns(payload.Content)
+	} else {
+		transcripts.InferRoles(turns)
+	}
+
+	if len(turns) == 0 {
+		RespondBadRequestErr(c, []error{errors.New("Transcript has no speaker turns")})
+		return
+	}
+
+	company, err := models.GetCompanyByID(tc.DB, uint(companyID))
+	if err != nil {
+		tc.Logger.Errorf("Error querying company: %v", err)
+		RespondInternalErr(c)
+		return
+	} else if company == nil {
+		RespondCustomStatusErr(c, http.StatusNotFound, []error{ErrUnknownCompany})
+		return
+	}
+
+	existing, err := models.GetTranscriptHeldAt(tc.DB, company.ID, payload.HeldAt)
+	if err != nil {
+		tc.Logger.Errorf("Error querying transcript: %v", err)
+		RespondInternalErr(c)
+		return
+	} else if existing != nil {
+		RespondCustomStatusErr(c, http.StatusConflict, []error{ErrDuplicateTranscript})
+		return
+	}
+
+	store, err := retrieval.NewPinecone(c.Request.Context(), tc.Embedder, company.ID)
+	if err != nil {
+		tc.Logger.Errorf("Error creating vector store: %v", err)
+		RespondInternalErr(c)
+		return
+	}
+
+	document, err := transcripts.Ingest(tc.DB, store, tc.Splitter, company, transcripts.Transcript{
+		Ticker:    company.Ticker,
+		Title:     payload.Title,
+		HeldAt:    payload.HeldAt,
+		OriginURL: payload.OriginURL,
+		Turns:     turns,
+		Uploaded:  true,
+	})
+	if err != nil {
+		tc.Logger.Errorf("Error ingesting transcript: %v", err)
+		RespondInternalErr(c)
+		return
+	}
+
+	tc.Logger.Infow("Ingested uploaded transcript", "companyID", company.ID, "documentID", document.ID, "userID", CurrentUserID(c))
+	RespondOK(c, document)
+}
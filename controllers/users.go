@@ -1,17 +1,28 @@
 package controllers
 
 import (
+	"archive/zip"
+	"bytes"
+	"cofin/internal/stripe_api"
 	"cofin/models"
+	"encoding/json"
+	"fmt"
 	"net/http"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"go.uber.org/zap"
 	"gorm.io/gorm"
 )
 
+// ACCOUNT_DELETION_MAX_SESSION_AGE is how recently a user must have signed in
+// to delete their account.
+const ACCOUNT_DELETION_MAX_SESSION_AGE = 10 * time.Minute
+
 type UsersController struct {
-	DB     *gorm.DB
-	Logger *zap.SugaredLogger
+	DB        *gorm.DB
+	Logger    *zap.SugaredLogger
+	StripeAPI stripe_api.StripeAPI
 }
 
 func (uc UsersController) GetCurrentUser(c *gin.Context) {
@@ -29,3 +40,179 @@ func (uc UsersController) GetCurrentUser(c *gin.Context) {
 
 	RespondOK(c, user)
 }
+
+// GetExport returns a ZIP archive of the current user's data: their profile,
+// messages with their annotations, watchlists, alerts and notifications, each
+// as a JSON file.
+func (uc UsersController) GetExport(c *gin.Context) {
+	type profile struct {
+		ID           uint      `json:"id"`
+		Email        string    `json:"email"`
+		FullName     string    `json:"full_name"`
+		IsSubscribed bool      `json:"is_subscribed"`
+		CreatedAt    time.Time `json:"created_at"`
+	}
+	type message struct {
+		ID         uint                 `json:"id"`
+		CompanyID  uint                 `json:"company_id"`
+		Author     models.MessageAuthor `json:"author"`
+		Text       string               `json:"text"`
+		Annotation models.JSON          `json:"annotation,omitempty"`
+		CreatedAt  time.Time            `json:"created_at"`
+	}
+
+	user, err := models.GetUserByID(uc.DB, CurrentUserID(c))
+	if err != nil {
+		uc.Logger.Errorf("Error querying user: %v", err)
+		RespondInternalErr(c)
+		return
+	} else if user == nil {
+		RespondCustomStatusErr(c, http.StatusNotFound, []error{ErrUnknownUser})
+		return
+	}
+
+	userMessages, err := models.GetUserMessagesChronological(uc.DB, user.ID)
+	if err != nil {
+		uc.Logger.Errorf("Error querying messages: %v", err)
+		RespondInternalErr(c)
+		return
+	}
+	messages := make([]message, len(userMessages))
+	for i, m := range userMessages {
+		messages[i] = message{
+			ID:         m.ID,
+			CompanyID:  m.CompanyID,
+			Author:     m.Author,
+			Text:       m.Text,
+			Annotation: m.Annotation,
+			CreatedAt:  m.CreatedAt,
+		}
+	}
+
+	watchlists, err := models.GetUserWatchlists(uc.DB, user.ID)
+	if err != nil {
+		uc.Logger.Errorf("Error querying watchlists: %v", err)
+		RespondInternalErr(c)
+		return
+	}
+
+	alerts, err := models.GetUserAlerts(uc.DB, user.ID)
+	if err != nil {
+		uc.Logger.Errorf("Error querying alerts: %v", err)
+		RespondInternalErr(c)
+		return
+	}
+
+	// A limit of -1 returns all notifications.
+	notifications, err := models.GetUserNotificationsInverseChronological(uc.DB, user.ID, false, 0, -1)
+	if err != nil {
+		uc.Logger.Errorf("Error querying notifications: %v", err)
+		RespondInternalErr(c)
+		return
+	}
+
+	files := []struct {
+		name string
+		data any
+	}{
+		{"profile.json", profile{
+			ID:           user.ID,
+			Email:        user.Email,
+			FullName:     user.FullName,
+			IsSubscribed: user.IsSubscribed,
+			CreatedAt:    user.CreatedAt,
+		}},
+		{"messages.json", messages},
+		{"watchlists.json", watchlists},
+		{"alerts.json", alerts},
+		{"notifications.json", notifications},
+	}
+
+	var buf bytes.Buffer
+	archive := zip.NewWriter(&buf)
+	for _, file := range files {
+		w, err := archive.Create(file.name)
+		if err == nil {
+			encoder := json.NewEncoder(w)
+			encoder.SetIndent("", "  ")
+			err = encoder.Encode(file.data)
+		}
+		if err != nil {
+			uc.Logger.Errorf("Error writing export: %v", err)
+			RespondInternalErr(c)
+			return
+		}
+	}
+	if err := archive.Close(); err != nil {
+		uc.Logger.Errorf("Error writing export: %v", err)
+		RespondInternalErr(c)
+		return
+	}
+
+	if err := models.CreateAuditEntry(uc.DB, user.ID, models.DataExportedAction); err != nil {
+		uc.Logger.Errorf("Error recording export: %v", err)
+		RespondInternalErr(c)
+		return
+	}
+
+	filename := fmt.Sprintf("cofin-export-%v.zip", time.Now().Format("2006-01-02"))
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+	c.Data(http.StatusOK, "application/zip", buf.Bytes())
+}
+
+// DeleteCurrentUser deletes the current user's account. Deletion cannot be
+// undone, so it must be confirmed in the request, from a session signed in
+// within the past ACCOUNT_DELETION_MAX_SESSION_AGE. Once the account is
+// deleted, the user's Stripe subscriptions are canceled. The Stripe customer
+// is kept for its billing history.
+func (uc UsersController) DeleteCurrentUser(c *gin.Context) {
+	type deleteParams struct {
+		Confirm bool `json:"confirm" binding:"required"`
+	}
+
+	var payload deleteParams
+	if err := c.BindJSON(&payload); err != nil {
+		RespondBadRequestErr(c, []error{err})
+		return
+	}
+
+	accessToken, err := models.GetAccessToken(uc.DB, CurrentAccessTokenID(c))
+	if err != nil {
+		uc.Logger.Errorf("Error querying access token: %v", err)
+		RespondInternalErr(c)
+		return
+	} else if accessToken == nil || time.Since(accessToken.CreatedAt) > ACCOUNT_DELETION_MAX_SESSION_AGE {
+		RespondCustomStatusErr(c, http.StatusForbidden, []error{ErrSignInRequired})
+		return
+	}
+
+	user, err := models.GetUserByID(uc.DB, CurrentUserID(c))
+	if err != nil {
+		uc.Logger.Errorf("Error querying user: %v", err)
+		RespondInternalErr(c)
+		return
+	} else if user == nil {
+		RespondCustomStatusErr(c, http.StatusNotFound, []error{ErrUnknownUser})
+		return
+	}
+
+	// The customer ID is anonymized with the account.
+	stripeCustomerID := user.StripeCustomerID
+	if err := models.DeleteUserAccount(uc.DB, user); err != nil {
+		uc.Logger.Errorf("Error deleting user: %v", err)
+		RespondInternalErr(c)
+		return
+	}
+	uc.Logger.Infow("Deleted user", "userID", user.ID)
+
+	// The account is gone either way, so a failure to cancel is left to be
+	// handled by hand rather than reported to the user. Stripe then sends a
+	// cancellation for a customer without a user, which the webhook ignores.
+	if stripeCustomerID != "" {
+		if err := uc.StripeAPI.CancelSubscriptions(stripeCustomerID); err != nil {
+			uc.Logger.Errorw(fmt.Sprintf("Error canceling Stripe subscriptions of deleted user, cancel them by hand: %v", err), "userID", user.ID, "stripeCustomerID", stripeCustomerID)
+		}
+	}
+
+	RespondOK(c, nil)
+}
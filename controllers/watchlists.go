@@ -0,0 +1,248 @@
+package controllers
+
+import (
+	"cofin/models"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+// MAX_WATCHLISTS is the most watchlists a user can have.
+const MAX_WATCHLISTS = 50
+
+// MAX_WATCHLIST_COMPANIES is the most companies a watchlist can hold.
+const MAX_WATCHLIST_COMPANIES = 200
+
+type WatchlistsController struct {
+	DB     *gorm.DB
+	Logger *zap.SugaredLogger
+}
+
+// GetWatchlists returns the current user's watchlists, with the latest price
+// data of each company and the filing time of its most recent document.
+func (wc WatchlistsController) GetWatchlists(c *gin.Context) {
+	watchlists, err := models.GetUserWatchlists(wc.DB, CurrentUserID(c))
+	if err != nil {
+		wc.Logger.Errorf("Error querying watchlists: %v", err)
+		RespondInternalErr(c)
+		return
+	}
+
+	RespondOK(c, watchlists)
+}
+
+// PostWatchlist creates a watchlist of the current user, optionally with
+// companies, in order.
+func (wc WatchlistsController) PostWatchlist(c *gin.Context) {
+	type watchlistParams struct {
+		Name       string `json:"name" binding:"required,max=100"`
+		CompanyIDs []uint `json:"company_ids"`
+	}
+
+	var payload watchlistParams
+	if err := c.BindJSON(&payload); err != nil {
+		RespondBadRequestErr(c, []error{err})
+		return
+	} else if len(payload.CompanyIDs) > MAX_WATCHLIST_COMPANIES {
+		RespondBadRequestErr(c, []error{ErrWatchlistFull})
+		return
+	}
+
+	count, err := models.CountUserWatchlists(wc.DB, CurrentUserID(c))
+	if err != nil {
+		wc.Logger.Errorf("Error counting watchlists: %v", err)
+		RespondInternalErr(c)
+		return
+	} else if count >= MAX_WATCHLISTS {
+		RespondBadRequestErr(c, []error{ErrTooManyWatchlists})
+		return
+	}
+
+	watchlist, err := models.CreateWatchlist(wc.DB, CurrentUserID(c), payload.Name, payload.CompanyIDs)
+	if errors.Is(err, models.ErrUnknownWatchlistCompanies) {
+		RespondCustomStatusErr(c, http.StatusNotFound, []error{ErrUnknownCompany})
+		return
+	} else if err != nil {
+		wc.Logger.Errorf("Error creating watchlist: %v", err)
+		RespondInternalErr(c)
+		return
+	}
+
+	RespondOK(c, watchlist)
+}
+
+func (wc WatchlistsController) GetWatchlist(c *gin.Context) {
+	watchlist, ok := wc.currentUserWatchlist(c)
+	if !ok {
+		return
+	}
+
+	RespondOK(c, watchlist)
+}
+
+// PutWatchlist renames a watchlist, replaces its companies with the given ones
+// in order, or both. Reordering a watchlist is replacing its companies.
+func (wc WatchlistsController) PutWatchlist(c *gin.Context) {
+	type watchlistParams struct {
+		Name       *string `json:"name" binding:"omitempty,min=1,max=100"`
+		CompanyIDs *[]uint `json:"company_ids"`
+	}
+
+	var payload watchlistParams
+	if err := c.BindJSON(&payload); err != nil {
+		RespondBadRequestErr(c, []error{err})
+		return
+	} else if payload.CompanyIDs != nil && len(*payload.CompanyIDs) > MAX_WATCHLIST_COMPANIES {
+		RespondBadRequestErr(c, []error{ErrWatchlistFull})
+		return
+	}
+
+	watchlist, ok := wc.currentUserWatchlist(c)
+	if !ok {
+		return
+	}
+
+	if payload.Name != nil {
+		if err := models.RenameWatchlist(wc.DB, watchlist, *payload.Name); err != nil {
+			wc.Logger.Errorf("Error renaming watchlist: %v", err)
+			RespondInternalErr(c)
+			return
+		}
+	}
+
+	if payload.CompanyIDs != nil {
+		err := models.ReplaceWatchlistEntries(wc.DB, watchlist, *payload.CompanyIDs)
+		if errors.Is(err, models.ErrUnknownWatchlistCompanies) {
+			RespondCustomStatusErr(c, http.StatusNotFound, []error{ErrUnknownCompany})
+			return
+		} else if err != nil {
+			wc.Logger.Errorf("Error replacing watchlist companies: %v", err)
+			RespondInternalErr(c)
+			return
+		}
+	}
+
+	wc.respondWatchlist(c, watchlist.ID)
+}
+
+func (wc WatchlistsController) DeleteWatchlist(c *gin.Context) {
+	watchlist, ok := wc.currentUserWatchlist(c)
+	if !ok {
+		return
+	}
+
+	if err := models.DeleteWatchlist(wc.DB, watchlist); err != nil {
+		wc.Logger.Errorf("Error deleting watchlist: %v", err)
+		RespondInternalErr(c)
+		return
+	}
+
+	RespondOK(c, nil)
+}
+
+// PostWatchlistCompany adds a company to the end of a watchlist.
+func (wc WatchlistsController) PostWatchlistCompany(c *gin.Context) {
+	type entryParams struct {
+		CompanyID uint `json:"company_id" binding:"required"`
+	}
+
+	var payload entryParams
+	if err := c.BindJSON(&payload); err != nil {
+		RespondBadRequestErr(c, []error{err})
+		return
+	}
+
+	watchlist, ok := wc.currentUserWatchlist(c)
+	if !ok {
+		return
+	}
+
+	if len(watchlist.Entries) >= MAX_WATCHLIST_COMPANIES {
+		RespondBadRequestErr(c, []error{ErrWatchlistFull})
+		return
+	}
+
+	company, err := models.GetCompanyByID(wc.DB, payload.CompanyID)
+	if err != nil {
+		wc.Logger.Errorf("Error querying company: %v", err)
+		RespondInternalErr(c)
+		return
+	} else if company == nil {
+		RespondCustomStatusErr(c, http.StatusNotFound, []error{ErrUnknownCompany})
+		return
+	}
+
+	if err := models.AddWatchlistEntry(wc.DB, watchlist, company.ID); err != nil {
+		wc.Logger.Errorf("Error adding company to watchlist: %v", err)
+		RespondInternalErr(c)
+		return
+	}
+
+	wc.respondWatchlist(c, watchlist.ID)
+}
+
+// DeleteWatchlistCompany removes a company from a watchlist.
+func (wc WatchlistsController) DeleteWatchlistCompany(c *gin.Context) {
+	companyID, err := strconv.ParseUint(c.Param("company_id"), 10, 32)
+	if err != nil {
+		RespondBadRequestErr(c, []error{err})
+		return
+	}
+
+	watchlist, ok := wc.currentUserWatchlist(c)
+	if !ok {
+		return
+	}
+
+	removed, err := models.RemoveWatchlistEntry(wc.DB, watchlist, uint(companyID))
+	if err != nil {
+		wc.Logger.Errorf("Error removing company from watchlist: %v", err)
+		RespondInternalErr(c)
+		return
+	} else if !removed {
+		RespondCustomStatusErr(c, http.StatusNotFound, []error{ErrUnknownCompany})
+		return
+	}
+
+	wc.respondWatchlist(c, watchlist.ID)
+}
+
+// currentUserWatchlist returns the watchlist of the watchlist_id parameter. If
+// the current user has no such watchlist, it responds with an error and ok is
+// false.
+func (wc WatchlistsController) currentUserWatchlist(c *gin.Context) (watchlist *models.Watchlist, ok bool) {
+	watchlistID, err := strconv.ParseUint(c.Param("watchlist_id"), 10, 32)
+	if err != nil {
+		RespondBadRequestErr(c, []error{err})
+		return nil, false
+	}
+
+	watchlist, err = models.GetUserWatchlist(wc.DB, CurrentUserID(c), uint(watchlistID))
+	if err != nil {
+		wc.Logger.Errorf("Error querying watchlist: %v", err)
+		RespondInternalErr(c)
+		return nil, false
+	} else if watchlist == nil {
+		RespondCustomStatusErr(c, http.StatusNotFound, []error{ErrUnknownWatchlist})
+		return nil, false
+	}
+
+	return watchlist, true
+}
+
+// respondWatchlist responds with the current state of a watchlist after a
+// change.
+func (wc WatchlistsController) respondWatchlist(c *gin.Context, watchlistID uint) {
+	watchlist, err := models.GetUserWatchlist(wc.DB, CurrentUserID(c), watchlistID)
+	if err != nil {
+		wc.Logger.Errorf("Error querying watchlist: %v", err)
+		RespondInternalErr(c)
+		return
+	}
+
+	RespondOK(c, watchlist)
+}
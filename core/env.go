@@ -0,0 +1,49 @@
+package core
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+)
+
+// GetEnvInt reads an integer from an environment variable. Returns fallback if
+// the variable is not set.
+func GetEnvInt(name string, fallback int) (int, error) {
+	value := os.Getenv(name)
+	if value == "" {
+		return fallback, nil
+	}
+
+	i, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("failed to parse %v: %w", name, err)
+	}
+
+	return i, nil
+}
+
+// GetEnvFloat reads a float from an environment variable. Returns fallback if
+// the variable is not set.
+func GetEnvFloat(name string, fallback float64) (float64, error) {
+	value := os.Getenv(name)
+	if value == "" {
+		return fallback, nil
+	}
+
+	f, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return 0, fmt.Errorf("failed to parse %v: %w", name, err)
+	}
+
+	return f, nil
+}
+
+// GetEnvRequired reads an environment variable that must be set.
+func GetEnvRequired(name string) (string, error) {
+	value := os.Getenv(name)
+	if value == "" {
+		return "", fmt.Errorf("%v is not set", name)
+	}
+
+	return value, nil
+}
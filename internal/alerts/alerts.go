@@ -0,0 +1,124 @@
+// Package alerts evaluates users' alerts as fetchers store filings and quotes,
+// and notifies users of the alerts that fire.
+package alerts
+
+import (
+	"cofin/models"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// FILING_MAX_AGE is how recent a filing must be to notify of. Older filings
+// are being backfilled, and are not news.
+const FILING_MAX_AGE = 7 * 24 * time.Hour
+
+// EvaluateFiling notifies users with filing alerts on the company of a new
+// document.
+func EvaluateFiling(db *gorm.DB, company *models.Company, document *models.Document) error {
+	if time.Since(document.FiledAt) > FILING_MAX_AGE {
+		return nil
+	}
+
+	alerts, err := models.GetCompanyAlertsOfKinds(db, company.ID, []models.AlertKind{models.FilingAlert})
+	if err != nil {
+		return err
+	}
+
+	var errs []error
+	for i := range alerts {
+		alert := &alerts[i]
+		if alert.FilingKind != "" && alert.FilingKind != document.Kind {
+			continue
+		}
+
+		text := fmt.Sprintf("%v (%v) filed a %v on %v.", company.Name, company.Ticker, document.Kind, document.FiledAt.Format("2006-01-02"))
+		if err := notify(db, alert, &document.ID, text); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if err := models.SetAlertLastTriggeredAt(db, alert, time.Now()); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// EvaluateQuote notifies users with price alerts on the company whose
+// condition starts to hold at the company's current quote.
+func EvaluateQuote(db *gorm.DB, company *models.Company) error {
+	alerts, err := models.GetCompanyAlertsOfKinds(db, company.ID, []models.AlertKind{models.PriceAlert, models.ChangeAlert})
+	if err != nil {
+		return err
+	}
+
+	var errs []error
+	for i := range alerts {
+		alert := &alerts[i]
+		holds, text := evaluateQuote(alert, company)
+		if holds == alert.Triggered {
+			continue
+		}
+
+		// Record that the condition holds before notifying, so that a
+		// failure does not notify twice.
+		if err := models.SetAlertTriggered(db, alert, holds, time.Now()); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if holds {
+			if err := notify(db, alert, nil, text); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// evaluateQuote reports whether the condition of a price alert holds at the
+// company's current quote, and describes it.
+func evaluateQuote(alert *models.Alert, company *models.Company) (bool, string) {
+	// A company without a quote yet has a zero price.
+	if company.Price <= 0 {
+		return false, ""
+	}
+
+	switch alert.Kind {
+	case models.PriceAlert:
+		holds := company.Price >= alert.Threshold
+		if alert.Direction == models.Below {
+			holds = company.Price <= alert.Threshold
+		}
+		return holds, fmt.Sprintf("%v (%v) is at %.2f, %v your alert at %.2f.", company.Name, company.Ticker, company.Price, alert.Direction, alert.Threshold)
+	case models.ChangeAlert:
+		direction := "up"
+		if company.Change < 0 {
+			direction = "down"
+		}
+		return math.Abs(company.Change) >= alert.Threshold, fmt.Sprintf("%v (%v) is %v %.2f%% today, at %.2f.", company.Name, company.Ticker, direction, math.Abs(company.Change), company.Price)
+	default:
+		return false, ""
+	}
+}
+
+// notify delivers a notification of an alert on its channel.
+func notify(db *gorm.DB, alert *models.Alert, documentID *uint, text string) error {
+	switch alert.Channel {
+	case models.InAppChannel:
+		return models.CreateNotification(db, &models.Notification{
+			UserID:     alert.UserID,
+			AlertID:    alert.ID,
+			CompanyID:  alert.CompanyID,
+			Kind:       alert.Kind,
+			DocumentID: documentID,
+			Text:       text,
+		})
+	default:
+		return fmt.Errorf("unknown channel %q of alert %v", alert.Channel, alert.ID)
+	}
+}
@@ -0,0 +1,260 @@
+// Package diff compares the text of a section between two filings, e.g. the
+// risk factors of two consecutive 10-Ks, paragraph by paragraph.
+package diff
+
+import (
+	"errors"
+	"strings"
+	"unicode"
+)
+
+type ChangeKind string
+
+const (
+	Added    ChangeKind = "added"
+	Removed  ChangeKind = "removed"
+	Modified ChangeKind = "modified"
+)
+
+// Paragraphs of the old and new text at least this similar are taken to be the
+// same paragraph, modified.
+const MODIFIED_SIMILARITY_THRESHOLD = 0.5
+
+// Texts with more paragraphs than this are not compared. Aligning paragraphs
+// takes time and memory proportional to the product of the paragraph counts of
+// the texts.
+const MAX_PARAGRAPHS = 2000
+
+var ErrTooLarge = errors.New("text has too many paragraphs to compare")
+
+// Change is a paragraph added, removed or modified in the new text.
+type Change struct {
+	Kind ChangeKind `json:"kind"`
+	// Old and New are the paragraph as it reads in the old and new text. Old
+	// is empty for added paragraphs, New for removed ones.
+	Old string `json:"old,omitempty"`
+	New string `json:"new,omitempty"`
+	// Position of the paragraph in the new text, or in the old text for
+	// removed paragraphs, counting from 0.
+	Position int `json:"position"`
+	// Similarity of the old and new paragraph, from 0 to 1, for modified
+	// paragraphs.
+	Similarity float64 `json:"similarity,omitempty"`
+}
+
+// Diff is the result of comparing two texts.
+type Diff struct {
+	Added     int `json:"added"`
+	Removed   int `json:"removed"`
+	Modified  int `json:"modified"`
+	Unchanged int `json:"unchanged"`
+	// Changes in document order. Removed paragraphs come before the added or
+	// modified paragraphs that follow them in the old text.
+	Changes []Change `json:"changes"`
+}
+
+// Compare aligns the paragraphs of the old and new text and classifies each as
+// unchanged, added, removed or modified. Paragraphs that read the same are
+// aligned first, in order; paragraphs that only moved count as unchanged. The
+// paragraphs left over are paired up, in order, as modified if they are
+// similar enough, and are added or removed otherwise. Texts of more than
+// MAX_PARAGRAPHS paragraphs return ErrTooLarge.
+func Compare(oldText, newText string) (Diff, error) {
+	oldParagraphs, newParagraphs := Paragraphs(oldText), Paragraphs(newText)
+	if len(oldParagraphs) > MAX_PARAGRAPHS || len(newParagraphs) > MAX_PARAGRAPHS {
+		return Diff{}, ErrTooLarge
+	}
+
+	oldKeys, newKeys := make([]string, len(oldParagraphs)), make([]string, len(newParagraphs))
+	for i, paragraph := range oldParagraphs {
+		oldKeys[i] = normalize(paragraph)
+	}
+	for j, paragraph := range newParagraphs {
+		newKeys[j] = normalize(paragraph)
+	}
+
+	anchors := commonSubsequence(oldKeys, newKeys)
+	oldAligned, newAligned := make([]bool, len(oldKeys)), make([]bool, len(newKeys))
+	for _, anchor := range anchors {
+		oldAligned[anchor[0]], newAligned[anchor[1]] = true, true
+	}
+
+	// Paragraphs left out of the common subsequence that read the same as a
+	// paragraph left out of the other text were moved.
+	unaligned := map[string][]int{}
+	for i, key := range oldKeys {
+		if !oldAligned[i] {
+			unaligned[key] = append(unaligned[key], i)
+		}
+	}
+	moved := 0
+	for j, key := range newKeys {
+		if olds := unaligned[key]; !newAligned[j] && len(olds) > 0 {
+			oldAligned[olds[0]], newAligned[j] = true, true
+			unaligned[key] = olds[1:]
+			moved++
+		}
+	}
+
+	var removed, added []int
+	for i := range oldKeys {
+		if !oldAligned[i] {
+			removed = append(removed, i)
+		}
+	}
+	for j := range newKeys {
+		if !newAligned[j] {
+			added = append(added, j)
+		}
+	}
+
+	d := Diff{
+		Unchanged: len(anchors) + moved,
+		Changes:   pair(oldParagraphs, newParagraphs, removed, added),
+	}
+	for _, change := range d.Changes {
+		switch change.Kind {
+		case Added:
+			d.Added++
+		case Removed:
+			d.Removed++
+		case Modified:
+			d.Modified++
+		}
+	}
+
+	return d, nil
+}
+
+// pair matches removed and added paragraphs that are similar enough to be the
+// same paragraph, modified, keeping their order. Each removed
+// paragraph is paired with the most similar added paragraph after the last
+// one paired.
+func pair(oldParagraphs, newParagraphs []string, removed, added []int) []Change {
+	// Every removed paragraph is compared with many added ones, so their words
+	// are split once.
+	addedWords := make([][]string, len(added))
+	for k, j := range added {
+		addedWords[k] = words(newParagraphs[j])
+	}
+
+	var changes []Change
+	next := 0
+	for _, i := range removed {
+		removedWords := words(oldParagraphs[i])
+		best, bestSimilarity := -1, 0.0
+		for k := next; k < len(added); k++ {
+			if s := similarity(removedWords, addedWords[k]); s >= MODIFIED_SIMILARITY_THRESHOLD && s > bestSimilarity {
+				best, bestSimilarity = k, s
+			}
+		}
+
+		if best < 0 {
+			changes = append(changes, Change{Kind: Removed, Old: oldParagraphs[i], Position: i})
+			continue
+		}
+
+		for ; next < best; next++ {
+			changes = append(changes, Change{Kind: Added, New: newParagraphs[added[next]], Position: added[next]})
+		}
+		j := added[best]
+		changes = append(changes, Change{Kind: Modified, Old: oldParagraphs[i], New: newParagraphs[j], Position: j, Similarity: bestSimilarity})
+		next = best + 1
+	}
+
+	for ; next < len(added); next++ {
+		changes = append(changes, Change{Kind: Added, New: newParagraphs[added[next]], Position: added[next]})
+	}
+
+	return changes
+}
+
+// commonSubsequence returns the index pairs of the longest common subsequence
+// of a and b, in order.
+func commonSubsequence(a, b []string) [][2]int {
+	// lengths[i][j] is the length of the longest common subsequence of a[i:]
+	// and b[j:].
+	lengths := make([][]int32, len(a)+1)
+	for i := range lengths {
+		lengths[i] = make([]int32, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lengths[i][j] = lengths[i+1][j+1] + 1
+			} else if lengths[i+1][j] >= lengths[i][j+1] {
+				lengths[i][j] = lengths[i+1][j]
+			} else {
+				lengths[i][j] = lengths[i][j+1]
+			}
+		}
+	}
+
+	var pairs [][2]int
+	for i, j := 0, 0; i < len(a) && j < len(b); {
+		if a[i] == b[j] {
+			pairs = append(pairs, [2]int{i, j})
+			i++
+			j++
+		} else if lengths[i+1][j] >= lengths[i][j+1] {
+			i++
+		} else {
+			j++
+		}
+	}
+
+	return pairs
+}
+
+// Paragraphs splits text into paragraphs, one per non-blank line, with
+// surrounding whitespace removed.
+func Paragraphs(text string) []string {
+	var paragraphs []string
+	for _, line := range strings.Split(text, "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			paragraphs = append(paragraphs, line)
+		}
+	}
+
+	return paragraphs
+}
+
+// Similarity is the Dice coefficient of the words of two paragraphs, from 0
+// for paragraphs with no words in common to 1 for paragraphs with the same
+// words.
+func Similarity(a, b string) float64 {
+	return similarity(words(a), words(b))
+}
+
+func similarity(aWords, bWords []string) float64 {
+	if len(aWords) == 0 && len(bWords) == 0 {
+		return 1
+	}
+
+	counts := map[string]int{}
+	for _, word := range aWords {
+		counts[word]++
+	}
+
+	var common int
+	for _, word := range bWords {
+		if counts[word] > 0 {
+			counts[word]--
+			common++
+		}
+	}
+
+	return 2 * float64(common) / float64(len(aWords)+len(bWords))
+}
+
+// normalize makes paragraphs that differ only in case, punctuation or spacing
+// compare equal.
+func normalize(paragraph string) string {
+	return strings.Join(words(paragraph), " ")
+}
+
+func words(paragraph string) []string {
+	return strings.FieldsFunc(strings.ToLower(paragraph), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+}
@@ -0,0 +1,137 @@
+package diff
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestCompare(t *testing.T) {
+	tests := []struct {
+		name     string
+		old, new string
+		want     Diff
+	}{
+		{
+			name: "unchanged",
+			old:  "alpha one\nbeta two",
+			new:  "alpha one\n\n  beta two  ",
+			want: Diff{Unchanged: 2},
+		},
+		{
+			name: "case and punctuation",
+			old:  "Alpha, one.",
+			new:  "alpha one",
+			want: Diff{Unchanged: 1},
+		},
+		{
+			name: "added",
+			old:  "alpha one\ngamma three",
+			new:  "alpha one\nbeta two\ngamma three",
+			want: Diff{Added: 1, Unchanged: 2, Changes: []Change{
+				{Kind: Added, New: "beta two", Position: 1},
+			}},
+		},
+		{
+			name: "removed",
+			old:  "alpha one\nbeta two\ngamma three",
+			new:  "alpha one\ngamma three",
+			want: Diff{Removed: 1, Unchanged: 2, Changes: []Change{
+				{Kind: Removed, Old: "beta two", Position: 1},
+			}},
+		},
+		{
+			name: "modified",
+			old:  "alpha one\nthe company faces competition in all markets",
+			new:  "alpha one\nthe company faces intense competition in all markets",
+			want: Diff{Modified: 1, Unchanged: 1, Changes: []Change{
+				{Kind: Modified, Old: "the company faces competition in all markets", New: "the company faces intense competition in all markets", Position: 1, Similarity: 2 * 7.0 / 15},
+			}},
+		},
+		{
+			name: "moved",
+			old:  "alpha one\nbeta two\ngamma three",
+			new:  "gamma three\nalpha one\nbeta two",
+			want: Diff{Unchanged: 3},
+		},
+		{
+			name: "duplicate removed",
+			old:  "alpha one\nalpha one\nbeta two",
+			new:  "alpha one\nbeta two",
+			want: Diff{Removed: 1, Unchanged: 2, Changes: []Change{
+				{Kind: Removed, Old: "alpha one", Position: 1},
+			}},
+		},
+		{
+			name: "duplicate added",
+			old:  "alpha one\nbeta two",
+			new:  "alpha one\nbeta two\nalpha one",
+			want: Diff{Added: 1, Unchanged: 2, Changes: []Change{
+				{Kind: Added, New: "alpha one", Position: 2},
+			}},
+		},
+		{
+			name: "at similarity threshold",
+			old:  "one two three four",
+			new:  "one two five six",
+			want: Diff{Modified: 1, Changes: []Change{
+				{Kind: Modified, Old: "one two three four", New: "one two five six", Position: 0, Similarity: MODIFIED_SIMILARITY_THRESHOLD},
+			}},
+		},
+		{
+			name: "below similarity threshold",
+			old:  "one two three four",
+			new:  "one two five six seven",
+			want: Diff{Added: 1, Removed: 1, Changes: []Change{
+				{Kind: Removed, Old: "one two three four", Position: 0},
+				{Kind: Added, New: "one two five six seven", Position: 0},
+			}},
+		},
+		{
+			name: "modified keeps order",
+			old:  "alpha one two three\nbeta four five six",
+			new:  "gamma seven\nalpha one two three eight\nbeta four five six nine",
+			want: Diff{Added: 1, Modified: 2, Changes: []Change{
+				{Kind: Added, New: "gamma seven", Position: 0},
+				{Kind: Modified, Old: "alpha one two three", New: "alpha one two three eight", Position: 1, Similarity: 2 * 4.0 / 9},
+				{Kind: Modified, Old: "beta four five six", New: "beta four five six nine", Position: 2, Similarity: 2 * 4.0 / 9},
+			}},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := Compare(test.old, test.new)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("Compare(%q, %q) = %+v, want %+v", test.old, test.new, got, test.want)
+			}
+		})
+	}
+}
+
+func TestCompareTooLarge(t *testing.T) {
+	limit := strings.Repeat("paragraph\n", MAX_PARAGRAPHS)
+	tooLarge := limit + "paragraph\n"
+
+	tests := []struct {
+		name     string
+		old, new string
+		want     error
+	}{
+		{"at limit", limit, limit, nil},
+		{"old too large", tooLarge, "paragraph", ErrTooLarge},
+		{"new too large", "paragraph", tooLarge, ErrTooLarge},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if _, err := Compare(test.old, test.new); !errors.Is(err, test.want) {
+				t.Errorf("Compare() error = %v, want %v", err, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,488 @@
+// Package edgar implements sec_api.Provider on top of EDGAR's public
+// endpoints. EDGAR is free to use but asks clients to identify themselves with
+// a descriptive User-Agent and to make no more than ten requests per second.
+//
+// See https://www.sec.gov/os/accessing-edgar-data.
+package edgar
+
+import (
+	"cofin/internal/sec_api"
+	"cofin/models"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/go-retryablehttp"
+)
+
+// SEC's fair access policy allows up to ten requests per second.
+const maxRequestsPerSecond = 10
+
+const (
+	tickersURL        = "https://www.sec.gov/files/company_tickers_exchange.json"
+	submissionsURL    = "https://data.sec.gov/submissions/CIK%010v.json"
+	submissionFileURL = "https://data.sec.gov/submissions/%v"
+	archiveURL        = "https://www.sec.gov/Archives/edgar/data/%v/%v/%v"
+	filingIndexURL    = "https://www.sec.gov/Archives/edgar/data/%v/%v/%v-index.htm"
+	documentCacheSize = 8
+	// The tickers file lists all exchanges and is requested once per
+	// exchange, so it is kept for a while.
+	tickersCacheTTL = time.Hour
+)
+
+// Client talks to EDGAR. It is safe for concurrent use.
+type Client struct {
+	userAgent string
+	http      *http.Client
+	throttle  *throttle
+
+	// Endpoints, overridden in tests.
+	tickersURL        string
+	submissionsURL    string
+	submissionFileURL string
+	companyFactsURL   string
+
+	tickersMutex     sync.Mutex
+	tickers          []sec_api.Listing
+	tickersFetchedAt time.Time
+
+	// Extracting a section requires downloading and parsing the whole
+	// filing, and sections are requested one at a time. We keep the sections
+	// of the most recently used filings around.
+	cacheMutex sync.Mutex
+	cache      map[string]*cachedFiling
+	cacheOrder []string
+}
+
+// cachedFiling holds the sections of a filing once ready is closed. Concurrent
+// requests for sections of the same filing share one download.
+type cachedFiling struct {
+	ready    chan struct{}
+	sections map[models.Section]string
+	err      error
+}
+
+var _ sec_api.Provider = &Client{}
+
+// NewClient creates a new EDGAR client. SEC requires userAgent to name the
+// company and a contact email address, e.g. "COFIN admin@cofin.ai".
+func NewClient(userAgent string) (*Client, error) {
+	if userAgent == "" {
+		return nil, errors.New("EDGAR requires a User-Agent with a contact email address")
+	}
+
+	client := retryablehttp.NewClient()
+	client.Logger = nil
+
+	return &Client{
+		userAgent: userAgent,
+		http:      client.StandardClient(),
+		throttle:  newThrottle(maxRequestsPerSecond),
+		cache:     make(map[string]*cachedFiling),
+
+		tickersURL:        tickersURL,
+		submissionsURL:    submissionsURL,
+		submissionFileURL: submissionFileURL,
+		companyFactsURL:   companyFactsURL,
+	}, nil
+}
+
+// get fetches a URL from EDGAR, respecting the rate limit.
+func (c *Client) get(url string) ([]byte, error) {
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", c.userAgent)
+
+	c.throttle.wait()
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("EDGAR returned %v for %v", resp.Status, url)
+	}
+
+	return b, nil
+}
+
+// Get companies traded on an exchange.
+func (c *Client) GetTradedCompanies(exchange sec_api.Exchange) ([]sec_api.Listing, error) {
+	all, err := c.getAllListings()
+	if err != nil {
+		return nil, err
+	}
+
+	var listings []sec_api.Listing
+	for _, listing := range all {
+		if strings.EqualFold(listing.Exchange, string(exchange)) {
+			listings = append(listings, listing)
+		}
+	}
+
+	return listings, nil
+}
+
+// getAllListings returns the listings of all exchanges, fetching the tickers
+// file at most once per tickersCacheTTL.
+func (c *Client) getAllListings() ([]sec_api.Listing, error) {
+	c.tickersMutex.Lock()
+	defer c.tickersMutex.Unlock()
+
+	if c.tickers != nil && time.Since(c.tickersFetchedAt) < tickersCacheTTL {
+		return c.tickers, nil
+	}
+
+	type response struct {
+		Fields []string        `json:"fields"`
+		Data   [][]interface{} `json:"data"`
+	}
+
+	b, err := c.get(c.tickersURL)
+	if err != nil {
+		return nil, err
+	}
+
+	var r response
+	if err := json.Unmarshal(b, &r); err != nil {
+		return nil, err
+	}
+
+	columns := make(map[string]int)
+	for i, field := range r.Fields {
+		columns[field] = i
+	}
+	for _, field := range []string{"cik", "name", "ticker", "exchange"} {
+		if _, ok := columns[field]; !ok {
+			return nil, fmt.Errorf("company tickers file has no %v field", field)
+		}
+	}
+
+	listings := []sec_api.Listing{}
+	for _, row := range r.Data {
+		if len(row) != len(r.Fields) {
+			continue
+		}
+
+		// JSON numbers are decoded as floats.
+		cik, ok := row[columns["cik"]].(float64)
+		if !ok {
+			continue
+		}
+
+		listings = append(listings, sec_api.Listing{
+			Name:     fmt.Sprint(row[columns["name"]]),
+			Ticker:   fmt.Sprint(row[columns["ticker"]]),
+			CIK:      fmt.Sprint(int64(cik)),
+			Exchange: strings.ToUpper(fmt.Sprint(row[columns["exchange"]])),
+		})
+	}
+
+	c.tickers = listings
+	c.tickersFetchedAt = time.Now()
+	return listings, nil
+}
+
+// submissionList lists filings of a company, one filing per index across the
+// fields.
+type submissionList struct {
+	AccessionNumber    []string `json:"accessionNumber"`
+	FilingDate         []string `json:"filingDate"`
+	ReportDate         []string `json:"reportDate"`
+	AcceptanceDateTime []string `json:"acceptanceDateTime"`
+	Form               []string `json:"form"`
+	PrimaryDocument    []string `json:"primaryDocument"`
+	PrimaryDocDesc     []string `json:"primaryDocDescription"`
+}
+
+// Get up to limit filings of a kind filed since a point in time, oldest first.
+//
+// The submissions of a company list its recent filings, at least a year or
+// 1,000 filings, whichever is more. Older filings are listed in further files,
+// which are only fetched if they cover filings since the point in time.
+func (c *Client) GetFilingsSince(cik string, kind models.SourceKind, since time.Time, limit int) ([]sec_api.Filing, error) {
+	type response struct {
+		CIK     string   `json:"cik"`
+		Name    string   `json:"name"`
+		Tickers []string `json:"tickers"`
+		Filings struct {
+			Recent submissionList `json:"recent"`
+			Files  []struct {
+				Name     string `json:"name"`
+				FilingTo string `json:"filingTo"`
+			} `json:"files"`
+		} `json:"filings"`
+	}
+
+	// CIKs are often zero-padded, which must not be read as octal.
+	cikNumber, err := strconv.ParseInt(cik, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid CIK %q: %w", cik, err)
+	}
+
+	b, err := c.get(fmt.Sprintf(c.submissionsURL, cikNumber))
+	if err != nil {
+		return nil, err
+	}
+
+	var r response
+	if err := json.Unmarshal(b, &r); err != nil {
+		return nil, err
+	}
+
+	lists := []submissionList{r.Filings.Recent}
+	for _, file := range r.Filings.Files {
+		// Files that list no filings since the point in time are skipped.
+		// Filing dates are days, so the day of since itself is kept.
+		if filingTo, err := time.Parse("2006-01-02", file.FilingTo); err == nil && filingTo.Before(since.Truncate(24*time.Hour)) {
+			continue
+		}
+
+		b, err := c.get(fmt.Sprintf(c.submissionFileURL, file.Name))
+		if err != nil {
+			return nil, err
+		}
+
+		var list submissionList
+		if err := json.Unmarshal(b, &list); err != nil {
+			return nil, fmt.Errorf("failed to parse %v: %w", file.Name, err)
+		}
+		lists = append(lists, list)
+	}
+
+	var ticker string
+	if len(r.Tickers) > 0 {
+		ticker = r.Tickers[0]
+	}
+
+	var filings []sec_api.Filing
+	for _, rf := range lists {
+		for i := range rf.AccessionNumber {
+			if i >= len(rf.Form) || i >= len(rf.AcceptanceDateTime) || i >= len(rf.PrimaryDocument) {
+				break
+			}
+
+			if rf.Form[i] != string(kind) {
+				continue
+			}
+
+			acceptedAt, err := time.Parse(time.RFC3339, rf.AcceptanceDateTime[i])
+			if err != nil {
+				return nil, fmt.Errorf("failed to parse acceptance time of %v: %w", rf.AccessionNumber[i], err)
+			}
+
+			if acceptedAt.Before(since) {
+				continue
+			}
+
+			accessionNumber := strings.ReplaceAll(rf.AccessionNumber[i], "-", "")
+			filing := sec_api.Filing{
+				ID:                  rf.AccessionNumber[i],
+				AccessionNo:         rf.AccessionNumber[i],
+				CIK:                 fmt.Sprint(cikNumber),
+				Ticker:              ticker,
+				CompanyName:         r.Name,
+				FormType:            rf.Form[i],
+				FiledAt:             acceptedAt.Format(time.RFC3339),
+				LinkToHtml:          fmt.Sprintf(filingIndexURL, cikNumber, accessionNumber, rf.AccessionNumber[i]),
+				LinkToFilingDetails: fmt.Sprintf(archiveURL, cikNumber, accessionNumber, rf.PrimaryDocument[i]),
+			}
+			if i < len(rf.PrimaryDocDesc) {
+				filing.Description = rf.PrimaryDocDesc[i]
+			}
+			if i < len(rf.ReportDate) {
+				filing.PeriodOfReport = rf.ReportDate[i]
+			}
+
+			filings = append(filings, filing)
+		}
+	}
+
+	// EDGAR lists the most recent filings first.
+	sort.SliceStable(filings, func(i, j int) bool {
+		return filings[i].FiledAt < filings[j].FiledAt
+	})
+
+	if limit > 0 && len(filings) > limit {
+		filings = filings[:limit]
+	}
+
+	return filings, nil
+}
+
+// Get the plain text of a section of the filing at originURL. The primary
+// document of the filing is downloaded and split into sections once and
+// served from a cache afterwards.
+func (c *Client) ExtractSectionContent(originURL string, section models.Section) (string, error) {
+	// Documents stored whole are the only section requested of their filing,
+	// so there is nothing to cache.
+	if section == models.FullDocument {
+		return c.fetchFullDocument(originURL)
+	}
+
+	c.cacheMutex.Lock()
+	filing, ok := c.cache[originURL]
+	if !ok {
+		filing = &cachedFiling{ready: make(chan struct{})}
+		c.cache[originURL] = filing
+		c.cacheOrder = append(c.cacheOrder, originURL)
+		if len(c.cacheOrder) > documentCacheSize {
+			delete(c.cache, c.cacheOrder[0])
+			c.cacheOrder = c.cacheOrder[1:]
+		}
+	}
+	c.cacheMutex.Unlock()
+
+	if !ok {
+		filing.sections, filing.err = c.fetchSections(originURL)
+		close(filing.ready)
+
+		// Let the next request try again.
+		if filing.err != nil {
+			c.cacheMutex.Lock()
+			if c.cache[originURL] == filing {
+				delete(c.cache, originURL)
+			}
+			c.cacheMutex.Unlock()
+		}
+	}
+
+	<-filing.ready
+	if filing.err != nil {
+		return "", filing.err
+	}
+
+	return filing.sections[section], nil
+}
+
+// fetchSections downloads a filing's primary document and splits it into
+// sections.
+func (c *Client) fetchSections(originURL string) (map[models.Section]string, error) {
+	b, err := c.get(originURL)
+	if err != nil {
+		return nil, err
+	}
+
+	text, err := htmlToText(b)
+	if err != nil {
+		return nil, err
+	}
+
+	return splitSections(text), nil
+}
+
+// fetchFullDocument returns the text of a filing's primary document followed by
+// the text of its exhibit 99 documents. 6-Ks and 40-Fs carry their substance,
+// such as press releases, interim reports and annual information forms, in
+// exhibit 99 rather than in the primary document.
+func (c *Client) fetchFullDocument(originURL string) (string, error) {
+	b, err := c.get(originURL)
+	if err != nil {
+		return "", err
+	}
+
+	text, err := htmlToText(b)
+	if err != nil {
+		return "", err
+	}
+
+	exhibits, err := c.getExhibitURLs(originURL)
+	if err != nil {
+		return "", err
+	}
+
+	texts := []string{text}
+	for _, exhibitURL := range exhibits {
+		b, err := c.get(exhibitURL)
+		if err != nil {
+			return "", err
+		}
+
+		text, err := htmlToText(b)
+		if err != nil {
+			return "", err
+		}
+		texts = append(texts, text)
+	}
+
+	return strings.TrimSpace(strings.Join(texts, "\n\n")), nil
+}
+
+// getExhibitURLs lists the exhibit 99 documents in the directory of the filing
+// at originURL. Exhibits are recognized by name, e.g. "d123456dex991.htm" or
+// "ex99-1.htm", as the directory listing does not give document types.
+func (c *Client) getExhibitURLs(originURL string) ([]string, error) {
+	type response struct {
+		Directory struct {
+			Item []struct {
+				Name string `json:"name"`
+			} `json:"item"`
+		} `json:"directory"`
+	}
+
+	directory := originURL[:strings.LastIndex(originURL, "/")]
+	b, err := c.get(directory + "/index.json")
+	if err != nil {
+		return nil, err
+	}
+
+	var r response
+	if err := json.Unmarshal(b, &r); err != nil {
+		return nil, err
+	}
+
+	var urls []string
+	for _, item := range r.Directory.Item {
+		name := strings.ToLower(item.Name)
+		if directory+"/"+item.Name == originURL || !(strings.HasSuffix(name, ".htm") || strings.HasSuffix(name, ".html")) {
+			continue
+		}
+
+		if strings.Contains(name, "ex99") || strings.Contains(name, "ex-99") || strings.Contains(name, "ex_99") {
+			urls = append(urls, directory+"/"+item.Name)
+		}
+	}
+
+	return urls, nil
+}
+
+// throttle spaces out requests so that no more than a fixed number are made
+// per second.
+type throttle struct {
+	mutex    sync.Mutex
+	interval time.Duration
+	next     time.Time
+}
+
+func newThrottle(perSecond int) *throttle {
+	return &throttle{interval: time.Second / time.Duration(perSecond)}
+}
+
+// wait blocks until the caller may make another request.
+func (t *throttle) wait() {
+	t.mutex.Lock()
+	now := time.Now()
+	if t.next.Before(now) {
+		t.next = now
+	}
+	wait := t.next.Sub(now)
+	t.next = t.next.Add(t.interval)
+	t.mutex.Unlock()
+
+	time.Sleep(wait)
+}
@@ -0,0 +1,162 @@
+package edgar
+
+import (
+	"cofin/internal/sec_api"
+	"cofin/models"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestClient returns a client talking to a server that serves the files in
+// testdata, and counts the requests made for each path.
+func newTestClient(t *testing.T) (*Client, map[string]int) {
+	t.Helper()
+
+	requests := map[string]int{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests[r.URL.Path]++
+		if r.Header.Get("User-Agent") != "COFIN test@cofin.ai" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+
+		b, err := os.ReadFile(filepath.Join("testdata", filepath.Base(r.URL.Path)))
+		if err != nil {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Write(b)
+	}))
+	t.Cleanup(server.Close)
+
+	client, err := NewClient("COFIN test@cofin.ai")
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.tickersURL = server.URL + "/company_tickers_exchange.json"
+	client.submissionsURL = server.URL + "/submissions_CIK%010v.json"
+	client.submissionFileURL = server.URL + "/%v"
+
+	return client, requests
+}
+
+func TestGetTradedCompanies(t *testing.T) {
+	client, requests := newTestClient(t)
+
+	nyse, err := client.GetTradedCompanies(sec_api.NYSE)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var tickers []string
+	for _, listing := range nyse {
+		if listing.Exchange != "NYSE" {
+			t.Errorf("listing %v on %v, want NYSE", listing.Ticker, listing.Exchange)
+		}
+		tickers = append(tickers, listing.Ticker)
+	}
+	if got, want := strings.Join(tickers, ","), "BRK-B,BRK-A,MET,AIR"; got != want {
+		t.Errorf("NYSE tickers = %v, want %v", got, want)
+	}
+	if nyse[0].CIK != "1067983" || nyse[0].Name != "BERKSHIRE HATHAWAY INC" {
+		t.Errorf("first NYSE listing = %+v", nyse[0])
+	}
+
+	nasdaq, err := client.GetTradedCompanies(sec_api.NASDAQ)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(nasdaq) != 5 {
+		t.Errorf("got %v Nasdaq listings, want 5", len(nasdaq))
+	}
+
+	if n := requests["/company_tickers_exchange.json"]; n != 1 {
+		t.Errorf("tickers file fetched %v times, want once", n)
+	}
+}
+
+func TestGetFilingsSince(t *testing.T) {
+	client, _ := newTestClient(t)
+
+	since := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	filings, err := client.GetFilingsSince("0000320193", models.SourceKind("10-Q"), since, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var accessionNos []string
+	for _, filing := range filings {
+		accessionNos = append(accessionNos, filing.AccessionNo)
+	}
+	want := "0000320193-23-000006,0000320193-23-000064,0000320193-23-000077,0000320193-24-000006"
+	if got := strings.Join(accessionNos, ","); got != want {
+		t.Errorf("10-Qs since 2023 = %v, want %v oldest first", got, want)
+	}
+
+	first := filings[0]
+	if first.Ticker != "AAPL" || first.CIK != "320193" || first.PeriodOfReport != "2022-12-31" {
+		t.Errorf("first filing = %+v", first)
+	}
+	if want := "https://www.sec.gov/Archives/edgar/data/320193/000032019323000006/aapl-20221231.htm"; first.LinkToFilingDetails != want {
+		t.Errorf("primary document = %v, want %v", first.LinkToFilingDetails, want)
+	}
+
+	limited, err := client.GetFilingsSince("320193", models.SourceKind("10-Q"), since, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(limited) != 2 || limited[0].AccessionNo != filings[0].AccessionNo || limited[1].AccessionNo != filings[1].AccessionNo {
+		t.Errorf("limited to 2 = %+v, want the 2 oldest", limited)
+	}
+
+	annual, err := client.GetFilingsSince("320193", models.SourceKind("10-K"), time.Time{}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(annual) != 2 || annual[0].FiledAt > annual[1].FiledAt {
+		t.Errorf("10-Ks = %+v, want 2 oldest first", annual)
+	}
+}
+
+func TestGetFilingsSinceOlderFiles(t *testing.T) {
+	client, requests := newTestClient(t)
+
+	if _, err := client.GetFilingsSince("320193", models.SourceKind("10-Q"), time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC), 0); err != nil {
+		t.Fatal(err)
+	}
+	if n := requests["/CIK0000320193-submissions-001.json"]; n != 0 {
+		t.Errorf("fetched older filings %v times for filings since 2023", n)
+	}
+
+	filings, err := client.GetFilingsSince("320193", models.SourceKind("10-Q"), time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var accessionNos []string
+	for _, filing := range filings {
+		accessionNos = append(accessionNos, filing.AccessionNo)
+	}
+	want := "0000320193-22-000007,0000320193-22-000059,0000320193-22-000070"
+	if got := strings.Join(accessionNos[:3], ","); got != want {
+		t.Errorf("first 10-Qs since 2022 = %v, want %v", got, want)
+	}
+	if len(filings) != 7 {
+		t.Errorf("got %v 10-Qs since 2022, want 7", len(filings))
+	}
+}
+
+func TestGetFilingsSinceInvalidCIK(t *testing.T) {
+	client, requests := newTestClient(t)
+
+	if _, err := client.GetFilingsSince("AAPL", models.SourceKind("10-K"), time.Time{}, 0); err == nil {
+		t.Error("expected an error for a ticker given as CIK")
+	}
+	if len(requests) != 0 {
+		t.Errorf("made requests %v for an invalid CIK", requests)
+	}
+}
@@ -0,0 +1,307 @@
+package edgar
+
+import (
+	"cofin/models"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+)
+
+const companyFactsURL = "https://data.sec.gov/api/xbrl/companyfacts/CIK%010v.json"
+
+// fact is a value reported in a filing's XBRL data. Duration facts, such as
+// revenue, cover the period from Start to End; instant facts, such as cash,
+// have no Start.
+type fact struct {
+	Start string  `json:"start"`
+	End   string  `json:"end"`
+	Value float64 `json:"val"`
+	// Accession number of the filing reporting the fact.
+	AccessionNo string `json:"accn"`
+	Form        string `json:"form"`
+	Filed       string `json:"filed"`
+}
+
+type companyFacts struct {
+	Facts map[string]map[string]struct {
+		Units map[string][]fact `json:"units"`
+	} `json:"facts"`
+}
+
+// Concepts that report each figure, in order of preference. Companies choose
+// among equivalent US GAAP concepts, and switch between them over time.
+var (
+	revenueConcepts = []string{
+		"Revenues",
+		"RevenueFromContractWithCustomerExcludingAssessedTax",
+		"RevenueFromContractWithCustomerIncludingAssessedTax",
+		"SalesRevenueNet",
+	}
+	costOfRevenueConcepts = []string{
+		"CostOfRevenue",
+		"CostOfGoodsAndServicesSold",
+		"CostOfGoodsSold",
+	}
+	depreciationConcepts = []string{
+		"DepreciationDepletionAndAmortization",
+		"DepreciationAndAmortization",
+		"DepreciationAmortizationAndAccretionNet",
+	}
+	cashConcepts = []string{
+		"CashAndCashEquivalentsAtCarryingValue",
+		"CashCashEquivalentsRestrictedCashAndRestrictedCashEquivalents",
+	}
+	equityConcepts = []string{
+		"StockholdersEquity",
+		"StockholdersEquityIncludingPortionAttributableToNoncontrollingInterest",
+	}
+)
+
+// GetFundamentals returns the latest fundamentals a company reported in the
+// XBRL data of its 10-K and 10-Q filings. Only US GAAP figures in USD are
+// read, so companies reporting under IFRS have no income statement or balance
+// sheet figures.
+func (c *Client) GetFundamentals(cik string) (*models.Fundamentals, error) {
+	// CIKs are often zero-padded, which must not be read as octal.
+	cikNumber, err := strconv.ParseInt(cik, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid CIK %q: %w", cik, err)
+	}
+
+	b, err := c.get(fmt.Sprintf(c.companyFactsURL, cikNumber))
+	if err != nil {
+		return nil, err
+	}
+
+	var facts companyFacts
+	if err := json.Unmarshal(b, &facts); err != nil {
+		return nil, err
+	}
+
+	var fundamentals models.Fundamentals
+	// The latest period end and filing date of the figures read.
+	var periodEnd, filed string
+	observe := func(f *fact) {
+		if f != nil && f.End > periodEnd {
+			periodEnd = f.End
+		}
+		if f != nil && f.Filed > filed {
+			filed = f.Filed
+		}
+	}
+
+	usGAAP := func(concepts ...string) []fact {
+		for _, concept := range concepts {
+			if values := facts.Facts["us-gaap"][concept].Units["USD"]; len(values) > 0 {
+				return values
+			}
+		}
+		return nil
+	}
+
+	var f *fact
+	fundamentals.Revenue, f = trailingTwelveMonths(usGAAP(revenueConcepts...))
+	observe(f)
+	fundamentals.GrossProfit, f = trailingTwelveMonths(usGAAP("GrossProfit"))
+	observe(f)
+	if fundamentals.GrossProfit == nil && fundamentals.Revenue != nil {
+		if costOfRevenue, f := trailingTwelveMonths(usGAAP(costOfRevenueConcepts...)); costOfRevenue != nil {
+			grossProfit := *fundamentals.Revenue - *costOfRevenue
+			fundamentals.GrossProfit = &grossProfit
+			observe(f)
+		}
+	}
+	fundamentals.OperatingIncome, f = trailingTwelveMonths(usGAAP("OperatingIncomeLoss"))
+	observe(f)
+	fundamentals.NetIncome, f = trailingTwelveMonths(usGAAP("NetIncomeLoss"))
+	observe(f)
+	fundamentals.DepreciationAndAmortization, f = trailingTwelveMonths(usGAAP(depreciationConcepts...))
+	observe(f)
+
+	fundamentals.Cash, f = latestInstant(usGAAP(cashConcepts...))
+	observe(f)
+	fundamentals.StockholdersEquity, f = latestInstant(usGAAP(equityConcepts...))
+	observe(f)
+	fundamentals.TotalDebt = totalDebt(usGAAP)
+
+	// Companies with several share classes report shares outstanding per
+	// class on the cover page.
+	fundamentals.SharesOutstanding = latestInstantSum(facts.Facts["dei"]["EntityCommonStockSharesOutstanding"].Units["shares"])
+	if fundamentals.SharesOutstanding == nil {
+		fundamentals.SharesOutstanding, _ = latestInstant(facts.Facts["us-gaap"]["CommonStockSharesOutstanding"].Units["shares"])
+	}
+
+	fundamentals.PeriodEnd, _ = time.Parse("2006-01-02", periodEnd)
+	fundamentals.FiledAt, _ = time.Parse("2006-01-02", filed)
+
+	return &fundamentals, nil
+}
+
+// totalDebt adds up long-term debt, including its current portion, and
+// short-term borrowings.
+func totalDebt(usGAAP func(concepts ...string) []fact) *float64 {
+	var total float64
+	var found bool
+	add := func(value *float64) {
+		if value != nil {
+			total += *value
+			found = true
+		}
+	}
+
+	if longTerm, _ := latestInstant(usGAAP("LongTermDebt")); longTerm != nil {
+		add(longTerm)
+	} else {
+		noncurrent, _ := latestInstant(usGAAP("LongTermDebtNoncurrent"))
+		current, _ := latestInstant(usGAAP("LongTermDebtCurrent"))
+		add(noncurrent)
+		add(current)
+	}
+	shortTerm, _ := latestInstant(usGAAP("ShortTermBorrowings"))
+	add(shortTerm)
+
+	if !found {
+		return nil
+	}
+
+	return &total
+}
+
+// periodicFacts keeps the facts from 10-K and 10-Q filings, and of facts of the
+// same period reported in several filings, the most recently filed one.
+func periodicFacts(facts []fact) []fact {
+	latest := map[string]fact{}
+	for _, f := range facts {
+		switch f.Form {
+		case "10-K", "10-Q", "10-K/A", "10-Q/A":
+		default:
+			continue
+		}
+
+		key := f.Start + "/" + f.End
+		if existing, ok := latest[key]; !ok || f.Filed > existing.Filed {
+			latest[key] = f
+		}
+	}
+
+	var periodic []fact
+	for _, f := range latest {
+		periodic = append(periodic, f)
+	}
+	sort.Slice(periodic, func(i, j int) bool {
+		if periodic[i].End != periodic[j].End {
+			return periodic[i].End < periodic[j].End
+		}
+		return periodic[i].Start < periodic[j].Start
+	})
+
+	return periodic
+}
+
+// trailingTwelveMonths returns the value of a duration fact over the twelve
+// months to the latest period reported, and the latest fact it used. It is the
+// latest fiscal year's value, plus the year to date of the current fiscal year,
+// less the same period of the previous one.
+func trailingTwelveMonths(facts []fact) (*float64, *fact) {
+	facts = periodicFacts(facts)
+
+	var annual, ytd *fact
+	for i := range facts {
+		f := &facts[i]
+		days := durationDays(f)
+		if days >= 350 && days <= 380 {
+			annual = f
+		}
+	}
+	if annual == nil {
+		return nil, nil
+	}
+
+	// The year to date is the longest period starting after the fiscal year
+	// ends, e.g. nine months rather than the third quarter.
+	for i := range facts {
+		f := &facts[i]
+		if f.End > annual.End && f.Start > annual.End && (ytd == nil || f.End > ytd.End || (f.End == ytd.End && f.Start < ytd.Start)) {
+			ytd = f
+		}
+	}
+
+	value := annual.Value
+	if ytd == nil {
+		return &value, annual
+	}
+
+	start, _ := time.Parse("2006-01-02", ytd.Start)
+	end, _ := time.Parse("2006-01-02", ytd.End)
+	for i := range facts {
+		f := &facts[i]
+		if nearDate(f.Start, start.AddDate(-1, 0, 0)) && nearDate(f.End, end.AddDate(-1, 0, 0)) {
+			value += ytd.Value - f.Value
+			return &value, ytd
+		}
+	}
+
+	// Without the comparable period, the fiscal year is the best we have.
+	return &value, annual
+}
+
+// latestInstant returns the latest value of an instant fact, and the fact.
+func latestInstant(facts []fact) (*float64, *fact) {
+	facts = periodicFacts(facts)
+	if len(facts) == 0 {
+		return nil, nil
+	}
+
+	f := facts[len(facts)-1]
+	return &f.Value, &f
+}
+
+// latestInstantSum adds up the values of an instant fact reported for the
+// latest date in the latest filing, such as the shares of each class.
+func latestInstantSum(facts []fact) *float64 {
+	var latest fact
+	for _, f := range facts {
+		if f.End > latest.End || (f.End == latest.End && f.Filed > latest.Filed) {
+			latest = f
+		}
+	}
+	if latest.End == "" {
+		return nil
+	}
+
+	var sum float64
+	for _, f := range facts {
+		if f.End == latest.End && f.AccessionNo == latest.AccessionNo {
+			sum += f.Value
+		}
+	}
+
+	return &sum
+}
+
+func durationDays(f *fact) int {
+	start, err := time.Parse("2006-01-02", f.Start)
+	if err != nil {
+		return 0
+	}
+	end, err := time.Parse("2006-01-02", f.End)
+	if err != nil {
+		return 0
+	}
+
+	return int(end.Sub(start).Hours() / 24)
+}
+
+// nearDate reports whether date is within a week of t. Fiscal periods of
+// consecutive years end on different dates when they end on a weekday.
+func nearDate(date string, t time.Time) bool {
+	d, err := time.Parse("2006-01-02", date)
+	if err != nil {
+		return false
+	}
+
+	diff := d.Sub(t)
+	return diff > -7*24*time.Hour && diff < 7*24*time.Hour
+}
@@ -0,0 +1,178 @@
+package edgar
+
+import (
+	"bytes"
+	"cofin/models"
+	"regexp"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// Elements that start a new line of text.
+var blockElements = map[atom.Atom]bool{
+	atom.Address: true, atom.Article: true, atom.Blockquote: true, atom.Br: true,
+	atom.Dd: true, atom.Div: true, atom.Dl: true, atom.Dt: true, atom.Footer: true,
+	atom.Form: true, atom.H1: true, atom.H2: true, atom.H3: true, atom.H4: true,
+	atom.H5: true, atom.H6: true, atom.Header: true, atom.Hr: true, atom.Li: true,
+	atom.Ol: true, atom.P: true, atom.Pre: true, atom.Section: true, atom.Table: true,
+	atom.Tr: true, atom.Ul: true,
+}
+
+// Elements whose contents are never shown.
+var hiddenElements = map[atom.Atom]bool{
+	atom.Head: true, atom.Script: true, atom.Style: true, atom.Title: true,
+}
+
+// htmlToText renders an HTML filing as plain text with one line per block
+// element. Table cells on the same row are separated by tabs. Hidden content,
+// such as the inline XBRL header, is dropped.
+func htmlToText(b []byte) (string, error) {
+	root, err := html.Parse(bytes.NewReader(b))
+	if err != nil {
+		return "", err
+	}
+
+	var text strings.Builder
+	var walk func(n *html.Node)
+	walk = func(n *html.Node) {
+		switch n.Type {
+		case html.TextNode:
+			text.WriteString(n.Data)
+			return
+		case html.ElementNode:
+			if hiddenElements[n.DataAtom] || isHidden(n) {
+				return
+			}
+
+			if n.DataAtom == atom.Td || n.DataAtom == atom.Th {
+				text.WriteString("\t")
+			} else if blockElements[n.DataAtom] {
+				text.WriteString("\n")
+			}
+		}
+
+		for child := n.FirstChild; child != nil; child = child.NextSibling {
+			walk(child)
+		}
+
+		if n.Type == html.ElementNode && blockElements[n.DataAtom] {
+			text.WriteString("\n")
+		}
+	}
+	walk(root)
+
+	return normalizeText(text.String()), nil
+}
+
+// isHidden reports whether an element is hidden with inline CSS or is the
+// inline XBRL header.
+func isHidden(n *html.Node) bool {
+	if n.Data == "ix:header" {
+		return true
+	}
+
+	for _, attr := range n.Attr {
+		if attr.Key == "style" {
+			style := strings.ReplaceAll(strings.ToLower(attr.Val), " ", "")
+			if strings.Contains(style, "display:none") {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+var spacesRegexp = regexp.MustCompile(`[ \t\x{00a0}]+`)
+
+// normalizeText collapses runs of whitespace within lines and drops empty
+// lines.
+func normalizeText(s string) string {
+	var lines []string
+	for _, line := range strings.Split(s, "\n") {
+		line = strings.TrimSpace(spacesRegexp.ReplaceAllString(line, " "))
+		if line != "" {
+			lines = append(lines, line)
+		}
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+var (
+	// Matches "PART I", "Part II." and the like at the start of a line.
+	partRegexp = regexp.MustCompile(`(?i)^part\s+(i{1,2}|1|2)\b`)
+	// Matches "Item 1A.", "ITEM 7 —", "Item 16K:" and the like at the start
+	// of a line.
+	itemRegexp = regexp.MustCompile(`(?i)^item\s*(\d{1,2}[a-k]?)\s*(?:[.:\-–—]|\s|$)`)
+)
+
+// Lines longer than this are paragraphs, not headings.
+const maxHeadingLength = 200
+
+type heading struct {
+	sections []models.Section
+	offset   int
+}
+
+// splitSections splits the text of a filing into sections keyed the same way
+// as models.K10Sections, models.Q10Sections and models.F20Sections. 10-Qs
+// number items per part, so items that follow a "Part" heading are stored both
+// unqualified ("1A") and qualified with the part ("part2item1a"). Every item is
+// also stored the way 20-F items are keyed ("item4a").
+//
+// Item headings appear both in the table of contents and in the body of the
+// filing. For every item we keep the occurrence that is followed by the most
+// text before the next heading, which is the body.
+func splitSections(text string) map[models.Section]string {
+	var headings []heading
+	var part string
+	offset := 0
+	for _, line := range strings.SplitAfter(text, "\n") {
+		trimmed := strings.TrimSpace(line)
+		if len(trimmed) <= maxHeadingLength {
+			if match := partRegexp.FindStringSubmatch(trimmed); match != nil {
+				part = partNumber(match[1])
+			} else if match := itemRegexp.FindStringSubmatch(trimmed); match != nil {
+				item := match[1]
+				sections := []models.Section{
+					models.Section(strings.ToUpper(item)),
+					models.Section("item" + strings.ToLower(item)),
+				}
+				if part != "" {
+					sections = append(sections, models.Section("part"+part+"item"+strings.ToLower(item)))
+				}
+				headings = append(headings, heading{sections: sections, offset: offset})
+			}
+		}
+		offset += len(line)
+	}
+
+	sections := make(map[models.Section]string)
+	for i, h := range headings {
+		end := len(text)
+		if i+1 < len(headings) {
+			end = headings[i+1].offset
+		}
+
+		content := strings.TrimSpace(text[h.offset:end])
+		for _, section := range h.sections {
+			if len(content) > len(sections[section]) {
+				sections[section] = content
+			}
+		}
+	}
+
+	return sections
+}
+
+func partNumber(roman string) string {
+	switch strings.ToLower(roman) {
+	case "i", "1":
+		return "1"
+	default:
+		return "2"
+	}
+}
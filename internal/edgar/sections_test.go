@@ -0,0 +1,89 @@
+package edgar
+
+import (
+	"cofin/models"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func readSections(t *testing.T, name string) map[models.Section]string {
+	t.Helper()
+
+	b, err := os.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	text, err := htmlToText(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return splitSections(text)
+}
+
+func TestSplitSections10K(t *testing.T) {
+	sections := readSections(t, "10k.htm")
+
+	tests := []struct {
+		section models.Section
+		want    string
+	}{
+		{models.K10Business, "designs, manufactures and markets smartphones"},
+		{models.K10RiskFactors, "can be affected by a number of factors"},
+		{models.K10ManagementsDiscussion, "Total net sales decreased 3%"},
+		// 20-F style keys are stored as well.
+		{models.Section("item1a"), "can be affected by a number of factors"},
+	}
+	for _, tt := range tests {
+		content := sections[tt.section]
+		// The table of contents also has the heading, but the body wins.
+		if !strings.Contains(content, tt.want) {
+			t.Errorf("section %v = %q, want the body containing %q", tt.section, content, tt.want)
+		}
+	}
+
+	if strings.Contains(sections[models.K10Business], "TABLE OF CONTENTS") || strings.Contains(sections[models.K10Business], "dei:DocumentType") {
+		t.Errorf("section 1 includes the table of contents or hidden header: %q", sections[models.K10Business])
+	}
+}
+
+func TestSplitSections10Q(t *testing.T) {
+	sections := readSections(t, "10q.htm")
+
+	tests := []struct {
+		section models.Section
+		want    string
+	}{
+		{models.Q10FinancialStatements, "119,575"},
+		{models.Q10ManagementDiscussion, "Total net sales increased 2%"},
+		{models.Q10LegalProceedings, "Epic Games"},
+		{models.Q10RiskFactors, "Digital Markets Act"},
+	}
+	for _, tt := range tests {
+		if content := sections[tt.section]; !strings.Contains(content, tt.want) {
+			t.Errorf("section %v = %q, want it to contain %q", tt.section, content, tt.want)
+		}
+	}
+
+	// Item 1 of Part I and Item 1 of Part II are different sections.
+	if strings.Contains(sections[models.Q10FinancialStatements], "Epic Games") {
+		t.Errorf("part 1 item 1 has the text of part 2 item 1: %q", sections[models.Q10FinancialStatements])
+	}
+	if strings.Contains(sections[models.Q10LegalProceedings], "119,575") {
+		t.Errorf("part 2 item 1 has the text of part 1 item 1: %q", sections[models.Q10LegalProceedings])
+	}
+}
+
+func TestHTMLToText(t *testing.T) {
+	text, err := htmlToText([]byte(`<html><head><style>p{}</style></head><body><p>Net&nbsp;sales  rose</p><span style="display: none">hidden</span><table><tr><td>A</td><td>B</td></tr></table></body></html>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want := "Net sales rose\nA B"; text != want {
+		t.Errorf("text = %q, want %q", text, want)
+	}
+}
@@ -1,51 +1,159 @@
+// Package google_pki fetches and caches the public keys Google signs Firebase
+// ID tokens with, and verifies the tokens.
 package google_pki
 
 import (
+	"crypto/rsa"
+	"encoding/base64"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
+	"math/big"
 	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 )
 
-type GooglePKI struct{}
+const DefaultKeysURL = "https://www.googleapis.com/service_accounts/v1/jwk/securetoken@system.gserviceaccount.com"
 
-func (gpki GooglePKI) GetPublicKeys() ([]GooglePublicKey, error) {
-	type dtoPayload struct {
-		Keys []GooglePublicKey `json:"keys"`
+// DEFAULT_MAX_AGE is how long keys are cached when the response does not say.
+const DEFAULT_MAX_AGE = time.Hour
+
+// MIN_REFRESH_INTERVAL is how often keys are refetched at most because of an
+// unknown key ID, so that tokens with made-up IDs do not flood Google.
+const MIN_REFRESH_INTERVAL = time.Minute
+
+var ErrKeyNotFound = errors.New("key not found")
+
+// GooglePKI caches Google's public keys for as long as the response allows.
+// It is safe for concurrent use.
+type GooglePKI struct {
+	KeysURL string
+	HTTP    *http.Client
+
+	mu          sync.Mutex
+	keys        map[string]*rsa.PublicKey
+	expiresAt   time.Time
+	refreshedAt time.Time
+}
+
+func NewGooglePKI() *GooglePKI {
+	return &GooglePKI{
+		KeysURL: DefaultKeysURL,
+		HTTP:    &http.Client{Timeout: 10 * time.Second},
 	}
+}
 
-	url := "https://www.googleapis.com/service_accounts/v1/jwk/securetoken@system.gserviceaccount.com"
-	req, _ := http.NewRequest("GET", url, nil)
+// GetPublicKeyForKid returns the public key with the ID. Keys are refetched
+// when the cached ones expired, or when none has the ID, as Google rotates
+// them.
+func (gpki *GooglePKI) GetPublicKeyForKid(kid string) (*rsa.PublicKey, error) {
+	gpki.mu.Lock()
+	defer gpki.mu.Unlock()
 
-	res, _ := http.DefaultClient.Do(req)
+	now := time.Now()
+	key, ok := gpki.keys[kid]
+	if ok && now.Before(gpki.expiresAt) {
+		return key, nil
+	}
 
-	body, err := io.ReadAll(res.Body)
-	if err != nil {
-		return nil, err
+	// A key ID unknown to fresh keys is only worth refetching for once in a
+	// while.
+	if !ok && now.Before(gpki.expiresAt) && now.Sub(gpki.refreshedAt) < MIN_REFRESH_INTERVAL {
+		return nil, ErrKeyNotFound
 	}
-	defer res.Body.Close()
 
-	var d *dtoPayload
-	err = json.Unmarshal(body, &d)
-	if err != nil {
+	if err := gpki.refresh(now); err != nil {
 		return nil, err
 	}
 
-	return d.Keys, nil
+	key, ok = gpki.keys[kid]
+	if !ok {
+		return nil, ErrKeyNotFound
+	}
+
+	return key, nil
 }
 
-func (gpki GooglePKI) GetPublicKeyForKid(kid string) (*GooglePublicKey, error) {
-	keys, err := gpki.GetPublicKeys()
+// refresh refetches the keys. The caller holds the lock.
+func (gpki *GooglePKI) refresh(now time.Time) error {
+	keys, maxAge, err := gpki.GetPublicKeys()
 	if err != nil {
-		return nil, err
+		return err
 	}
 
+	rsaKeys := map[string]*rsa.PublicKey{}
 	for _, key := range keys {
-		if key.Kid == kid {
-			return &key, nil
+		rsaKey, err := key.RSAPublicKey()
+		if err != nil {
+			return fmt.Errorf("invalid key %v: %w", key.Kid, err)
 		}
+		rsaKeys[key.Kid] = rsaKey
 	}
-	return nil, errors.New("key not found")
+
+	gpki.keys = rsaKeys
+	gpki.expiresAt = now.Add(maxAge)
+	gpki.refreshedAt = now
+	return nil
+}
+
+// GetPublicKeys fetches the current public keys, and how long they can be
+// cached for.
+func (gpki *GooglePKI) GetPublicKeys() ([]GooglePublicKey, time.Duration, error) {
+	type dtoPayload struct {
+		Keys []GooglePublicKey `json:"keys"`
+	}
+
+	req, err := http.NewRequest("GET", gpki.KeysURL, nil)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	res, err := gpki.HTTP.Do(req)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer res.Body.Close()
+
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		return nil, 0, err
+	}
+	if res.StatusCode != http.StatusOK {
+		return nil, 0, fmt.Errorf("failed to get public keys: status %v", res.StatusCode)
+	}
+
+	var d dtoPayload
+	if err := json.Unmarshal(body, &d); err != nil {
+		return nil, 0, err
+	}
+	if len(d.Keys) == 0 {
+		return nil, 0, errors.New("no public keys")
+	}
+
+	return d.Keys, maxAge(res.Header.Get("Cache-Control")), nil
+}
+
+// maxAge returns the max-age directive of a Cache-Control header, or
+// DEFAULT_MAX_AGE if there is none.
+func maxAge(cacheControl string) time.Duration {
+	for _, directive := range strings.Split(cacheControl, ",") {
+		name, value, found := strings.Cut(strings.TrimSpace(directive), "=")
+		if !found || !strings.EqualFold(name, "max-age") {
+			continue
+		}
+
+		seconds, err := strconv.Atoi(strings.Trim(value, `"`))
+		if err != nil || seconds < 0 {
+			break
+		}
+		return time.Duration(seconds) * time.Second
+	}
+
+	return DEFAULT_MAX_AGE
 }
 
 type GooglePublicKey struct {
@@ -56,3 +164,30 @@ type GooglePublicKey struct {
 	Alg string `json:"alg"`
 	Kid string `json:"kid"`
 }
+
+// RSAPublicKey decodes the key's modulus and exponent.
+func (key GooglePublicKey) RSAPublicKey() (*rsa.PublicKey, error) {
+	if key.Kty != "RSA" {
+		return nil, fmt.Errorf("unexpected key type %q", key.Kty)
+	}
+
+	n, err := base64.RawURLEncoding.DecodeString(key.N)
+	if err != nil {
+		return nil, err
+	}
+
+	e, err := base64.RawURLEncoding.DecodeString(key.E)
+	if err != nil {
+		return nil, err
+	}
+
+	exponent := new(big.Int).SetBytes(e)
+	if !exponent.IsInt64() || exponent.Int64() > 1<<31-1 {
+		return nil, errors.New("exponent out of range")
+	}
+
+	return &rsa.PublicKey{
+		N: new(big.Int).SetBytes(n),
+		E: int(exponent.Int64()),
+	}, nil
+}
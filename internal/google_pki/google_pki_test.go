@@ -0,0 +1,259 @@
+package google_pki
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const testProjectID = "cofin-test"
+
+var (
+	testKeysOnce sync.Once
+	testKeys     []*rsa.PrivateKey
+)
+
+// testKey returns one of a few RSA keys generated once for all tests.
+func testKey(t *testing.T, i int) *rsa.PrivateKey {
+	t.Helper()
+
+	testKeysOnce.Do(func() {
+		for i := 0; i < 2; i++ {
+			key, err := rsa.GenerateKey(rand.Reader, 2048)
+			if err != nil {
+				panic(err)
+			}
+			testKeys = append(testKeys, key)
+		}
+	})
+
+	return testKeys[i]
+}
+
+// keyServer serves public keys as Google does.
+type keyServer struct {
+	mu           sync.Mutex
+	keys         map[string]*rsa.PrivateKey
+	cacheControl string
+	requests     int
+}
+
+func (s *keyServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.requests++
+	var keys []GooglePublicKey
+	for kid, key := range s.keys {
+		keys = append(keys, GooglePublicKey{
+			Use: "sig",
+			Kty: "RSA",
+			Alg: "RS256",
+			Kid: kid,
+			N:   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		})
+	}
+
+	w.Header().Set("Cache-Control", s.cacheControl)
+	json.NewEncoder(w).Encode(map[string]interface{}{"keys": keys})
+}
+
+func (s *keyServer) setKeys(keys map[string]*rsa.PrivateKey) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.keys = keys
+}
+
+func (s *keyServer) requestCount() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.requests
+}
+
+func newTestPKI(t *testing.T, keys map[string]*rsa.PrivateKey) (*GooglePKI, *keyServer) {
+	t.Helper()
+
+	keyServer := &keyServer{keys: keys, cacheControl: "public, max-age=19000, must-revalidate, no-transform"}
+	server := httptest.NewServer(keyServer)
+	t.Cleanup(server.Close)
+
+	gpki := NewGooglePKI()
+	gpki.KeysURL = server.URL
+	return gpki, keyServer
+}
+
+// validClaims returns the claims of a token Firebase would issue now.
+func validClaims() jwt.MapClaims {
+	now := time.Now()
+	return jwt.MapClaims{
+		"iss":            "https://securetoken.google.com/" + testProjectID,
+		"aud":            testProjectID,
+		"sub":            "firebase-uid",
+		"iat":            now.Add(-time.Minute).Unix(),
+		"exp":            now.Add(time.Hour).Unix(),
+		"auth_time":      now.Add(-time.Hour).Unix(),
+		"email":          "user@example.com",
+		"email_verified": true,
+	}
+}
+
+func sign(t *testing.T, key *rsa.PrivateKey, kid string, claims jwt.MapClaims) string {
+	t.Helper()
+
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = kid
+	signed, err := token.SignedString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return signed
+}
+
+func TestVerifyIDToken(t *testing.T) {
+	gpki, _ := newTestPKI(t, map[string]*rsa.PrivateKey{"key-1": testKey(t, 0)})
+
+	claims, err := gpki.VerifyIDToken(sign(t, testKey(t, 0), "key-1", validClaims()), testProjectID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims.Subject != "firebase-uid" || claims.Email != "user@example.com" || !claims.EmailVerified {
+		t.Errorf("claims = %+v", claims)
+	}
+}
+
+func TestVerifyIDTokenRejects(t *testing.T) {
+	gpki, _ := newTestPKI(t, map[string]*rsa.PrivateKey{"key-1": testKey(t, 0)})
+	now := time.Now()
+
+	tests := []struct {
+		name   string
+		change func(claims jwt.MapClaims)
+		key    *rsa.PrivateKey
+		want   error
+	}{
+		{"wrong audience", func(c jwt.MapClaims) { c["aud"] = "other-project" }, nil, jwt.ErrTokenInvalidAudience},
+		{"wrong issuer", func(c jwt.MapClaims) { c["iss"] = "https://securetoken.google.com/other-project" }, nil, jwt.ErrTokenInvalidIssuer},
+		{"expired", func(c jwt.MapClaims) { c["exp"] = now.Add(-2 * CLOCK_SKEW).Unix() }, nil, jwt.ErrTokenExpired},
+		{"missing expiry", func(c jwt.MapClaims) { delete(c, "exp") }, nil, ErrMissingClaim},
+		{"issued in the future", func(c jwt.MapClaims) { c["iat"] = now.Add(2 * CLOCK_SKEW).Unix() }, nil, jwt.ErrTokenUsedBeforeIssued},
+		{"authenticated in the future", func(c jwt.MapClaims) { c["auth_time"] = now.Add(2 * CLOCK_SKEW).Unix() }, nil, ErrInvalidAuthTime},
+		{"missing auth time", func(c jwt.MapClaims) { delete(c, "auth_time") }, nil, ErrMissingClaim},
+		{"missing subject", func(c jwt.MapClaims) { delete(c, "sub") }, nil, ErrMissingClaim},
+		{"unverified email", func(c jwt.MapClaims) { c["email_verified"] = false }, nil, ErrUnverifiedEmail},
+		{"missing email", func(c jwt.MapClaims) { delete(c, "email") }, nil, ErrMissingClaim},
+		{"signed with another key", func(c jwt.MapClaims) {}, testKey(t, 1), jwt.ErrTokenSignatureInvalid},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims := validClaims()
+			tt.change(claims)
+			key := tt.key
+			if key == nil {
+				key = testKey(t, 0)
+			}
+
+			_, err := gpki.VerifyIDToken(sign(t, key, "key-1", claims), testProjectID)
+			if !errors.Is(err, tt.want) {
+				t.Errorf("error = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestVerifyIDTokenRejectsOtherAlgorithms(t *testing.T) {
+	gpki, _ := newTestPKI(t, map[string]*rsa.PrivateKey{"key-1": testKey(t, 0)})
+
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, validClaims())
+	token.Header["kid"] = "key-1"
+	signed, err := token.SignedString([]byte("secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := gpki.VerifyIDToken(signed, testProjectID); !errors.Is(err, jwt.ErrTokenSignatureInvalid) {
+		t.Errorf("error = %v, want an invalid signature", err)
+	}
+}
+
+func TestPublicKeysCachedForMaxAge(t *testing.T) {
+	gpki, server := newTestPKI(t, map[string]*rsa.PrivateKey{"key-1": testKey(t, 0)})
+
+	token := sign(t, testKey(t, 0), "key-1", validClaims())
+	for i := 0; i < 3; i++ {
+		if _, err := gpki.VerifyIDToken(token, testProjectID); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := server.requestCount(); n != 1 {
+		t.Errorf("keys fetched %v times, want once", n)
+	}
+	if ttl := time.Until(gpki.expiresAt); ttl < 18990*time.Second || ttl > 19000*time.Second {
+		t.Errorf("keys expire in %v, want the max-age of 19000s", ttl)
+	}
+
+	// Expired keys are refetched.
+	gpki.expiresAt = time.Now().Add(-time.Second)
+	if _, err := gpki.VerifyIDToken(token, testProjectID); err != nil {
+		t.Fatal(err)
+	}
+	if n := server.requestCount(); n != 2 {
+		t.Errorf("keys fetched %v times, want twice after expiry", n)
+	}
+}
+
+func TestUnknownKidRefetchesKeys(t *testing.T) {
+	gpki, server := newTestPKI(t, map[string]*rsa.PrivateKey{"key-1": testKey(t, 0)})
+
+	if _, err := gpki.VerifyIDToken(sign(t, testKey(t, 0), "key-1", validClaims()), testProjectID); err != nil {
+		t.Fatal(err)
+	}
+
+	// Google rotates in a new key before the cached keys expire.
+	server.setKeys(map[string]*rsa.PrivateKey{"key-1": testKey(t, 0), "key-2": testKey(t, 1)})
+	gpki.refreshedAt = time.Now().Add(-MIN_REFRESH_INTERVAL)
+	if _, err := gpki.VerifyIDToken(sign(t, testKey(t, 1), "key-2", validClaims()), testProjectID); err != nil {
+		t.Fatalf("token signed with a rotated in key: %v", err)
+	}
+	if n := server.requestCount(); n != 2 {
+		t.Errorf("keys fetched %v times, want a refetch for the unknown kid", n)
+	}
+
+	// Unknown key IDs right after a refetch do not refetch again.
+	if _, err := gpki.VerifyIDToken(sign(t, testKey(t, 1), "key-3", validClaims()), testProjectID); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("error = %v, want ErrKeyNotFound", err)
+	}
+	if n := server.requestCount(); n != 2 {
+		t.Errorf("keys fetched %v times, want no refetch within MIN_REFRESH_INTERVAL", n)
+	}
+}
+
+func TestMaxAge(t *testing.T) {
+	tests := []struct {
+		cacheControl string
+		want         time.Duration
+	}{
+		{"public, max-age=19000, must-revalidate, no-transform", 19000 * time.Second},
+		{"Max-Age=60", time.Minute},
+		{`max-age="120"`, 2 * time.Minute},
+		{"no-cache", DEFAULT_MAX_AGE},
+		{"max-age=-1", DEFAULT_MAX_AGE},
+		{"", DEFAULT_MAX_AGE},
+	}
+	for _, tt := range tests {
+		if got := maxAge(tt.cacheControl); got != tt.want {
+			t.Errorf("maxAge(%q) = %v, want %v", tt.cacheControl, got, tt.want)
+		}
+	}
+}
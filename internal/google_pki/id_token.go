@@ -0,0 +1,80 @@
+package google_pki
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// CLOCK_SKEW is how far the clocks of Google and ours may be apart when
+// checking the times of a token.
+const CLOCK_SKEW = time.Minute
+
+var (
+	ErrMissingClaim    = errors.New("token is missing a claim")
+	ErrInvalidAuthTime = errors.New("token was authenticated in the future")
+	ErrUnverifiedEmail = errors.New("email is not verified")
+)
+
+// FirebaseClaims are the claims of a Firebase ID token we use.
+type FirebaseClaims struct {
+	jwt.RegisteredClaims
+
+	Email         string `json:"email"`
+	EmailVerified bool   `json:"email_verified"`
+	Name          string `json:"name"`
+	// Time the user authenticated, in seconds since the epoch.
+	AuthTime int64 `json:"auth_time"`
+}
+
+// VerifyIDToken verifies that a Firebase ID token was signed by Google for the
+// Firebase project, is current, and is of a user with a verified email, and
+// returns its claims.
+func (gpki *GooglePKI) VerifyIDToken(idToken, projectID string) (*FirebaseClaims, error) {
+	if projectID == "" {
+		return nil, errors.New("no Firebase project to verify tokens for")
+	}
+
+	var claims FirebaseClaims
+	_, err := jwt.ParseWithClaims(idToken, &claims, func(token *jwt.Token) (interface{}, error) {
+		kid, ok := token.Header["kid"].(string)
+		if !ok || kid == "" {
+			return nil, fmt.Errorf("%w: kid", ErrMissingClaim)
+		}
+
+		return gpki.GetPublicKeyForKid(kid)
+	},
+		jwt.WithValidMethods([]string{jwt.SigningMethodRS256.Alg()}),
+		jwt.WithAudience(projectID),
+		jwt.WithIssuer("https://securetoken.google.com/"+projectID),
+		jwt.WithIssuedAt(),
+		jwt.WithLeeway(CLOCK_SKEW),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	// The parser only checks the expiry if there is one.
+	if claims.ExpiresAt == nil {
+		return nil, fmt.Errorf("%w: exp", ErrMissingClaim)
+	}
+	if claims.Subject == "" {
+		return nil, fmt.Errorf("%w: sub", ErrMissingClaim)
+	}
+	if claims.AuthTime == 0 {
+		return nil, fmt.Errorf("%w: auth_time", ErrMissingClaim)
+	}
+	if time.Unix(claims.AuthTime, 0).After(time.Now().Add(CLOCK_SKEW)) {
+		return nil, ErrInvalidAuthTime
+	}
+	if claims.Email == "" {
+		return nil, fmt.Errorf("%w: email", ErrMissingClaim)
+	}
+	if !claims.EmailVerified {
+		return nil, ErrUnverifiedEmail
+	}
+
+	return &claims, nil
+}
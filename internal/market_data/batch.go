@@ -0,0 +1,128 @@
+package market_data
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// BatchProvider is a provider that fetches the quotes of several tickers with
+// one request.
+type BatchProvider interface {
+	Provider
+	// MaxBatchSize is the most tickers GetQuotes accepts at once.
+	MaxBatchSize() int
+	// GetQuotes returns the quotes of the tickers it has quotes of, keyed by
+	// upper-case ticker.
+	GetQuotes(tickers []string) (map[string]*Quote, error)
+}
+
+// GetQuotes fetches the quotes of many tickers, using at most workers requests
+// at a time. Providers that fetch quotes in batches are sent batches; the
+// others are sent a request per ticker. A fallback provider asks each of its
+// providers in turn for the quotes the ones before it did not have. It returns
+// the quotes keyed by upper-case ticker and the errors of tickers without
+// quotes.
+func GetQuotes(provider Provider, tickers []string, workers int) (quotes map[string]*Quote, errs map[string]error) {
+	quotes, errs = map[string]*Quote{}, map[string]error{}
+	if workers < 1 {
+		workers = 1
+	}
+
+	if fallback, ok := provider.(Fallback); ok {
+		remaining := tickers
+		for _, provider := range fallback {
+			if len(remaining) == 0 {
+				break
+			}
+
+			found, failed := GetQuotes(provider, remaining, workers)
+			remaining = nil
+			for _, ticker := range tickers {
+				key := strings.ToUpper(ticker)
+				if quote, ok := found[key]; ok {
+					quotes[key] = quote
+					delete(errs, key)
+				} else if err, ok := failed[key]; ok {
+					errs[key] = joinErrors(errs[key], fmt.Errorf("%v: %w", provider.Name(), err))
+					remaining = append(remaining, ticker)
+				}
+			}
+		}
+
+		return quotes, errs
+	}
+
+	var mutex sync.Mutex
+	record := func(ticker string, quote *Quote, err error) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		if err != nil {
+			errs[strings.ToUpper(ticker)] = err
+		} else {
+			quotes[strings.ToUpper(ticker)] = quote
+		}
+	}
+
+	// Each job is a batch of tickers, or a single ticker for providers that
+	// do not fetch in batches.
+	var batches [][]string
+	batchProvider, isBatch := provider.(BatchProvider)
+	if isBatch {
+		size := batchProvider.MaxBatchSize()
+		for start := 0; start < len(tickers); start += size {
+			end := start + size
+			if end > len(tickers) {
+				end = len(tickers)
+			}
+			batches = append(batches, tickers[start:end])
+		}
+	} else {
+		for _, ticker := range tickers {
+			batches = append(batches, []string{ticker})
+		}
+	}
+
+	jobs := make(chan []string)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for batch := range jobs {
+				if !isBatch {
+					quote, err := provider.GetQuote(batch[0])
+					record(batch[0], quote, err)
+					continue
+				}
+
+				found, err := batchProvider.GetQuotes(batch)
+				for _, ticker := range batch {
+					if err != nil {
+						record(ticker, nil, err)
+					} else if quote, ok := found[strings.ToUpper(ticker)]; ok {
+						record(ticker, quote, nil)
+					} else {
+						record(ticker, nil, fmt.Errorf("no quote of %v", ticker))
+					}
+				}
+			}
+		}()
+	}
+
+	for _, batch := range batches {
+		jobs <- batch
+	}
+	close(jobs)
+	wg.Wait()
+
+	return quotes, errs
+}
+
+func joinErrors(err, next error) error {
+	if err == nil {
+		return next
+	}
+
+	return fmt.Errorf("%v; %w", err, next)
+}
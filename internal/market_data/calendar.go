@@ -0,0 +1,162 @@
+package market_data
+
+import (
+	"time"
+	// Embed the time zone database, as containers may not have one.
+	_ "time/tzdata"
+)
+
+// US exchanges trade from 9:30 to 16:00 New York time on weekdays other than
+// holidays, and close at 13:00 on a few days around holidays.
+var newYork = mustLoadLocation("America/New_York")
+
+func mustLoadLocation(name string) *time.Location {
+	location, err := time.LoadLocation(name)
+	if err != nil {
+		panic(err)
+	}
+
+	return location
+}
+
+// MarketHours returns the times the US stock market opens and closes on the
+// day of t in New York. ok is false if the market does not open that day.
+func MarketHours(t time.Time) (open, close time.Time, ok bool) {
+	t = t.In(newYork)
+	year, month, day := t.Date()
+	date := time.Date(year, month, day, 0, 0, 0, 0, newYork)
+	if !IsTradingDay(date) {
+		return time.Time{}, time.Time{}, false
+	}
+
+	open = time.Date(year, month, day, 9, 30, 0, 0, newYork)
+	close = time.Date(year, month, day, 16, 0, 0, 0, newYork)
+	if isEarlyClose(date) {
+		close = time.Date(year, month, day, 13, 0, 0, 0, newYork)
+	}
+
+	return open, close, true
+}
+
+// IsMarketOpen reports whether the US stock market is open at t.
+func IsMarketOpen(t time.Time) bool {
+	open, close, ok := MarketHours(t)
+	return ok && !t.Before(open) && t.Before(close)
+}
+
+// NextMarketOpen returns the next time after t the US stock market opens.
+func NextMarketOpen(t time.Time) time.Time {
+	for day := t.In(newYork); ; day = day.AddDate(0, 0, 1) {
+		if open, _, ok := MarketHours(day); ok && open.After(t) {
+			return open
+		}
+	}
+}
+
+// IsTradingDay reports whether the US stock market opens on the date, in New
+// York.
+func IsTradingDay(date time.Time) bool {
+	if date.Weekday() == time.Saturday || date.Weekday() == time.Sunday {
+		return false
+	}
+
+	for _, holiday := range holidays(date.Year()) {
+		if sameDate(holiday, date) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// holidays returns the dates the NYSE is closed for holidays in a year, as
+// observed. Holidays on Saturday are observed on Friday, except New Year's
+// Day, and holidays on Sunday on Monday.
+func holidays(year int) []time.Time {
+	date := func(month time.Month, day int) time.Time {
+		return time.Date(year, month, day, 0, 0, 0, 0, newYork)
+	}
+
+	days := []time.Time{
+		observed(date(time.January, 1)),
+		nthWeekday(year, time.January, time.Monday, 3),  // Martin Luther King Jr. Day
+		nthWeekday(year, time.February, time.Monday, 3), // Washington's Birthday
+		easter(year).AddDate(0, 0, -2),                  // Good Friday
+		lastWeekday(year, time.May, time.Monday),        // Memorial Day
+		observed(date(time.July, 4)),
+		nthWeekday(year, time.September, time.Monday, 1),  // Labor Day
+		nthWeekday(year, time.November, time.Thursday, 4), // Thanksgiving
+		observed(date(time.December, 25)),
+	}
+	if year >= 2022 {
+		days = append(days, observed(date(time.June, 19))) // Juneteenth
+	}
+
+	return days
+}
+
+// isEarlyClose reports whether the market closes at 13:00 on the date: the day
+// before Independence Day, the day after Thanksgiving and Christmas Eve, when
+// they are trading days.
+func isEarlyClose(date time.Time) bool {
+	year := date.Year()
+	july3 := time.Date(year, time.July, 3, 0, 0, 0, 0, newYork)
+	christmasEve := time.Date(year, time.December, 24, 0, 0, 0, 0, newYork)
+	dayAfterThanksgiving := nthWeekday(year, time.November, time.Thursday, 4).AddDate(0, 0, 1)
+
+	return sameDate(date, july3) || sameDate(date, christmasEve) || sameDate(date, dayAfterThanksgiving)
+}
+
+func observed(holiday time.Time) time.Time {
+	switch holiday.Weekday() {
+	case time.Saturday:
+		// New Year's Day on a Saturday is not observed, as the Friday before
+		// ends the previous year's books.
+		if holiday.Month() == time.January && holiday.Day() == 1 {
+			return time.Time{}
+		}
+		return holiday.AddDate(0, 0, -1)
+	case time.Sunday:
+		return holiday.AddDate(0, 0, 1)
+	default:
+		return holiday
+	}
+}
+
+// nthWeekday returns the nth weekday of a month, e.g. the third Monday.
+func nthWeekday(year int, month time.Month, weekday time.Weekday, n int) time.Time {
+	first := time.Date(year, month, 1, 0, 0, 0, 0, newYork)
+	offset := (int(weekday) - int(first.Weekday()) + 7) % 7
+	return first.AddDate(0, 0, offset+7*(n-1))
+}
+
+// lastWeekday returns the last weekday of a month, e.g. the last Monday.
+func lastWeekday(year int, month time.Month, weekday time.Weekday) time.Time {
+	last := time.Date(year, month+1, 0, 0, 0, 0, 0, newYork)
+	offset := (int(last.Weekday()) - int(weekday) + 7) % 7
+	return last.AddDate(0, 0, -offset)
+}
+
+// easter returns the date of Easter Sunday in the Gregorian calendar, using the
+// anonymous Gregorian algorithm.
+func easter(year int) time.Time {
+	a := year % 19
+	b, c := year/100, year%100
+	d, e := b/4, b%4
+	f := (b + 8) / 25
+	g := (b - f + 1) / 3
+	h := (19*a + b - d - g + 15) % 30
+	i, k := c/4, c%4
+	l := (32 + 2*e + 2*i - h - k) % 7
+	m := (a + 11*h + 22*l) / 451
+	month := (h + l - 7*m + 114) / 31
+	day := (h+l-7*m+114)%31 + 1
+
+	return time.Date(year, time.Month(month), day, 0, 0, 0, 0, newYork)
+}
+
+func sameDate(a, b time.Time) bool {
+	ay, am, ad := a.Date()
+	by, bm, bd := b.In(a.Location()).Date()
+	return ay == by && am == bm && ad == bd
+}
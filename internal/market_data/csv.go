@@ -0,0 +1,138 @@
+package market_data
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CSV provides quotes read from a file, for use without network access. The
+// file has a header row naming its columns: ticker and price are required,
+// and currency, change, total_volume and as_of are optional. as_of is a date
+// (YYYY-MM-DD) or an RFC 3339 time, and defaults to the file's modification
+// time.
+type CSV struct {
+	quotes map[string]Quote
+}
+
+// NewCSV reads the quotes in the file at path.
+func NewCSV(path string) (*CSV, error) {
+	if path == "" {
+		return nil, fmt.Errorf("MARKET_DATA_CSV is not set")
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	reader := csv.NewReader(file)
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read header of %v: %w", path, err)
+	}
+
+	columns := map[string]int{}
+	for i, name := range header {
+		columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	for _, required := range []string{"ticker", "price"} {
+		if _, ok := columns[required]; !ok {
+			return nil, fmt.Errorf("%v has no %v column", path, required)
+		}
+	}
+
+	c := &CSV{quotes: map[string]Quote{}}
+	for line := 2; ; line++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("failed to read %v: %w", path, err)
+		}
+
+		field := func(name string) string {
+			if i, ok := columns[name]; ok && i < len(record) {
+				return strings.TrimSpace(record[i])
+			}
+			return ""
+		}
+		number := func(name string) (float64, error) {
+			if field(name) == "" {
+				return 0, nil
+			}
+			value, err := strconv.ParseFloat(field(name), 64)
+			if err != nil {
+				return 0, fmt.Errorf("invalid %v on line %v of %v: %w", name, line, path, err)
+			}
+			return value, nil
+		}
+
+		quote := Quote{Currency: field("currency"), AsOf: info.ModTime(), Source: c.Name()}
+		if field("price") == "" {
+			return nil, fmt.Errorf("no price on line %v of %v", line, path)
+		}
+		if quote.Price, err = number("price"); err != nil {
+			return nil, err
+		}
+		if quote.Change, err = number("change"); err != nil {
+			return nil, err
+		}
+		if quote.TotalVolume, err = number("total_volume"); err != nil {
+			return nil, err
+		}
+
+		if asOf := field("as_of"); asOf != "" {
+			if quote.AsOf, err = time.Parse(time.RFC3339, asOf); err != nil {
+				if quote.AsOf, err = time.Parse("2006-01-02", asOf); err != nil {
+					return nil, fmt.Errorf("invalid as_of on line %v of %v: %w", line, path, err)
+				}
+			}
+		}
+
+		c.quotes[strings.ToUpper(field("ticker"))] = quote
+	}
+
+	return c, nil
+}
+
+func (c *CSV) Name() string {
+	return "csv"
+}
+
+func (c *CSV) GetQuote(ticker string) (*Quote, error) {
+	quote, ok := c.quotes[strings.ToUpper(ticker)]
+	if !ok {
+		return nil, fmt.Errorf("no quote of %v in the file", ticker)
+	}
+
+	return &quote, nil
+}
+
+// Quotes are looked up in memory, so batches can be large.
+func (c *CSV) MaxBatchSize() int {
+	return 1000
+}
+
+func (c *CSV) GetQuotes(tickers []string) (map[string]*Quote, error) {
+	quotes := map[string]*Quote{}
+	for _, ticker := range tickers {
+		if quote, err := c.GetQuote(ticker); err == nil {
+			quotes[strings.ToUpper(ticker)] = quote
+		}
+	}
+
+	return quotes, nil
+}
@@ -0,0 +1,53 @@
+package market_data
+
+import (
+	"cofin/internal/ratelimit"
+	"context"
+)
+
+// limitedProvider waits on a rate limiter before every call to the provider.
+type limitedProvider struct {
+	provider Provider
+	limiter  *ratelimit.Limiter
+}
+
+// limitedBatchProvider is a limitedProvider of a provider that fetches quotes
+// in batches. A batch counts as one call.
+type limitedBatchProvider struct {
+	limitedProvider
+}
+
+// NewLimitedProvider wraps a provider so that it is called no faster than the
+// limiter allows. The wrapper fetches quotes in batches if the provider does.
+func NewLimitedProvider(provider Provider, limiter *ratelimit.Limiter) Provider {
+	limited := limitedProvider{provider: provider, limiter: limiter}
+	if _, ok := provider.(BatchProvider); ok {
+		return limitedBatchProvider{limited}
+	}
+
+	return limited
+}
+
+func (p limitedProvider) Name() string {
+	return p.provider.Name()
+}
+
+func (p limitedProvider) GetQuote(ticker string) (*Quote, error) {
+	if err := p.limiter.Wait(context.Background()); err != nil {
+		return nil, err
+	}
+
+	return p.provider.GetQuote(ticker)
+}
+
+func (p limitedBatchProvider) MaxBatchSize() int {
+	return p.provider.(BatchProvider).MaxBatchSize()
+}
+
+func (p limitedBatchProvider) GetQuotes(tickers []string) (map[string]*Quote, error) {
+	if err := p.limiter.Wait(context.Background()); err != nil {
+		return nil, err
+	}
+
+	return p.provider.(BatchProvider).GetQuotes(tickers)
+}
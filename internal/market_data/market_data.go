@@ -0,0 +1,251 @@
+// Package market_data fetches the latest quotes of companies from one of
+// several market data providers.
+package market_data
+
+import (
+	"cofin/core"
+	"cofin/internal/ratelimit"
+	"cofin/internal/real_stonks"
+	"cofin/internal/yahoo_finance"
+	"cofin/models"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Quote is the latest trade of a company's primary security.
+type Quote struct {
+	// Empty if the provider does not tell the currency.
+	Currency string
+	Price    float64
+	// Change since the previous close, in percent.
+	Change      float64
+	TotalVolume float64
+	// Time of the quote.
+	AsOf time.Time
+	// Name of the provider the quote came from.
+	Source string
+}
+
+// Provider is a source of quotes.
+type Provider interface {
+	// Name identifies the provider in the quotes it returns.
+	Name() string
+	GetQuote(ticker string) (*Quote, error)
+}
+
+// Fallback asks its providers for a quote in order and returns the first one
+// any of them has.
+type Fallback []Provider
+
+func (f Fallback) Name() string {
+	names := make([]string, len(f))
+	for i, provider := range f {
+		names[i] = provider.Name()
+	}
+
+	return strings.Join(names, ",")
+}
+
+func (f Fallback) GetQuote(ticker string) (*Quote, error) {
+	var errs []error
+	for _, provider := range f {
+		quote, err := provider.GetQuote(ticker)
+		if err == nil {
+			return quote, nil
+		}
+
+		errs = append(errs, fmt.Errorf("%v: %w", provider.Name(), err))
+	}
+
+	return nil, errors.Join(errs...)
+}
+
+// NewProvider returns the providers named in the MARKET_DATA_PROVIDERS
+// environment variable, comma-separated and in order of preference, falling
+// back from one to the next. The providers are real_stonks, which requires
+// RAPID_API_KEY, yahoo_finance, and csv, which reads quotes from the file at
+// MARKET_DATA_CSV. By default, Real Stonks falls back to Yahoo Finance.
+//
+// Calls to Real Stonks and Yahoo Finance are limited to
+// REAL_STONKS_REQUESTS_PER_SECOND and YAHOO_FINANCE_REQUESTS_PER_SECOND.
+func NewProvider() (Provider, error) {
+	realStonksRequestsPerSecond, err := core.GetEnvFloat("REAL_STONKS_REQUESTS_PER_SECOND", 5)
+	if err != nil {
+		return nil, err
+	}
+	yahooFinanceLimiter, err := YahooFinanceLimiter()
+	if err != nil {
+		return nil, err
+	}
+
+	names := os.Getenv("MARKET_DATA_PROVIDERS")
+	if names == "" {
+		names = "real_stonks,yahoo_finance"
+	}
+
+	var providers Fallback
+	for _, name := range strings.Split(names, ",") {
+		switch strings.TrimSpace(name) {
+		case "real_stonks":
+			provider := RealStonks{real_stonks.NewRealStonks(os.Getenv("RAPID_API_KEY"))}
+			providers = append(providers, NewLimitedProvider(provider, ratelimit.NewLimiter(realStonksRequestsPerSecond, 1)))
+		case "yahoo_finance":
+			provider := YahooFinance{yahoo_finance.NewYahooFinance()}
+			providers = append(providers, NewLimitedProvider(provider, yahooFinanceLimiter))
+		case "csv":
+			csv, err := NewCSV(os.Getenv("MARKET_DATA_CSV"))
+			if err != nil {
+				return nil, err
+			}
+			providers = append(providers, csv)
+		case "":
+		default:
+			return nil, fmt.Errorf("unknown market data provider %q", name)
+		}
+	}
+
+	if len(providers) == 0 {
+		return nil, fmt.Errorf("no market data providers configured")
+	} else if len(providers) == 1 {
+		return providers[0], nil
+	}
+
+	return providers, nil
+}
+
+var yahooFinanceLimiter struct {
+	once    sync.Once
+	limiter *ratelimit.Limiter
+	err     error
+}
+
+// YahooFinanceLimiter returns the limiter of all calls to Yahoo Finance in the
+// process, quotes and daily prices alike, which allows
+// YAHOO_FINANCE_REQUESTS_PER_SECOND.
+func YahooFinanceLimiter() (*ratelimit.Limiter, error) {
+	yahooFinanceLimiter.once.Do(func() {
+		perSecond, err := core.GetEnvFloat("YAHOO_FINANCE_REQUESTS_PER_SECOND", 2)
+		yahooFinanceLimiter.limiter, yahooFinanceLimiter.err = ratelimit.NewLimiter(perSecond, 1), err
+	})
+
+	return yahooFinanceLimiter.limiter, yahooFinanceLimiter.err
+}
+
+// RealStonks provides quotes from the Real Stonks API.
+type RealStonks struct {
+	*real_stonks.RealStonks
+}
+
+func (rs RealStonks) Name() string {
+	return "real_stonks"
+}
+
+func (rs RealStonks) GetQuote(ticker string) (*Quote, error) {
+	information, err := rs.GetMarketData(ticker)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Quote{
+		Currency:    information.Currency,
+		Price:       information.Price,
+		Change:      information.Change,
+		TotalVolume: information.TotalVolume,
+		AsOf:        information.AsOf,
+		Source:      rs.Name(),
+	}, nil
+}
+
+// YahooFinance provides quotes from the Yahoo Finance chart API.
+type YahooFinance struct {
+	*yahoo_finance.YahooFinance
+}
+
+func (yf YahooFinance) Name() string {
+	return "yahoo_finance"
+}
+
+func (yf YahooFinance) GetQuote(ticker string) (*Quote, error) {
+	quote, err := yf.YahooFinance.GetQuote(ticker)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Quote{
+		Currency:    quote.Currency,
+		Price:       quote.Price,
+		Change:      quote.Change,
+		TotalVolume: float64(quote.Volume),
+		AsOf:        quote.AsOf,
+		Source:      yf.Name(),
+	}, nil
+}
+
+func (yf YahooFinance) MaxBatchSize() int {
+	return yahoo_finance.MAX_QUOTES_PER_REQUEST
+}
+
+func (yf YahooFinance) GetQuotes(tickers []string) (map[string]*Quote, error) {
+	found, err := yf.YahooFinance.GetQuotes(tickers)
+	if err != nil {
+		return nil, err
+	}
+
+	quotes := map[string]*Quote{}
+	for ticker, quote := range found {
+		quotes[ticker] = &Quote{
+			Currency:    quote.Currency,
+			Price:       quote.Price,
+			Change:      quote.Change,
+			TotalVolume: float64(quote.Volume),
+			AsOf:        quote.AsOf,
+			Source:      yf.Name(),
+		}
+	}
+
+	return quotes, nil
+}
+
+// UpdateCompanyQuote fetches the latest quote of a company and stores it on the
+// company.
+func UpdateCompanyQuote(db *gorm.DB, provider Provider, company *models.Company) (*Quote, error) {
+	quote, err := provider.GetQuote(company.Ticker)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := SaveCompanyQuote(db, company, quote); err != nil {
+		return nil, err
+	}
+
+	return quote, nil
+}
+
+// SaveCompanyQuote stores a quote on the company and refreshes its valuation.
+// Only the market data columns are written. The currency is kept if the
+// provider does not tell it.
+func SaveCompanyQuote(db *gorm.DB, company *models.Company, quote *Quote) error {
+	if quote.Currency != "" {
+		company.Currency = quote.Currency
+	}
+	company.Price = quote.Price
+	company.Change = quote.Change
+	company.TotalVolume = quote.TotalVolume
+	company.PriceSource = quote.Source
+	company.PriceAsOf = &quote.AsOf
+
+	if err := models.UpdateCompanyQuote(db, company); err != nil {
+		return err
+	}
+
+	return models.RefreshValuation(db, company)
+}
+
+var _ BatchProvider = YahooFinance{}
+var _ BatchProvider = &CSV{}
@@ -0,0 +1,206 @@
+// Package quote_feed delivers quote updates to the clients of an API server.
+// Fetchers publish updates with Postgres NOTIFY as they store quotes, and every
+// API server LISTENs for them, so that all servers push the same updates.
+package quote_feed
+
+import (
+	"cofin/models"
+	"context"
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/stdlib"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+// RECONNECT_DELAY is how long to wait before listening again after the
+// connection to the database fails.
+const RECONNECT_DELAY = 5 * time.Second
+
+// SUBSCRIPTION_BUFFER is the number of updates held for a subscriber that has
+// not received them yet. Further updates are dropped until it catches up, so
+// a slow client never holds up the others.
+const SUBSCRIPTION_BUFFER = 64
+
+// Feed fans out the quote updates published on models.QUOTE_UPDATES_CHANNEL to
+// its subscribers.
+type Feed struct {
+	db     *gorm.DB
+	logger *zap.SugaredLogger
+
+	mutex         sync.Mutex
+	subscriptions map[*Subscription]struct{}
+}
+
+func NewFeed(db *gorm.DB, logger *zap.SugaredLogger) *Feed {
+	return &Feed{
+		db:            db,
+		logger:        logger,
+		subscriptions: map[*Subscription]struct{}{},
+	}
+}
+
+// Listen receives quote updates from the database and delivers them to
+// subscribers until the context is done. It listens again after errors.
+func (f *Feed) Listen(ctx context.Context) {
+	for {
+		err := f.listen(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+
+		f.logger.Errorf("Stopped listening for quote updates, listening again in %v: %v", RECONNECT_DELAY, err)
+		select {
+		case <-time.After(RECONNECT_DELAY):
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// listen holds a connection of the pool to LISTEN on, until it fails.
+func (f *Feed) listen(ctx context.Context) error {
+	sqlDB, err := f.db.DB()
+	if err != nil {
+		return err
+	}
+
+	conn, err := sqlDB.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	var listenErr error
+	conn.Raw(func(driverConn any) error {
+		pgxConn := driverConn.(*stdlib.Conn).Conn()
+		listenErr = f.receive(ctx, pgxConn)
+
+		// The connection still listens, so it must not go back to the pool.
+		return driver.ErrBadConn
+	})
+
+	return listenErr
+}
+
+func (f *Feed) receive(ctx context.Context, conn *pgx.Conn) error {
+	if _, err := conn.Exec(ctx, "LISTEN "+pgx.Identifier{models.QUOTE_UPDATES_CHANNEL}.Sanitize()); err != nil {
+		return err
+	}
+	f.logger.Infof("Listening for quote updates")
+
+	for {
+		notification, err := conn.WaitForNotification(ctx)
+		if err != nil {
+			return err
+		}
+
+		var update models.QuoteUpdate
+		if err := json.Unmarshal([]byte(notification.Payload), &update); err != nil {
+			f.logger.Errorf("Invalid quote update %q: %v", notification.Payload, err)
+			continue
+		}
+
+		f.publish(update)
+	}
+}
+
+// publish delivers an update to the subscribers of its ticker.
+func (f *Feed) publish(update models.QuoteUpdate) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	for subscription := range f.subscriptions {
+		if !subscription.Has(update.Ticker) {
+			continue
+		}
+
+		select {
+		case subscription.updates <- update:
+		default:
+		}
+	}
+}
+
+// Subscribe returns a subscription to no tickers yet. It must be closed once
+// it is no longer used.
+func (f *Feed) Subscribe() *Subscription {
+	subscription := &Subscription{
+		feed:    f,
+		tickers: map[string]bool{},
+		updates: make(chan models.QuoteUpdate, SUBSCRIPTION_BUFFER),
+	}
+
+	f.mutex.Lock()
+	f.subscriptions[subscription] = struct{}{}
+	f.mutex.Unlock()
+
+	return subscription
+}
+
+// Subscription receives the quote updates of the tickers it is subscribed to.
+type Subscription struct {
+	feed    *Feed
+	mutex   sync.Mutex
+	tickers map[string]bool
+	updates chan models.QuoteUpdate
+}
+
+// Updates returns the channel updates are delivered on.
+func (s *Subscription) Updates() <-chan models.QuoteUpdate {
+	return s.updates
+}
+
+// Add subscribes to tickers. At most max tickers are subscribed to at once.
+func (s *Subscription) Add(tickers []string, max int) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	added := map[string]bool{}
+	for _, ticker := range tickers {
+		if ticker = strings.ToUpper(ticker); !s.tickers[ticker] {
+			added[ticker] = true
+		}
+	}
+	if len(s.tickers)+len(added) > max {
+		return fmt.Errorf("at most %v tickers can be subscribed to", max)
+	}
+
+	for ticker := range added {
+		s.tickers[ticker] = true
+	}
+
+	return nil
+}
+
+// Remove unsubscribes from tickers.
+func (s *Subscription) Remove(tickers []string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for _, ticker := range tickers {
+		delete(s.tickers, strings.ToUpper(ticker))
+	}
+}
+
+// Has reports whether the subscription includes the ticker.
+func (s *Subscription) Has(ticker string) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.tickers[strings.ToUpper(ticker)]
+}
+
+// Close stops delivering updates to the subscription.
+func (s *Subscription) Close() {
+	s.feed.mutex.Lock()
+	defer s.feed.mutex.Unlock()
+
+	delete(s.feed.subscriptions, s)
+}
@@ -5,86 +5,124 @@ import (
 	"fmt"
 	"io"
 	"net/http"
-	"os"
+	"net/url"
 	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/go-retryablehttp"
 )
 
-type RealStonks struct{}
+const DefaultURL = "https://realstonks.p.rapidapi.com"
+
+// RealStonks fetches quotes of US stocks from the Real Stonks API on RapidAPI.
+type RealStonks struct {
+	Key  string
+	URL  string
+	HTTP *http.Client
+}
+
+func NewRealStonks(key string) *RealStonks {
+	client := retryablehttp.NewClient()
+	client.Logger = nil
+
+	return &RealStonks{
+		Key:  key,
+		URL:  DefaultURL,
+		HTTP: client.StandardClient(),
+	}
+}
 
 type TickerInformation struct {
-	Currency    string
-	Price       float64
+	// The API does not tell the currency. It is empty.
+	Currency string
+	Price    float64
+	// Change since the previous close, in percent.
 	Change      float64
 	TotalVolume float64
+	// The API does not tell the time of the quote. It is the time it was
+	// fetched.
+	AsOf time.Time
 }
 
 func (rs RealStonks) GetMarketData(ticker string) (*TickerInformation, error) {
 	type dto struct {
-		Price            float64 `json:"price"`
-		ChangePercentage float64 `json:"change_percentage"`
-		TotalVolume      string  `json:"total_vol"`
+		Price            *float64 `json:"price"`
+		ChangePercentage float64  `json:"change_percentage"`
+		TotalVolume      string   `json:"total_vol"`
 	}
 
-	url := "https://realstonks.p.rapidapi.com/" + ticker
-	req, _ := http.NewRequest("GET", url, nil)
+	req, err := http.NewRequest("GET", rs.URL+"/"+url.PathEscape(ticker), nil)
+	if err != nil {
+		return nil, err
+	}
 
-	req.Header.Add("X-RapidAPI-Key", os.Getenv("RAPID_API_KEY"))
+	req.Header.Add("X-RapidAPI-Key", rs.Key)
 	req.Header.Add("X-RapidAPI-Host", "realstonks.p.rapidapi.com")
 
-	res, _ := http.DefaultClient.Do(req)
+	res, err := rs.HTTP.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
 
 	body, err := io.ReadAll(res.Body)
 	if err != nil {
 		return nil, err
 	}
-	defer res.Body.Close()
 
-	var d *dto
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("real stonks returned status %v for %v: %v", res.StatusCode, ticker, string(body))
+	}
+
+	var d dto
 	err = json.Unmarshal(body, &d)
 	if err != nil {
 		return nil, fmt.Errorf("%v: %w", string(body), err)
 	}
 
-	ti := &TickerInformation{
-		Currency:    "USD",
-		Price:       d.Price,
-		Change:      d.ChangePercentage,
-		TotalVolume: convertToFloat(d.TotalVolume),
+	if d.Price == nil {
+		return nil, fmt.Errorf("real stonks returned no price for %v: %v", ticker, string(body))
+	}
+
+	totalVolume, err := convertToFloat(d.TotalVolume)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse total volume of %v: %w", ticker, err)
 	}
 
-	return ti, err
+	return &TickerInformation{
+		Price:       *d.Price,
+		Change:      d.ChangePercentage,
+		TotalVolume: totalVolume,
+		AsOf:        time.Now(),
+	}, nil
 }
 
-func convertToFloat(s string) float64 {
+// convertToFloat parses a number abbreviated with a K, M or B suffix, e.g.
+// "1.5M". Thousands may be separated with commas.
+func convertToFloat(s string) (float64, error) {
+	s = strings.ReplaceAll(strings.TrimSpace(s), ",", "")
 	if len(s) == 0 {
-		return 0
+		return 0, nil
+	}
+
+	multiplier := 1.0
+	switch s[len(s)-1:] {
+	case "K":
+		multiplier = 1000
+	case "M":
+		multiplier = 1000000
+	case "B":
+		multiplier = 1000000000
+	}
+	if multiplier != 1 {
+		s = s[:len(s)-1]
 	}
 
-	suffix := s[len(s)-1:]
-	switch suffix {
-	case "K", "M", "B":
-		modifiedString := s[:len(s)-1]
-
-		value, err := strconv.ParseFloat(modifiedString, 64)
-		if err != nil {
-			panic(err)
-		}
-
-		switch suffix {
-		case "K":
-			value *= 1000
-		case "M":
-			value *= 1000000
-		case "B":
-			value *= 1000000000
-		}
-
-		return value
-	default:
-		value, err := strconv.ParseFloat(s, 64)
-		if err != nil {
-			panic(err)
-		}
-		return value
+	value, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, err
 	}
+
+	return value * multiplier, nil
 }
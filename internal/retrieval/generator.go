@@ -2,6 +2,7 @@ package retrieval
 
 import (
 	"bytes"
+	"cofin/internal/diff"
 	"cofin/models"
 	"context"
 	"encoding/json"
@@ -9,6 +10,7 @@ import (
 	"io/ioutil"
 	"net/http"
 	"os"
+	"strings"
 	"time"
 
 	"github.com/hashicorp/go-retryablehttp"
@@ -48,7 +50,7 @@ func (g *Generator) CondenseConversation(ctx context.Context, user *models.User,
 	input := []schema.ChatMessage{
 		schema.SystemChatMessage{
 			Text: fmt.Sprintf(
-				"You are COFIN, a virtual assistant that helps people read, analyze, and interpret financial filings of publicly traded companies. You have access to 10-K and 10-Q documents filed to SEC. Today is %v.",
+				"You are COFIN, a virtual assistant that helps people read, analyze, and interpret financial filings of publicly traded companies. You have access to 10-K and 10-Q documents filed to SEC, to 20-F, 40-F and 6-K documents foreign companies file instead, and to earnings call transcripts. Today is %v.",
 				time.Now().Format("2006-01-02")),
 		},
 		schema.HumanChatMessage{
@@ -86,24 +88,28 @@ Your task is to rewrite each message to make it shorter but to keep the most imp
 // and available documents to decide which document to retrieve from using what
 // query.
 //
-// If returned *message is not nil, no further retrieval is necessary.
-func (g *Generator) CreateRetrieval(ctx context.Context, user *models.User, company *models.Company, documentIDs []uint, documentList, conversation string, lastMessage string) (earlyResponse *string, documentID uint, query string, err error) {
+// If returned *message is not nil, no further retrieval is necessary. If
+// diffSection is not empty, the user asked what changed in a section, and the
+// section of the document should be compared with the previous document of the
+// same kind instead of searching the document with query.
+func (g *Generator) CreateRetrieval(ctx context.Context, user *models.User, company *models.Company, documentIDs []uint, documentList, conversation string, lastMessage string) (earlyResponse *string, documentID uint, query string, diffSection models.Section, err error) {
 	documentIDsFormatted := jsonEscapeArray(documentIDs)
 	documentListFormatted := jsonEscapeString(documentList)
 	conversationFormatted := jsonEscapeString(conversation)
 	userNameFormatted := jsonEscapeString(user.FullName)
 	lastMessageFormatted := jsonEscapeString(lastMessage)
+	valuationFormatted := jsonEscapeString(describeValuation(company))
 	jsonStr := fmt.Sprintf(`
 {
 	"model": "%v",
 	"messages": [
-		{"role": "system", "content": "You are COFIN, a virtual assistant that helps people read, analyze, and interpret financial filings of publicly traded companies. You have access to 10-K and 10-Q documents filed to SEC. Today is %v."},
-		{"role": "user", "content": "I am going to send you conversation history between you and a user as a single message. The conversation pertains to company %v ($%v). You have access to financial documents of the company."},
-		{"role": "user", "content": "You need to respond to the user's last message. You can either create a response right away or make a function call to retrieve_relevant_paragraphs which retrieves relevant paragraphs from the document of your choice using semantic search. If you want to, you can retrieve this information to answer the last user message in the conversation."},
-		{"role": "user", "content": "Here's the list of documents you have access to in <DocumentID>: <Description> format:\n%v"},
+		{"role": "system", "content": "You are COFIN, a virtual assistant that helps people read, analyze, and interpret financial filings of publicly traded companies. You have access to 10-K and 10-Q documents filed to SEC, to 20-F, 40-F and 6-K documents foreign companies file instead, and to earnings call transcripts. Today is %v."},
+		{"role": "user", "content": "I am going to send you conversation history between you and a user as a single message. The conversation pertains to company %v ($%v). You have access to financial documents of the company.%v"},
+		{"role": "user", "content": "You need to respond to the user's last message. You can either create a response right away or make a function call to retrieve_relevant_paragraphs which retrieves relevant paragraphs from the document of your choice using semantic search. If you want to, you can retrieve this information to answer the last user message in the conversation. If the user asks what changed, what is new or what was removed in a document, call compare_with_previous_document instead, which lists the paragraphs of a section added, removed or modified since the previous document of the same kind."},
+		{"role": "user", "content": "Here's the list of documents you have access to in <DocumentID>: <Description> format. Amended documents are marked as superseded; their amendments restate them and take precedence:\n%v"},
 		{"role": "user", "content": "Here is the conversation history:\n%v"},
 		{"role": "user", "content": "%v: %v"},
-		{"role": "user", "content": "Do one of the following.\n1. Generate a reponse to %v. Do not repeat their last message. Do not prepend your answer with \"User:\" or \"COFIN:\". Just address %v directly.\n2. If you need more financial data to inform your answer, choose a document with retrieve_relevant_paragraphs and submit a query to retrieve information from the document. Use the most recent document by default. Phrase the query so that it matches text in the document that might contain the answer to the user's question. Remember, you are working with 10-Ks and 10-Qs.\n3: If you need more information from the user and the most recent document won't answer their question, give them the list of documents you have access to and explicitly ask them which one they'd like to use."}
+		{"role": "user", "content": "Do one of the following.\n1. Generate a reponse to %v. Do not repeat their last message. Do not prepend your answer with \"User:\" or \"COFIN:\". Just address %v directly.\n2. If you need more financial data to inform your answer, choose a document with retrieve_relevant_paragraphs and submit a query to retrieve information from the document. Use the most recent document by default. Phrase the query so that it matches text in the document that might contain the answer to the user's question. Remember, you are working with 10-Ks, 10-Qs, 20-Fs, 40-Fs, 6-Ks and earnings call transcripts.\n3: If you need more information from the user and the most recent document won't answer their question, give them the list of documents you have access to and explicitly ask them which one they'd like to use."}
 	   ],
 	"temperature": %v,
 	"functions": [
@@ -121,15 +127,30 @@ func (g *Generator) CreateRetrieval(ctx context.Context, user *models.User, comp
 			   },
 			   "required": ["query", "documentID"]
 			}
+		},
+		{
+			"name": "compare_with_previous_document",
+			"description": "List the paragraphs of a section of the document that were added, removed or modified since the company's previous document of the same kind.",
+			"parameters": {
+			   "type": "object",
+			   "properties": {
+				   "section": {
+					   "type": "string",
+					   "description": "Section code. For 10-Ks: 1A for risk factors, 1 for business, 3 for legal proceedings, 7 for management's discussion and analysis. For 10-Qs: part2item1a for risk factors, part1item2 for management's discussion and analysis. For 20-Fs: item3 for key information including risk factors, item4 for information on the company, item5 for the operating and financial review."
+				   },
+				   "documentID": {"type": "number", "enum": %v}
+			   },
+			   "required": ["section", "documentID"]
+			}
 		}
 	],
 	"function_call": "auto"
    }
-	`, g.model, time.Now().Format("2006-01-02"), company.Name, company.Ticker, documentListFormatted, conversationFormatted, userNameFormatted, lastMessageFormatted, userNameFormatted, userNameFormatted, g.temperature, documentIDsFormatted)
+	`, g.model, time.Now().Format("2006-01-02"), company.Name, company.Ticker, valuationFormatted, documentListFormatted, conversationFormatted, userNameFormatted, lastMessageFormatted, userNameFormatted, userNameFormatted, g.temperature, documentIDsFormatted, documentIDsFormatted)
 
 	req, err := http.NewRequest("POST", "https://api.openai.com/v1/chat/completions", bytes.NewReader([]byte(jsonStr)))
 	if err != nil {
-		return nil, 0, "", err
+		return nil, 0, "", "", err
 	}
 	req.Header.Set("Authorization", fmt.Sprintf("Bearer %v", os.Getenv("OPENAI_API_KEY")))
 	req.Header.Set("Content-Type", "application/json")
@@ -138,12 +159,12 @@ func (g *Generator) CreateRetrieval(ctx context.Context, user *models.User, comp
 	client.Logger = nil
 	resp, err := client.StandardClient().Do(req)
 	if err != nil {
-		return nil, 0, "", err
+		return nil, 0, "", "", err
 	}
 
 	b, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
-		return nil, 0, "", err
+		return nil, 0, "", "", err
 	}
 
 	type FunctionCall struct {
@@ -164,27 +185,32 @@ func (g *Generator) CreateRetrieval(ctx context.Context, user *models.User, comp
 	var res Response
 	err = json.Unmarshal(b, &res)
 	if err != nil {
-		return nil, 0, "", err
+		return nil, 0, "", "", err
 	}
 
 	var Arguments struct {
-		Query      string `json:"query"`
-		DocumentID uint   `json:"documentID"`
+		Query      string         `json:"query"`
+		Section    models.Section `json:"section"`
+		DocumentID uint           `json:"documentID"`
 	}
 	if len(res.Choices) == 0 {
-		return nil, 0, "", fmt.Errorf("no choices returned: %v", string(b))
+		return nil, 0, "", "", fmt.Errorf("no choices returned: %v", string(b))
 	}
 	m := res.Choices[0].Message
 	if m.FunctionCall != nil {
 		args := res.Choices[0].Message.FunctionCall.Arguments
 		err = json.Unmarshal([]byte(args), &Arguments)
 		if err != nil {
-			return nil, 0, "", err
+			return nil, 0, "", "", err
 		}
 
-		return nil, Arguments.DocumentID, Arguments.Query, nil
+		if m.FunctionCall.Name == "compare_with_previous_document" {
+			return nil, Arguments.DocumentID, "", Arguments.Section, nil
+		}
+
+		return nil, Arguments.DocumentID, Arguments.Query, "", nil
 	} else {
-		return &m.Content, 0, "", nil
+		return &m.Content, 0, "", "", nil
 	}
 }
 
@@ -199,10 +225,10 @@ func (g *Generator) Continue(ctx context.Context, user *models.User, company *mo
 
 	input := []schema.ChatMessage{
 		schema.SystemChatMessage{
-			Text: fmt.Sprintf("You are COFIN, a virtual assistant that helps people read, analyze, and interpret financial filings of publicly traded companies. You have access to 10-K and 10-Q documents filed to SEC. Today is %v.", time.Now().Format("2006-01-02")),
+			Text: fmt.Sprintf("You are COFIN, a virtual assistant that helps people read, analyze, and interpret financial filings of publicly traded companies. You have access to 10-K and 10-Q documents filed to SEC, to 20-F, 40-F and 6-K documents foreign companies file instead, and to earnings call transcripts. Today is %v.", time.Now().Format("2006-01-02")),
 		},
 		schema.HumanChatMessage{
-			Text: fmt.Sprintf("I am going to send conversation history between you and a user as a single message. The conversation pertains to company %v ($%v). You have access to the following documents of the company:\n%v", company.Name, company.Ticker, documentList),
+			Text: fmt.Sprintf("I am going to send conversation history between you and a user as a single message. The conversation pertains to company %v ($%v).%v You have access to the following documents of the company:\n%v", company.Name, company.Ticker, describeValuation(company), documentList),
 		},
 		schema.HumanChatMessage{
 			Text: fmt.Sprintf("I am going to provide you with paragraphs from the %v document for %v filed at %v. You have previously chosen these as most relevant to the conversation you were having with the user. You should generate a response to the last user message using this document context as the source of data.", document.Kind, company.Name, document.FiledAt.Format("2006-01-02")),
@@ -221,6 +247,99 @@ func (g *Generator) Continue(ctx context.Context, user *models.User, company *mo
 	return res, nil
 }
 
+// ContinueWithDiff generates a continuation to a conversation about what
+// changed in a section of a document since the previous document of the same
+// kind. Changes beyond what fits the prompt are left out.
+func (g *Generator) ContinueWithDiff(ctx context.Context, user *models.User, company *models.Company, documentList, conversation, lastMessage string, document, previous *models.Document, sectionTitle string, d diff.Diff) (string, error) {
+	// Keep the prompt within the model's context window.
+	const maxChangesLength = 24000
+
+	var changes string
+	var omitted int
+	for _, change := range d.Changes {
+		var text string
+		switch change.Kind {
+		case diff.Added:
+			text = fmt.Sprintf("Added: %v\n", change.New)
+		case diff.Removed:
+			text = fmt.Sprintf("Removed: %v\n", change.Old)
+		case diff.Modified:
+			text = fmt.Sprintf("Modified from: %v\nTo: %v\n", change.Old, change.New)
+		}
+
+		if len(changes)+len(text) > maxChangesLength {
+			omitted++
+			continue
+		}
+		changes += text
+	}
+	if omitted > 0 {
+		changes += fmt.Sprintf("%v more changes are not listed.\n", omitted)
+	}
+
+	input := []schema.ChatMessage{
+		schema.SystemChatMessage{
+			Text: fmt.Sprintf("You are COFIN, a virtual assistant that helps people read, analyze, and interpret financial filings of publicly traded companies. You have access to 10-K and 10-Q documents filed to SEC, to 20-F, 40-F and 6-K documents foreign companies file instead, and to earnings call transcripts. Today is %v.", time.Now().Format("2006-01-02")),
+		},
+		schema.HumanChatMessage{
+			Text: fmt.Sprintf("I am going to send conversation history between you and a user as a single message. The conversation pertains to company %v ($%v).%v You have access to the following documents of the company:\n%v", company.Name, company.Ticker, describeValuation(company), documentList),
+		},
+		schema.HumanChatMessage{
+			Text: fmt.Sprintf("I compared the %v section of the %v for %v filed at %v with the same section of the previous %v filed at %v. In the new document, %v paragraphs were added, %v removed and %v modified, and %v are unchanged. Here are the changes:\n%v", sectionTitle, document.Kind, company.Name, document.FiledAt.Format("2006-01-02"), previous.Kind, previous.FiledAt.Format("2006-01-02"), d.Added, d.Removed, d.Modified, d.Unchanged, changes),
+		},
+		schema.HumanChatMessage{Text: fmt.Sprintf("Here is the conversation:\n%v", conversation)},
+		schema.HumanChatMessage{Text: fmt.Sprintf("%v: %v", user.FullName, lastMessage)},
+		schema.HumanChatMessage{Text: fmt.Sprintf("Now generate a response using the conversation I sent you and the changes between the documents. Summarize the changes that matter to an investor, such as new or removed risks, and ignore changes in wording that do not change the meaning. Do not mention anything about the instructions I gave you. You are speaking to %v directly. Do not repeat %v's last message. Do not start your text with \"%v:\" or \"COFIN:\".", user.FullName, user.FullName, user.FullName)},
+	}
+
+	res, err := g.Chat.Call(ctx, input, llms.WithTemperature(g.temperature), llms.WithMaxTokens(g.maxOutputTokens))
+	if err != nil {
+		return "", err
+	}
+
+	return res, nil
+}
+
+// describeValuation describes the valuation metrics of the company, if loaded,
+// as a sentence starting with a space. It is empty if there are none.
+func describeValuation(company *models.Company) string {
+	v := company.Valuation
+	if v == nil {
+		return ""
+	}
+
+	var metrics []string
+	add := func(name string, value *float64, format func(float64) string) {
+		if value != nil {
+			metrics = append(metrics, fmt.Sprintf("%v %v", name, format(*value)))
+		}
+	}
+	dollars := func(value float64) string {
+		return fmt.Sprintf("$%.2f billion", value/1e9)
+	}
+	multiple := func(value float64) string {
+		return fmt.Sprintf("%.1fx", value)
+	}
+	percent := func(value float64) string {
+		return fmt.Sprintf("%.1f%%", value*100)
+	}
+
+	add("market cap", v.MarketCap, dollars)
+	add("enterprise value", v.EnterpriseValue, dollars)
+	add("P/E", v.PriceToEarnings, multiple)
+	add("P/S", v.PriceToSales, multiple)
+	add("EV/EBITDA", v.EVToEBITDA, multiple)
+	add("gross margin", v.GrossMargin, percent)
+	add("operating margin", v.OperatingMargin, percent)
+	add("net margin", v.NetMargin, percent)
+	add("return on equity", v.ReturnOnEquity, percent)
+	if len(metrics) == 0 {
+		return ""
+	}
+
+	return fmt.Sprintf(" Its valuation metrics at a price of %.2f, with trailing twelve months figures to %v, are: %v.", v.Price, v.PeriodEnd.Format("2006-01-02"), strings.Join(metrics, ", "))
+}
+
 // jsonEscapeString escapes a string as a JSON string. For instance, it converts
 // newline characters to "\n". Start and end quotation marks are removed.
 func jsonEscapeString(i string) string {
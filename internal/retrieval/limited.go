@@ -0,0 +1,67 @@
+package retrieval
+
+import (
+	"cofin/internal/ratelimit"
+	"context"
+
+	"github.com/tmc/langchaingo/embeddings"
+	"github.com/tmc/langchaingo/schema"
+	"github.com/tmc/langchaingo/vectorstores"
+)
+
+// limitedEmbedder waits on a rate limiter before every call to the embedding
+// API.
+type limitedEmbedder struct {
+	embedder embeddings.Embedder
+	limiter  *ratelimit.Limiter
+}
+
+// NewLimitedEmbedder wraps an embedder so that it calls the embedding API no
+// faster than the limiter allows.
+func NewLimitedEmbedder(embedder embeddings.Embedder, limiter *ratelimit.Limiter) embeddings.Embedder {
+	return limitedEmbedder{embedder: embedder, limiter: limiter}
+}
+
+func (e limitedEmbedder) EmbedDocuments(ctx context.Context, texts []string) ([][]float64, error) {
+	if err := e.limiter.Wait(ctx); err != nil {
+		return nil, err
+	}
+
+	return e.embedder.EmbedDocuments(ctx, texts)
+}
+
+func (e limitedEmbedder) EmbedQuery(ctx context.Context, text string) ([]float64, error) {
+	if err := e.limiter.Wait(ctx); err != nil {
+		return nil, err
+	}
+
+	return e.embedder.EmbedQuery(ctx, text)
+}
+
+// limitedStore waits on a rate limiter before every call to the vector store.
+type limitedStore struct {
+	store   vectorstores.VectorStore
+	limiter *ratelimit.Limiter
+}
+
+// NewLimitedStore wraps a vector store so that it is called no faster than the
+// limiter allows.
+func NewLimitedStore(store vectorstores.VectorStore, limiter *ratelimit.Limiter) vectorstores.VectorStore {
+	return limitedStore{store: store, limiter: limiter}
+}
+
+func (s limitedStore) AddDocuments(ctx context.Context, docs []schema.Document, options ...vectorstores.Option) error {
+	if err := s.limiter.Wait(ctx); err != nil {
+		return err
+	}
+
+	return s.store.AddDocuments(ctx, docs, options...)
+}
+
+func (s limitedStore) SimilaritySearch(ctx context.Context, query string, numDocuments int, options ...vectorstores.Option) ([]schema.Document, error) {
+	if err := s.limiter.Wait(ctx); err != nil {
+		return nil, err
+	}
+
+	return s.store.SimilaritySearch(ctx, query, numDocuments, options...)
+}
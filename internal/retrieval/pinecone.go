@@ -1,10 +1,16 @@
 package retrieval
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
 	"fmt"
+	"io"
+	"net/http"
 	"os"
+	"time"
 
+	"github.com/hashicorp/go-retryablehttp"
 	"github.com/tmc/langchaingo/embeddings"
 	"github.com/tmc/langchaingo/schema"
 	"github.com/tmc/langchaingo/vectorstores"
@@ -31,21 +37,169 @@ func NewPinecone(ctx context.Context, embedder embeddings.Embedder, companyID ui
 	return &store, nil
 }
 
-// StoreChunks stores document chunks in Pinecone.
+// DeleteChunks deletes the chunks in the company's namespace whose metadata
+// matches the filter, e.g. {"accession_no": "0000320193-23-000106"}. The vector
+// store is not part of database transactions, so chunks stored for a document
+// whose transaction was rolled back are left behind until deleted.
+func DeleteChunks(ctx context.Context, companyID uint, filter map[string]interface{}) error {
+	endpoint := pineconeEndpoint("/vectors/delete")
+
+	payload, err := json.Marshal(map[string]interface{}{
+		"filter":    filter,
+		"namespace": fmt.Sprint(companyID),
+	})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Api-Key", os.Getenv("PINECONE_API_KEY"))
+
+	resp, err := pineconeHTTP.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("failed to delete chunks: %v: %s", resp.Status, body)
+	}
+
+	return nil
+}
+
+// DeleteDocumentChunks deletes the chunks of the documents from the company's
+// namespace.
+func DeleteDocumentChunks(companyID uint, documentIDs []uint) error {
+	return DeleteChunks(context.Background(), companyID, map[string]interface{}{
+		"document_id": map[string]interface{}{"$in": documentIDs},
+	})
+}
+
+// ScoredChunk is a chunk found by a similarity search.
+type ScoredChunk struct {
+	DocumentID uint
+	Content    string
+	// Similarity of the chunk to the query. Higher is more similar.
+	Score float64
+}
+
+// QueryChunks returns the topK chunks in the company's namespace most similar
+// to the vector among those whose metadata matches the filter, most similar
+// first. Unlike the vector store's similarity search, it returns the scores,
+// so that chunks of several queries can be ranked together.
+func QueryChunks(ctx context.Context, companyID uint, vector []float64, topK int, filter map[string]interface{}) ([]ScoredChunk, error) {
+	payload, err := json.Marshal(map[string]interface{}{
+		"vector":          vector,
+		"topK":            topK,
+		"filter":          filter,
+		"namespace":       fmt.Sprint(companyID),
+		"includeMetadata": true,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, pineconeEndpoint("/query"), bytes.NewReader(payload))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Api-Key", os.Getenv("PINECONE_API_KEY"))
+
+	resp, err := pineconeHTTP.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to query chunks: %v: %s", resp.Status, body)
+	}
+
+	var response struct {
+		Matches []struct {
+			Score    float64                `json:"score"`
+			Metadata map[string]interface{} `json:"metadata"`
+		} `json:"matches"`
+	}
+	if err := json.Unmarshal(body, &response); err != nil {
+		return nil, err
+	}
+
+	chunks := make([]ScoredChunk, 0, len(response.Matches))
+	for _, match := range response.Matches {
+		// Langchain stores the text of a chunk under "text".
+		content, _ := match.Metadata["text"].(string)
+		// JSON numbers are decoded as floats.
+		documentID, _ := match.Metadata["document_id"].(float64)
+		chunks = append(chunks, ScoredChunk{
+			DocumentID: uint(documentID),
+			Content:    content,
+			Score:      match.Score,
+		})
+	}
+
+	return chunks, nil
+}
+
+// pineconeHTTP is shared by requests to Pinecone's REST API. Failed requests
+// are retried and every attempt times out, so that a stalled request does not
+// block an ingestion or a generator request indefinitely.
+var pineconeHTTP = func() *http.Client {
+	client := retryablehttp.NewClient()
+	client.Logger = nil
+	client.RetryMax = 3
+	client.HTTPClient.Timeout = 30 * time.Second
+	// Return the last response once retries run out, so that its status code
+	// can be reported.
+	client.ErrorHandler = retryablehttp.PassthroughErrorHandler
+
+	return client.StandardClient()
+}()
+
+func pineconeEndpoint(path string) string {
+	return fmt.Sprintf(
+		"https://%v-%v.svc.%v.pinecone.io%v",
+		os.Getenv("PINECONE_INDEX"),
+		os.Getenv("PINECONE_PROJECT"),
+		os.Getenv("PINECONE_ENVIRONMENT"),
+		path,
+	)
+}
+
+// MaxConcurrentBatches is the number of chunk batches StoreChunks upserts at
+// the same time. It may be changed before any chunks are stored.
+var MaxConcurrentBatches = 4
+
+// StoreChunks stores document chunks in Pinecone in batches.
 func StoreChunks(store vectorstores.VectorStore, documentID uint, chunks []schema.Document) error {
 	const BATCH_SIZE = 50
 
-	// Set document metadata. We only set the ID that matches the internal ID.
+	// Set document metadata. We always set the ID that matches the internal
+	// ID and keep any metadata the caller attached to the chunk, such as the
+	// speaker of a transcript turn.
 	//
 	// Langchain sets document text for us.
 	for i := range chunks {
 		// Modify chunks in-place. They are not pointers.
-		chunks[i].Metadata = map[string]interface{}{
-			"document_id": documentID,
+		if chunks[i].Metadata == nil {
+			chunks[i].Metadata = map[string]interface{}{}
 		}
+		chunks[i].Metadata["document_id"] = documentID
 	}
 
 	errs, ctx := errgroup.WithContext(context.Background())
+	errs.SetLimit(MaxConcurrentBatches)
 	for i := 0; i < len(chunks); i += BATCH_SIZE {
 		end := i + BATCH_SIZE
 		if end > len(chunks) {
@@ -1,10 +1,12 @@
 package retrieval
 
 import (
+	"cofin/models"
 	"context"
+	"sort"
+	"strings"
 
 	"github.com/tmc/langchaingo/embeddings"
-	"github.com/tmc/langchaingo/vectorstores"
 	"gorm.io/gorm"
 )
 
@@ -12,8 +14,10 @@ import (
 type Retriever struct {
 	db       *gorm.DB
 	embedder embeddings.Embedder
-	store    vectorstores.VectorStore
-	topK     int
+	// Vector store namespaces searched: the company's, and those of the
+	// companies merged into it, which keep their chunks.
+	companyIDs []uint
+	topK       int
 }
 
 // NewRetriever creates a new Retriever namespaces to the given company.
@@ -23,33 +27,157 @@ func NewRetriever(db *gorm.DB, companyID uint) (*Retriever, error) {
 		return nil, err
 	}
 
-	store, err := NewPinecone(context.Background(), embedder, companyID)
+	mergedIDs, err := models.GetMergedCompanyIDs(db, companyID)
 	if err != nil {
 		return nil, err
 	}
 
 	return &Retriever{
-		db:       db,
-		embedder: embedder,
-		store:    store,
-		topK:     3,
+		db:         db,
+		embedder:   embedder,
+		companyIDs: append([]uint{companyID}, mergedIDs...),
+		topK:       3,
 	}, nil
 }
 
 func (r *Retriever) GetSemanticChunks(ctx context.Context, companyID, documentID uint, text string) ([]string, error) {
-	docs, err := r.store.SimilaritySearch(context.Background(), text, r.topK, vectorstores.WithFilters(map[string]any{
+	vector, err := r.embedder.EmbedQuery(ctx, text)
+	if err != nil {
+		return nil, err
+	}
+
+	scoredChunks, err := r.queryChunks(ctx, vector, r.topK, map[string]interface{}{
 		// This is type-sensitive. Setting this to a string, for example, will
 		// return no results.
 		"document_id": documentID,
-	}))
+	})
 	if err != nil {
 		return nil, err
 	}
 
-	docStrings := make([]string, len(docs))
-	for i, doc := range docs {
-		docStrings[i] = doc.PageContent
+	docStrings := make([]string, len(scoredChunks))
+	for i, chunk := range scoredChunks {
+		docStrings[i] = chunk.Content
 	}
 
 	return docStrings, nil
 }
+
+// queryChunks returns the topK chunks most similar to the vector among those
+// whose metadata matches the filter, most similar first, from all namespaces
+// of the company.
+func (r *Retriever) queryChunks(ctx context.Context, vector []float64, topK int, filter map[string]interface{}) ([]ScoredChunk, error) {
+	var chunks []ScoredChunk
+	for _, companyID := range r.companyIDs {
+		found, err := QueryChunks(ctx, companyID, vector, topK, filter)
+		if err != nil {
+			return nil, err
+		}
+		chunks = append(chunks, found...)
+	}
+
+	sort.SliceStable(chunks, func(i, j int) bool {
+		return chunks[i].Score > chunks[j].Score
+	})
+	if len(chunks) > topK {
+		chunks = chunks[:topK]
+	}
+
+	return chunks, nil
+}
+
+// GetSemanticChunksPreferring retrieves the topK chunks most similar to text
+// from several versions of a document, such as an amendment and the document it
+// amends, in order of preference. Chunks of all documents are ranked together
+// by similarity. A chunk of a less preferred document is left out when a more
+// preferred document restates the section it is in, so that a partial
+// amendment only replaces the sections it restates.
+func (r *Retriever) GetSemanticChunksPreferring(ctx context.Context, companyID uint, documentIDs []uint, text string) ([]string, error) {
+	if len(documentIDs) == 1 {
+		return r.GetSemanticChunks(ctx, companyID, documentIDs[0], text)
+	}
+
+	vector, err := r.embedder.EmbedQuery(ctx, text)
+	if err != nil {
+		return nil, err
+	}
+
+	// Ask for enough chunks that topK remain after restated ones are left
+	// out.
+	scoredChunks, err := r.queryChunks(ctx, vector, r.topK*len(documentIDs), map[string]interface{}{
+		"document_id": map[string]interface{}{"$in": documentIDs},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	restated, err := r.restatedSections(documentIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	var chunks []string
+	for _, chunk := range scoredChunks {
+		if len(chunks) == r.topK {
+			break
+		}
+		if isRestated(chunk, restated[chunk.DocumentID]) {
+			continue
+		}
+
+		chunks = append(chunks, chunk.Content)
+	}
+
+	return chunks, nil
+}
+
+// restatedSections returns, for each document, the contents of its sections
+// that a more preferred document also has.
+func (r *Retriever) restatedSections(documentIDs []uint) (map[uint][]string, error) {
+	restated := map[uint][]string{}
+	preferred := map[models.Section]bool{}
+	for _, documentID := range documentIDs {
+		sections, err := models.GetDocumentSectionsWithContent(r.db, documentID)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, section := range sections {
+			if preferred[section.Code] {
+				restated[documentID] = append(restated[documentID], section.Content)
+			}
+		}
+		for _, section := range sections {
+			if strings.TrimSpace(section.Content) != "" {
+				preferred[section.Code] = true
+			}
+		}
+	}
+
+	return restated, nil
+}
+
+// restatedPrefixLength is how much of the start of a chunk is looked for in
+// restated sections. Chunks may run past the end of a section, so the chunk as
+// a whole is not.
+const restatedPrefixLength = 200
+
+// isRestated reports whether a chunk starts in one of the restated sections of
+// its document.
+func isRestated(chunk ScoredChunk, restatedSections []string) bool {
+	prefix := strings.TrimSpace(chunk.Content)
+	if len(prefix) > restatedPrefixLength {
+		prefix = prefix[:restatedPrefixLength]
+	}
+	if prefix == "" {
+		return false
+	}
+
+	for _, content := range restatedSections {
+		if strings.Contains(content, prefix) {
+			return true
+		}
+	}
+
+	return false
+}
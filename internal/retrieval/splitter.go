@@ -2,6 +2,12 @@ package retrieval
 
 import "fmt"
 
+// Chunk length and overlap used for all indexed documents.
+const (
+	DefaultChunkLength  = 3000
+	DefaultChunkOverlap = 100
+)
+
 // Splitter splits text into chunks.
 type Splitter struct {
 	chunkLength  int
@@ -0,0 +1,368 @@
+package sec_api
+
+import (
+	"bytes"
+	"cofin/models"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/go-retryablehttp"
+)
+
+// Base URLs of the sec-api.io endpoints.
+const (
+	DefaultQueryURL     = "https://api.sec-api.io"
+	DefaultMappingURL   = "https://api.sec-api.io/mapping"
+	DefaultExtractorURL = "https://api.sec-api.io/extractor"
+	DefaultArchiveURL   = "https://archive.sec-api.io"
+)
+
+// Names of the endpoints, as used in errors and metrics.
+const (
+	QueryEndpoint     = "query"
+	MappingEndpoint   = "mapping"
+	ExtractorEndpoint = "extractor"
+	ArchiveEndpoint   = "archive"
+)
+
+var (
+	// The API key is missing or invalid, or the plan does not cover the
+	// endpoint.
+	ErrUnauthorized = errors.New("sec-api.io rejected the API key")
+	// Too many requests. Requests are retried before this is returned.
+	ErrRateLimited = errors.New("sec-api.io rate limit exceeded")
+	ErrNotFound    = errors.New("not found on sec-api.io")
+	// The extractor has not finished processing the filing yet. The same
+	// request succeeds later.
+	ErrProcessing = errors.New("sec-api.io is still processing the filing")
+)
+
+// APIError is an unsuccessful response from sec-api.io. It matches
+// ErrUnauthorized, ErrRateLimited or ErrNotFound with errors.Is, depending on
+// the status code.
+type APIError struct {
+	Endpoint   string
+	StatusCode int
+	// Beginning of the response body, for debugging.
+	Body string
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("sec-api.io %v endpoint returned %v %v: %v", e.Endpoint, e.StatusCode, http.StatusText(e.StatusCode), e.Body)
+}
+
+func (e *APIError) Unwrap() error {
+	switch e.StatusCode {
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return ErrUnauthorized
+	case http.StatusTooManyRequests:
+		return ErrRateLimited
+	case http.StatusNotFound:
+		return ErrNotFound
+	default:
+		return nil
+	}
+}
+
+// Client is the sec-api.io implementation of Provider. It is safe for
+// concurrent use.
+type Client struct {
+	Key string
+	// Base URLs of the endpoints. NewClient sets them to sec-api.io's.
+	QueryURL     string
+	MappingURL   string
+	ExtractorURL string
+	ArchiveURL   string
+	// HTTP is shared by all requests. NewClient sets it to a client that
+	// retries failed requests, honoring Retry-After on 429 responses.
+	HTTP    *http.Client
+	Metrics *Metrics
+}
+
+var _ Provider = &Client{}
+
+// NewClient creates a sec-api.io client with the given API key.
+func NewClient(key string) *Client {
+	client := retryablehttp.NewClient()
+	client.Logger = nil
+	// Return the last response once retries run out, so that its status code
+	// can be reported.
+	client.ErrorHandler = retryablehttp.PassthroughErrorHandler
+
+	return &Client{
+		Key:          key,
+		QueryURL:     DefaultQueryURL,
+		MappingURL:   DefaultMappingURL,
+		ExtractorURL: DefaultExtractorURL,
+		ArchiveURL:   DefaultArchiveURL,
+		HTTP:         client.StandardClient(),
+		Metrics:      NewMetrics(),
+	}
+}
+
+// do sends a request to an endpoint and returns the response body. The API
+// key is sent in the Authorization header. Unsuccessful responses are returned
+// as an *APIError, and the extractor's processing placeholder as ErrProcessing.
+func (c *Client) do(endpoint string, req *http.Request) (body []byte, err error) {
+	start := time.Now()
+	defer func() {
+		c.Metrics.record(endpoint, time.Since(start), err)
+	}()
+
+	req.Header.Set("Authorization", c.Key)
+	resp, err := c.HTTP.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err = io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		const maxBodyLength = 200
+		if len(body) > maxBodyLength {
+			body = body[:maxBodyLength]
+		}
+
+		return nil, &APIError{Endpoint: endpoint, StatusCode: resp.StatusCode, Body: string(body)}
+	}
+
+	// The extractor answers with a placeholder instead of the section while
+	// it processes a filing it has not seen before.
+	if endpoint == ExtractorEndpoint && strings.TrimSpace(string(body)) == "processing" {
+		return nil, ErrProcessing
+	}
+
+	return body, nil
+}
+
+// Get companies traded on an exchange.
+func (c *Client) GetTradedCompanies(exchange Exchange) (listings []Listing, err error) {
+	req, err := http.NewRequest("GET", fmt.Sprintf("%v/exchange/%v", c.MappingURL, exchange), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	b, err := c.do(MappingEndpoint, req)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(b, &listings); err != nil {
+		return nil, fmt.Errorf("failed to parse listings: %w", err)
+	}
+
+	return listings, nil
+}
+
+// Get up to limit filings of a kind filed since a point in time, oldest first.
+func (c *Client) GetFilingsSince(cik string, kind models.SourceKind, since time.Time, limit int) ([]Filing, error) {
+	timeStart := since.Format(time.RFC3339)
+	timeEnd := time.Now().Format(time.RFC3339)
+
+	var jsonStr = []byte(
+		fmt.Sprintf(`{
+			"query": {
+				"query_string": {
+					"query": "formType:\"%v\" AND filedAt:[%v TO %v] AND cik:(%v)",
+					"time_zone": "America/New_York"
+				}
+			},
+			"from": "0",
+			"size": "%v",
+			"sort": [{ "filedAt": { "order": "asc" } }]
+		}`, kind, timeStart, timeEnd, cik, limit),
+	)
+
+	req, err := http.NewRequest("POST", c.QueryURL, bytes.NewBuffer(jsonStr))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	b, err := c.do(QueryEndpoint, req)
+	if err != nil {
+		return nil, err
+	}
+
+	type Total struct {
+		Value    int    `json:"value"`
+		Relation string `json:"relation"`
+	}
+
+	type Query struct {
+		From int `json:"from"`
+		Size int `json:"size"`
+	}
+
+	type response struct {
+		Total   Total    `json:"total"`
+		Query   Query    `json:"query"`
+		Filings []Filing `json:"filings"`
+	}
+
+	var r response
+	if err := json.Unmarshal(b, &r); err != nil {
+		return nil, fmt.Errorf("failed to parse filings: %w", err)
+	}
+
+	return r.Filings, nil
+}
+
+// Get the plain text of a section of the filing at originURL. Returns an empty
+// string if the filing has no such section.
+func (c *Client) ExtractSectionContent(originURL string, section models.Section) (string, error) {
+	query := url.Values{}
+	query.Set("url", originURL)
+	query.Set("item", string(section))
+	query.Set("type", "text")
+
+	req, err := http.NewRequest("GET", c.ExtractorURL+"?"+query.Encode(), nil)
+	if err != nil {
+		return "", err
+	}
+
+	b, err := c.do(ExtractorEndpoint, req)
+	if err != nil {
+		return "", err
+	}
+
+	return string(b), nil
+}
+
+// Get a filing's primary document from the sec-api.io archive.
+func (c *Client) GetFilingFile(filing Filing) ([]byte, error) {
+	// Get the file name.
+	_, fileName := path.Split(filing.LinkToFilingDetails)
+	// In the URL, the accession number should have no dashes.
+	accessionNumber := strings.ReplaceAll(filing.AccessionNo, "-", "")
+
+	req, err := http.NewRequest("GET", fmt.Sprintf("%v/%v/%v/%v", c.ArchiveURL, filing.CIK, accessionNumber, fileName), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.do(ArchiveEndpoint, req)
+}
+
+// EndpointMetrics counts the calls made to an endpoint.
+type EndpointMetrics struct {
+	Calls int
+	// Calls that failed, by error: "unauthorized", "rate_limited",
+	// "not_found", "processing", "http_<status>" or "other".
+	Errors   map[string]int
+	Duration time.Duration
+}
+
+// Metrics counts calls per endpoint. A nil *Metrics counts nothing.
+type Metrics struct {
+	mutex     sync.Mutex
+	endpoints map[string]*EndpointMetrics
+}
+
+func NewMetrics() *Metrics {
+	return &Metrics{endpoints: make(map[string]*EndpointMetrics)}
+}
+
+func (m *Metrics) record(endpoint string, duration time.Duration, err error) {
+	if m == nil {
+		return
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	metrics, ok := m.endpoints[endpoint]
+	if !ok {
+		metrics = &EndpointMetrics{Errors: make(map[string]int)}
+		m.endpoints[endpoint] = metrics
+	}
+
+	metrics.Calls++
+	metrics.Duration += duration
+	if err != nil {
+		metrics.Errors[errorLabel(err)]++
+	}
+}
+
+// Snapshot returns a copy of the metrics of every endpoint called so far.
+func (m *Metrics) Snapshot() map[string]EndpointMetrics {
+	snapshot := make(map[string]EndpointMetrics)
+	if m == nil {
+		return snapshot
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	for endpoint, metrics := range m.endpoints {
+		errs := make(map[string]int, len(metrics.Errors))
+		for label, count := range metrics.Errors {
+			errs[label] = count
+		}
+		snapshot[endpoint] = EndpointMetrics{Calls: metrics.Calls, Errors: errs, Duration: metrics.Duration}
+	}
+
+	return snapshot
+}
+
+// String summarizes the metrics on one line, e.g. "extractor: 120 calls, 2
+// failed (rate_limited: 2), 31.2s".
+func (m *Metrics) String() string {
+	snapshot := m.Snapshot()
+	endpoints := make([]string, 0, len(snapshot))
+	for endpoint := range snapshot {
+		endpoints = append(endpoints, endpoint)
+	}
+	sort.Strings(endpoints)
+
+	var parts []string
+	for _, endpoint := range endpoints {
+		metrics := snapshot[endpoint]
+		part := fmt.Sprintf("%v: %v calls", endpoint, metrics.Calls)
+		if len(metrics.Errors) > 0 {
+			var failed int
+			var labels []string
+			for label, count := range metrics.Errors {
+				failed += count
+				labels = append(labels, fmt.Sprintf("%v: %v", label, count))
+			}
+			sort.Strings(labels)
+			part += fmt.Sprintf(", %v failed (%v)", failed, strings.Join(labels, ", "))
+		}
+		part += fmt.Sprintf(", %v", metrics.Duration.Round(100*time.Millisecond))
+		parts = append(parts, part)
+	}
+
+	return strings.Join(parts, "; ")
+}
+
+func errorLabel(err error) string {
+	var apiErr *APIError
+	switch {
+	case errors.Is(err, ErrUnauthorized):
+		return "unauthorized"
+	case errors.Is(err, ErrRateLimited):
+		return "rate_limited"
+	case errors.Is(err, ErrNotFound):
+		return "not_found"
+	case errors.Is(err, ErrProcessing):
+		return "processing"
+	case errors.As(err, &apiErr):
+		return fmt.Sprintf("http_%v", apiErr.StatusCode)
+	default:
+		return "other"
+	}
+}
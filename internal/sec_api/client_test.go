@@ -0,0 +1,138 @@
+package sec_api
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// newTestClient returns a client whose endpoints are all served by handler.
+func newTestClient(t *testing.T, handler http.HandlerFunc) *Client {
+	t.Helper()
+
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+
+	client := NewClient("test-key")
+	client.QueryURL = server.URL + "/query"
+	client.MappingURL = server.URL + "/mapping"
+	client.ExtractorURL = server.URL + "/extractor"
+	client.ArchiveURL = server.URL + "/archive"
+
+	return client
+}
+
+func TestClientErrors(t *testing.T) {
+	tests := []struct {
+		status int
+		want   error
+		label  string
+	}{
+		{http.StatusUnauthorized, ErrUnauthorized, "unauthorized"},
+		{http.StatusForbidden, ErrUnauthorized, "unauthorized"},
+		{http.StatusTooManyRequests, ErrRateLimited, "rate_limited"},
+		{http.StatusNotFound, ErrNotFound, "not_found"},
+		{http.StatusBadRequest, nil, "http_400"},
+	}
+	for _, tt := range tests {
+		requests := 0
+		client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+			requests++
+			// Retry at once, rather than after a backoff.
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(tt.status)
+			w.Write([]byte(`{"status":"error"}`))
+		})
+
+		_, err := client.GetTradedCompanies(NYSE)
+		var apiErr *APIError
+		if !errors.As(err, &apiErr) || apiErr.StatusCode != tt.status || apiErr.Endpoint != MappingEndpoint {
+			t.Errorf("%v: error = %v, want an APIError of the mapping endpoint", tt.status, err)
+		}
+		if tt.want != nil && !errors.Is(err, tt.want) {
+			t.Errorf("%v: error = %v, want %v", tt.status, err, tt.want)
+		}
+
+		// Rate limited requests are retried, others are not.
+		if tt.status == http.StatusTooManyRequests && requests < 2 {
+			t.Errorf("%v: made %v requests, want retries", tt.status, requests)
+		} else if tt.status != http.StatusTooManyRequests && requests != 1 {
+			t.Errorf("%v: made %v requests, want 1", tt.status, requests)
+		}
+
+		metrics := client.Metrics.Snapshot()[MappingEndpoint]
+		if metrics.Calls != 1 || metrics.Errors[tt.label] != 1 {
+			t.Errorf("%v: metrics = %+v, want 1 call failing with %v", tt.status, metrics, tt.label)
+		}
+	}
+}
+
+func TestClientSendsKeyInHeader(t *testing.T) {
+	client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "test-key" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if r.URL.Query().Has("token") || r.URL.Query().Has("apiKey") {
+			t.Errorf("API key sent in the query string: %v", r.URL)
+		}
+		w.Write([]byte(`Item 1. Business`))
+	})
+
+	content, err := client.ExtractSectionContent("https://www.sec.gov/aapl-20230930.htm", "1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if content != "Item 1. Business" {
+		t.Errorf("content = %q", content)
+	}
+}
+
+func TestExtractSectionContentProcessing(t *testing.T) {
+	client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("processing\n"))
+	})
+
+	if _, err := client.ExtractSectionContent("https://www.sec.gov/aapl-20230930.htm", "1"); !errors.Is(err, ErrProcessing) {
+		t.Errorf("error = %v, want ErrProcessing", err)
+	}
+
+	metrics := client.Metrics.Snapshot()[ExtractorEndpoint]
+	if metrics.Calls != 1 || metrics.Errors["processing"] != 1 {
+		t.Errorf("metrics = %+v, want 1 call failing with processing", metrics)
+	}
+}
+
+func TestMetricsPerEndpoint(t *testing.T) {
+	client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/query":
+			w.Write([]byte(`{"total":{"value":0},"filings":[]}`))
+		case "/mapping/exchange/nyse":
+			w.Write([]byte(`[]`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	})
+
+	client.GetTradedCompanies(NYSE)
+	client.GetTradedCompanies(NYSE)
+	client.GetFilingsSince("320193", "10-K", time.Now().AddDate(-1, 0, 0), 10)
+	client.GetFilingFile(Filing{CIK: "320193", AccessionNo: "0000320193-23-000106", LinkToFilingDetails: "https://www.sec.gov/aapl-20230930.htm"})
+
+	snapshot := client.Metrics.Snapshot()
+	if m := snapshot[MappingEndpoint]; m.Calls != 2 || len(m.Errors) != 0 {
+		t.Errorf("mapping metrics = %+v, want 2 calls without errors", m)
+	}
+	if m := snapshot[QueryEndpoint]; m.Calls != 1 || len(m.Errors) != 0 {
+		t.Errorf("query metrics = %+v, want 1 call without errors", m)
+	}
+	if m := snapshot[ArchiveEndpoint]; m.Calls != 1 || m.Errors["not_found"] != 1 {
+		t.Errorf("archive metrics = %+v, want 1 call not found", m)
+	}
+	if _, ok := snapshot[ExtractorEndpoint]; ok {
+		t.Errorf("extractor metrics recorded without calls")
+	}
+}
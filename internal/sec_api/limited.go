@@ -0,0 +1,44 @@
+package sec_api
+
+import (
+	"cofin/internal/ratelimit"
+	"cofin/models"
+	"context"
+	"time"
+)
+
+// limitedProvider waits on a rate limiter before every call to the provider.
+type limitedProvider struct {
+	provider Provider
+	limiter  *ratelimit.Limiter
+}
+
+// NewLimitedProvider wraps a provider so that it is called no faster than the
+// limiter allows.
+func NewLimitedProvider(provider Provider, limiter *ratelimit.Limiter) Provider {
+	return limitedProvider{provider: provider, limiter: limiter}
+}
+
+func (p limitedProvider) GetTradedCompanies(exchange Exchange) ([]Listing, error) {
+	if err := p.limiter.Wait(context.Background()); err != nil {
+		return nil, err
+	}
+
+	return p.provider.GetTradedCompanies(exchange)
+}
+
+func (p limitedProvider) GetFilingsSince(cik string, kind models.SourceKind, since time.Time, limit int) ([]Filing, error) {
+	if err := p.limiter.Wait(context.Background()); err != nil {
+		return nil, err
+	}
+
+	return p.provider.GetFilingsSince(cik, kind, since, limit)
+}
+
+func (p limitedProvider) ExtractSectionContent(originURL string, section models.Section) (string, error) {
+	if err := p.limiter.Wait(context.Background()); err != nil {
+		return "", err
+	}
+
+	return p.provider.ExtractSectionContent(originURL, section)
+}
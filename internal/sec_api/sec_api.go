@@ -1,17 +1,11 @@
 package sec_api
 
 import (
-	"bytes"
 	"cofin/models"
-	"encoding/json"
 	"fmt"
-	"io/ioutil"
-	"net/http"
 	"path"
 	"strings"
 	"time"
-
-	"github.com/hashicorp/go-retryablehttp"
 )
 
 type Exchange string
@@ -25,6 +19,20 @@ const (
 // WHEN?!
 var StockExchanges = []Exchange{NYSE, NASDAQ}
 
+// Provider is a source of exchange listings, filings and filing contents.
+// Client implements it using the paid sec-api.io service; the edgar package
+// implements it using EDGAR's public endpoints.
+type Provider interface {
+	// Get companies traded on an exchange.
+	GetTradedCompanies(exchange Exchange) ([]Listing, error)
+	// Get up to limit filings of a kind filed since a point in time, oldest
+	// first.
+	GetFilingsSince(cik string, kind models.SourceKind, since time.Time, limit int) ([]Filing, error)
+	// Get the plain text of a section of the filing at originURL. Returns an
+	// empty string if the filing has no such section.
+	ExtractSectionContent(originURL string, section models.Section) (string, error)
+}
+
 // This is the response from the SEC API when we request a list of companies
 // traded on an exchange.
 type Listing struct {
@@ -47,6 +55,31 @@ type Listing struct {
 	ID           string `json:"id,omitempty"`
 }
 
+// Profile returns the classification of the listed company.
+func (l Listing) Profile() models.CompanyProfile {
+	return models.CompanyProfile{
+		Exchange:     l.Exchange,
+		Category:     l.Category,
+		Sector:       l.Sector,
+		Industry:     l.Industry,
+		SIC:          l.SIC,
+		SICSector:    l.SICSector,
+		SICIndustry:  l.SICIndustry,
+		FAMASector:   l.FAMASector,
+		FAMAIndustry: l.FAMAIndustry,
+		Location:     l.Location,
+	}
+}
+
+// Security returns the listed security.
+func (l Listing) Security() models.ListedSecurity {
+	return models.ListedSecurity{
+		Ticker:   l.Ticker,
+		Exchange: l.Exchange,
+		Delisted: l.IsDelisted,
+	}
+}
+
 // This is the response from the SEC API when we request a list of filings for a
 // company.
 type Filing struct {
@@ -102,35 +135,6 @@ type Entity struct {
 	Sic                  string `json:"sic,omitempty"`
 }
 
-// Get companies traded on an exchange.
-func GetTradedCompanies(key string, exchange Exchange) (listings []Listing, err error) {
-	const exchangeURLTemplate = "https://api.sec-api.io/mapping/exchange/%v"
-	req, err := http.NewRequest("GET", fmt.Sprintf(exchangeURLTemplate, exchange), nil)
-	if err != nil {
-		return nil, err
-	}
-	req.Header.Set("Authorization", key)
-
-	client := retryablehttp.NewClient()
-	client.Logger = nil
-	resp, err := client.StandardClient().Do(req)
-	if err != nil {
-		return nil, err
-	}
-
-	b, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		return nil, err
-	}
-
-	err = json.Unmarshal(b, &listings)
-	if err != nil {
-		return nil, err
-	}
-
-	return listings, nil
-}
-
 func GetFilingOriginURL(filing Filing) string {
 	// Template for paths to the original files on the SEC website.
 	const secFileURLTemplate = "https://www.sec.gov/Archives/edgar/data/%v/%v/%v"
@@ -142,121 +146,24 @@ func GetFilingOriginURL(filing Filing) string {
 	return originURL
 }
 
-func GetSECArchiveURL(filing Filing) string {
-	// Template for downloadable files in the paid SEC API archive.
-	const secArchiveURLTemplate = "https://archive.sec-api.io/%v/%v/%v"
-	// Get the file name.
-	_, fileName := path.Split(filing.LinkToFilingDetails)
-	// In the URL, the accession number should have no dashes.
-	accessionNumber := strings.ReplaceAll(filing.AccessionNo, "-", "")
-	url := fmt.Sprintf(secArchiveURLTemplate, filing.CIK, accessionNumber, fileName)
-	return url
-}
-
-// Get the filing file from the SEC. Return the origin URL on the SEC website,
-// the file bytes, and an error, if there is one.
-func GetFilingFile(key string, filing Filing) (file []byte, err error) {
-	req, err := http.NewRequest("GET", GetSECArchiveURL(filing), nil)
-	if err != nil {
-		return nil, err
-	}
-	req.Header.Set("Authorization", key)
-
-	client := retryablehttp.NewClient()
-	client.Logger = nil
-	resp, err := client.StandardClient().Do(req)
-	if err != nil {
-		return nil, err
-	}
-
-	f, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		return nil, err
-	}
-
-	return f, nil
-}
-
-func GetFilingsSince(key, cik string, kind models.SourceKind, since time.Time, limit int) (filings []Filing, err error) {
-	timeStart := since.Format(time.RFC3339)
-	timeEnd := time.Now().Format(time.RFC3339)
-
-	var jsonStr = []byte(
-		fmt.Sprintf(`{
-			"query": {
-				"query_string": {
-					"query": "formType:\"%v\" AND filedAt:[%v TO %v] AND cik:(%v)",
-					"time_zone": "America/New_York"
-				}
-			},
-			"from": "0",
-			"size": "%v",
-			"sort": [{ "filedAt": { "order": "asc" } }]
-		}`, kind, timeStart, timeEnd, cik, limit),
-	)
-
-	req, err := http.NewRequest("POST", "https://api.sec-api.io", bytes.NewBuffer(jsonStr))
-	if err != nil {
-		return nil, err
-	}
-	req.Header.Set("Authorization", key)
-	req.Header.Set("Content-Type", "application/json")
-
-	client := retryablehttp.NewClient()
-	client.Logger = nil
-	resp, err := client.StandardClient().Do(req)
-	if err != nil {
-		return nil, err
-	}
-
-	b, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		return nil, err
-	}
-
-	type Total struct {
-		Value    int    `json:"value"`
-		Relation string `json:"relation"`
-	}
-
-	type Query struct {
-		From int `json:"from"`
-		Size int `json:"size"`
-	}
-
-	type response struct {
-		Total   Total    `json:"total"`
-		Query   Query    `json:"query"`
-		Filings []Filing `json:"filings"`
-	}
-
-	var r response
-	err = json.Unmarshal(b, &r)
-	if err != nil {
-		return nil, err
-	}
-
-	return r.Filings, nil
-}
-
-func ExtractSectionContent(key, originURL string, section models.Section) (string, error) {
-	const URLTemplate = "https://api.sec-api.io/extractor?token=%v&url=%v&item=%v&type=text"
-	req, err := http.NewRequest("GET", fmt.Sprintf(URLTemplate, key, originURL, section), nil)
-	if err != nil {
-		return "", err
-	}
-
-	client := retryablehttp.NewClient()
-	client.Logger = nil
-	resp, err := client.StandardClient().Do(req)
-	if err != nil {
-		return "", err
+// GetAccessionNoFromOriginURL recovers the accession number of a filing from
+// the path to its original file on the SEC website. ok is false if the URL is
+// not such a path.
+func GetAccessionNoFromOriginURL(originURL string) (accessionNo string, ok bool) {
+	// The path is /Archives/edgar/data/<CIK>/<accession number>/<file name>,
+	// with the accession number of the form 0000320193-23-000106 written
+	// without dashes.
+	parts := strings.Split(strings.TrimPrefix(originURL, "https://www.sec.gov/"), "/")
+	if len(parts) != 6 || parts[0] != "Archives" || len(parts[4]) != 18 {
+		return "", false
 	}
 
-	b, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		return "", err
+	digits := parts[4]
+	for _, digit := range digits {
+		if digit < '0' || digit > '9' {
+			return "", false
+		}
 	}
 
-	return string(b), nil
+	return digits[:10] + "-" + digits[10:12] + "-" + digits[12:], true
 }
@@ -10,6 +10,7 @@ import (
 	checkoutSession "github.com/stripe/stripe-go/v74/checkout/session"
 	"github.com/stripe/stripe-go/v74/customer"
 	"github.com/stripe/stripe-go/v74/price"
+	"github.com/stripe/stripe-go/v74/subscription"
 	"github.com/stripe/stripe-go/v74/webhook"
 )
 
@@ -44,6 +45,28 @@ func (s StripeAPI) CreateCustomer(email, fullName string) (stripeCustomerID stri
 	return stripeCustomer.ID, nil
 }
 
+// CancelSubscriptions cancels the subscriptions of a customer that are not
+// canceled yet, at once. The customer is kept, with its invoices and payments.
+func (s StripeAPI) CancelSubscriptions(stripeCustomerID string) error {
+	stripe.Key = s.apiKey
+	params := &stripe.SubscriptionListParams{
+		Customer: stripe.String(stripeCustomerID),
+	}
+
+	var errs []error
+	i := subscription.List(params)
+	for i.Next() {
+		if _, err := subscription.Cancel(i.Subscription().ID, nil); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if err := i.Err(); err != nil {
+		errs = append(errs, err)
+	}
+
+	return errors.Join(errs...)
+}
+
 func (s StripeAPI) GetPrices() []*stripe.Price {
 	stripe.Key = s.apiKey
 	productID := s.ProductID
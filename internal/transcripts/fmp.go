@@ -0,0 +1,106 @@
+package transcripts
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/go-retryablehttp"
+)
+
+// FMP fetches transcripts from the Financial Modeling Prep API.
+type FMP struct {
+	key string
+}
+
+// NewProvider returns the configured transcript provider, or nil if no
+// provider is configured.
+func NewProvider() Provider {
+	if key := os.Getenv("FMP_API_KEY"); key != "" {
+		return FMP{key: key}
+	}
+
+	return nil
+}
+
+func (f FMP) GetTranscriptsSince(ticker string, since time.Time) ([]Transcript, error) {
+	type dto struct {
+		Symbol  string `json:"symbol"`
+		Quarter int    `json:"quarter"`
+		Year    int    `json:"year"`
+		Date    string `json:"date"`
+		Content string `json:"content"`
+	}
+
+	const URLTemplate = "https://financialmodelingprep.com/api/v4/batch_earning_call_transcript/%v?year=%v&apikey=%v"
+
+	client := retryablehttp.NewClient()
+	client.Logger = nil
+
+	var transcripts []Transcript
+	for year := since.Year(); year <= time.Now().Year(); year++ {
+		resp, err := client.StandardClient().Get(fmt.Sprintf(URLTemplate, url.PathEscape(ticker), year, f.key))
+		if err != nil {
+			return nil, f.redact(err)
+		}
+
+		b, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("unexpected status %v: %v", resp.StatusCode, string(b))
+		}
+
+		var ds []dto
+		if err := json.Unmarshal(b, &ds); err != nil {
+			return nil, fmt.Errorf("%v: %w", string(b), err)
+		}
+
+		// The API lists the most recent call first.
+		for i := len(ds) - 1; i >= 0; i-- {
+			d := ds[i]
+			heldAt, err := time.ParseInLocation("2006-01-02 15:04:05", d.Date, newYork)
+			if err != nil {
+				return nil, err
+			}
+
+			if !heldAt.After(since) {
+				continue
+			}
+
+			transcripts = append(transcripts, Transcript{
+				Ticker: d.Symbol,
+				Title:  fmt.Sprintf("Q%v %v earnings call", d.Quarter, d.Year),
+				HeldAt: heldAt,
+				Turns:  ParseTurns(d.Content),
+			})
+		}
+	}
+
+	return transcripts, nil
+}
+
+// redact removes the API key from an error. Request errors carry the URL,
+// and with it the key in the query string, in their message.
+func (f FMP) redact(err error) error {
+	return errors.New(strings.ReplaceAll(err.Error(), f.key, "REDACTED"))
+}
+
+// FMP reports call times in US Eastern time.
+var newYork = func() *time.Location {
+	location, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		return time.UTC
+	}
+
+	return location
+}()
@@ -0,0 +1,56 @@
+package transcripts
+
+import (
+	"cofin/internal/retrieval"
+	"cofin/models"
+	"fmt"
+	"strings"
+
+	"github.com/tmc/langchaingo/schema"
+	"github.com/tmc/langchaingo/vectorstores"
+	"gorm.io/gorm"
+)
+
+// Ingest stores the transcript as a document of the company and indexes it in
+// the company's vector store. Every speaker turn is indexed separately, with
+// speaker and role metadata, so that retrieved paragraphs never mix up who
+// said what. Turns longer than a chunk are split with the splitter.
+func Ingest(db *gorm.DB, store vectorstores.VectorStore, splitter *retrieval.Splitter, company *models.Company, transcript Transcript) (*models.Document, error) {
+	if len(transcript.Turns) == 0 {
+		return nil, fmt.Errorf("transcript has no turns")
+	}
+
+	var chunks []schema.Document
+	for i, turn := range transcript.Turns {
+		texts, err := splitter.SplitText(strings.TrimSpace(turn.Text))
+		if err != nil {
+			return nil, err
+		}
+
+		for _, text := range texts {
+			chunks = append(chunks, schema.Document{
+				PageContent: FormatTurn(Turn{Speaker: turn.Speaker, Role: turn.Role, Text: text}),
+				Metadata: map[string]interface{}{
+					"speaker": turn.Speaker,
+					"role":    string(turn.Role),
+					"turn":    i,
+				},
+			})
+		}
+	}
+
+	var document *models.Document
+	err := db.Transaction(func(tx *gorm.DB) (err error) {
+		document, err = models.CreateTranscriptDocument(tx, company, transcript.HeldAt, transcript.OriginURL, Format(transcript.Turns), transcript.Uploaded)
+		if err != nil {
+			return err
+		}
+
+		return retrieval.StoreChunks(store, document.ID, chunks)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return document, nil
+}
@@ -0,0 +1,195 @@
+package transcripts
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Role describes who is speaking in a turn of an earnings call.
+type Role string
+
+const (
+	Operator   Role = "operator"
+	Management Role = "management"
+	Analyst    Role = "analyst"
+	Unknown    Role = "unknown"
+)
+
+// Turn is a single uninterrupted stretch of speech by one speaker.
+type Turn struct {
+	Speaker string `json:"speaker" binding:"required"`
+	Role    Role   `json:"role"`
+	Text    string `json:"text" binding:"required"`
+}
+
+// Transcript is an earnings call transcript split into speaker turns.
+type Transcript struct {
+	Ticker    string
+	Title     string
+	HeldAt    time.Time
+	OriginURL string
+	Turns     []Turn
+	// Whether an admin uploaded the transcript.
+	Uploaded bool
+}
+
+// Provider is a source of earnings call transcripts.
+type Provider interface {
+	// GetTranscriptsSince returns transcripts of calls held after since,
+	// oldest first.
+	GetTranscriptsSince(ticker string, since time.Time) ([]Transcript, error)
+}
+
+// Matches "Speaker: text" at the start of a line. Speaker labels are short,
+// capitalised and do not contain sentence punctuation, which keeps us from
+// splitting on colons inside of regular speech.
+var turnHeaderRegexp = regexp.MustCompile(`^([A-Z][^:.?!]{0,79}):\s*(.*)$`)
+
+// Speaker labels longer than this many words are treated as speech.
+const maxSpeakerLabelWords = 10
+
+// Matches the conventional "Name -- Title" speaker label.
+var speakerTitleRegexp = regexp.MustCompile(`^(.+?)\s+(?:--|—|–|-)\s+(.+)$`)
+
+// Phrases the operator uses to open the Q&A section of the call.
+var questionsMarkers = []string{
+	"question-and-answer",
+	"question and answer",
+	"first question",
+	"open the line for questions",
+	"open the call for questions",
+	"open it up for questions",
+}
+
+// Words in a speaker title that identify company management.
+var managementTitles = []string{
+	"chief", "ceo", "cfo", "coo", "cto", "president", "officer", "director",
+	"treasurer", "head of", "investor relations", "vp", "founder", "chairman",
+}
+
+// ParseTurns splits a plain-text transcript into speaker turns. Every turn
+// starts on a new line with "Speaker: " or "Speaker -- Title: ". Lines without
+// a speaker label continue the previous turn.
+//
+// Roles are inferred: the operator is recognised by name, speakers with a title
+// by their title, and the remaining speakers by whether they first speak before
+// or after the operator opens the line for questions.
+func ParseTurns(content string) []Turn {
+	var turns []Turn
+	var titles []string
+	for _, line := range strings.Split(content, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+
+		if match := turnHeaderRegexp.FindStringSubmatch(line); match != nil && len(strings.Fields(match[1])) <= maxSpeakerLabelWords {
+			speaker, title := match[1], ""
+			if m := speakerTitleRegexp.FindStringSubmatch(speaker); m != nil {
+				speaker, title = m[1], m[2]
+			}
+
+			turns = append(turns, Turn{Speaker: strings.TrimSpace(speaker), Text: match[2]})
+			titles = append(titles, title)
+			continue
+		}
+
+		if len(turns) == 0 {
+			continue
+		}
+
+		last := &turns[len(turns)-1]
+		if last.Text == "" {
+			last.Text = line
+		} else {
+			last.Text += "\n" + line
+		}
+	}
+
+	assignRoles(turns, titles)
+	return turns
+}
+
+// InferRoles sets the role of every turn that has none, using the same rules
+// as ParseTurns.
+func InferRoles(turns []Turn) {
+	assignRoles(turns, make([]string, len(turns)))
+}
+
+// assignRoles sets the role of every turn without one in place. titles holds
+// the speaker title parsed for each turn, if any.
+func assignRoles(turns []Turn, titles []string) {
+	roles := make(map[string]Role)
+	inQuestions := false
+	for i := range turns {
+		speaker := turns[i].Speaker
+		if _, ok := roles[speaker]; !ok && turns[i].Role != "" {
+			roles[speaker] = turns[i].Role
+		} else if !ok {
+			roles[speaker] = inferRole(speaker, titles[i], inQuestions, i > 0 && roles[turns[i-1].Speaker] == Operator)
+		}
+
+		if roles[speaker] == Operator && containsAny(strings.ToLower(turns[i].Text), questionsMarkers) {
+			inQuestions = true
+		}
+	}
+
+	for i := range turns {
+		if turns[i].Role == "" {
+			turns[i].Role = roles[turns[i].Speaker]
+		}
+	}
+}
+
+func inferRole(speaker, title string, inQuestions, afterOperator bool) Role {
+	if strings.EqualFold(speaker, "operator") {
+		return Operator
+	}
+
+	if title != "" {
+		lowerTitle := strings.ToLower(title)
+		if strings.Contains(lowerTitle, "analyst") {
+			return Analyst
+		} else if containsAny(lowerTitle, managementTitles) {
+			return Management
+		}
+	}
+
+	if !inQuestions {
+		return Management
+	} else if afterOperator {
+		return Analyst
+	}
+
+	return Unknown
+}
+
+func containsAny(s string, substrings []string) bool {
+	for _, substring := range substrings {
+		if strings.Contains(s, substring) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Format renders transcript turns as plain text, one turn per paragraph.
+func Format(turns []Turn) string {
+	var b strings.Builder
+	for i, turn := range turns {
+		if i > 0 {
+			b.WriteString("\n\n")
+		}
+		b.WriteString(FormatTurn(turn))
+	}
+
+	return b.String()
+}
+
+// FormatTurn renders a single turn with its speaker label.
+func FormatTurn(turn Turn) string {
+	return fmt.Sprintf("%v (%v): %v", turn.Speaker, turn.Role, turn.Text)
+}
@@ -0,0 +1,299 @@
+// Package websocket implements the server side of the WebSocket protocol (RFC
+// 6455), enough to push JSON messages to browsers and read their small text
+// messages. Extensions and fragmented messages from clients are not supported.
+package websocket
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Opcodes of the frames of RFC 6455.
+const (
+	opText   = 0x1
+	opBinary = 0x2
+	opClose  = 0x8
+	opPing   = 0x9
+	opPong   = 0xA
+)
+
+// Close status codes.
+const (
+	CloseNormal          = 1000
+	CloseGoingAway       = 1001
+	CloseProtocolError   = 1002
+	CloseUnsupportedData = 1003
+	CloseMessageTooBig   = 1009
+)
+
+// MAX_MESSAGE_SIZE is the largest message read from a client. Clients only send
+// small commands.
+const MAX_MESSAGE_SIZE = 64 * 1024
+
+// MAX_CONTROL_PAYLOAD_SIZE is the largest payload of a control frame.
+const MAX_CONTROL_PAYLOAD_SIZE = 125
+
+// WRITE_TIMEOUT bounds how long a write to a slow client may block.
+const WRITE_TIMEOUT = 10 * time.Second
+
+// acceptGUID is appended to the client's key to compute the accept key.
+const acceptGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// ErrClosed is returned by ReadMessage once the client closes the connection.
+var ErrClosed = errors.New("websocket: connection closed")
+
+// Conn is a WebSocket connection. ReadMessage must only be called from one
+// goroutine at a time, while writes may be made from any.
+type Conn struct {
+	conn   net.Conn
+	reader *bufio.Reader
+
+	writeMutex sync.Mutex
+	closed     bool
+}
+
+// IsUpgrade reports whether the request asks to upgrade to WebSocket.
+func IsUpgrade(r *http.Request) bool {
+	return headerContains(r.Header, "Connection", "upgrade") && headerContains(r.Header, "Upgrade", "websocket")
+}
+
+// Upgrade completes the WebSocket handshake of the request and takes over its
+// connection. On error, an error response has been written.
+func Upgrade(w http.ResponseWriter, r *http.Request) (*Conn, error) {
+	if r.Method != http.MethodGet || !IsUpgrade(r) {
+		http.Error(w, "expected a WebSocket upgrade", http.StatusBadRequest)
+		return nil, errors.New("websocket: not an upgrade request")
+	}
+
+	if r.Header.Get("Sec-WebSocket-Version") != "13" {
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		http.Error(w, "unsupported WebSocket version", http.StatusUpgradeRequired)
+		return nil, errors.New("websocket: unsupported version")
+	}
+
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if key == "" {
+		http.Error(w, "missing Sec-WebSocket-Key", http.StatusBadRequest)
+		return nil, errors.New("websocket: missing key")
+	}
+
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "WebSocket not supported", http.StatusInternalServerError)
+		return nil, errors.New("websocket: response does not support hijacking")
+	}
+
+	conn, buffer, err := hijacker.Hijack()
+	if err != nil {
+		return nil, err
+	}
+
+	response := "HTTP/1.1 101 Switching Protocols\r\n" +
+		"Upgrade: websocket\r\n" +
+		"Connection: Upgrade\r\n" +
+		"Sec-WebSocket-Accept: " + acceptKey(key) + "\r\n\r\n"
+	conn.SetWriteDeadline(time.Now().Add(WRITE_TIMEOUT))
+	if _, err := conn.Write([]byte(response)); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	return &Conn{conn: conn, reader: buffer.Reader}, nil
+}
+
+func acceptKey(key string) string {
+	h := sha1.New()
+	h.Write([]byte(key + acceptGUID))
+	return base64.StdEncoding.EncodeToString(h.Sum(nil))
+}
+
+func headerContains(header http.Header, name, token string) bool {
+	for _, value := range header.Values(name) {
+		for _, part := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(part), token) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// ReadMessage returns the next text or binary message from the client. Pings
+// are answered and pongs are skipped. It returns ErrClosed once the client
+// closes the connection.
+func (c *Conn) ReadMessage() ([]byte, error) {
+	for {
+		final, opcode, payload, err := c.readFrame()
+		if err != nil {
+			return nil, err
+		}
+
+		switch opcode {
+		case opText, opBinary:
+			if !final {
+				c.CloseWithStatus(CloseUnsupportedData, "fragmented messages are not supported")
+				return nil, errors.New("websocket: fragmented message")
+			}
+			return payload, nil
+		case opPing:
+			if err := c.writeFrame(opPong, payload); err != nil {
+				return nil, err
+			}
+		case opPong:
+		case opClose:
+			c.CloseWithStatus(CloseNormal, "")
+			return nil, ErrClosed
+		default:
+			c.CloseWithStatus(CloseProtocolError, "unexpected opcode")
+			return nil, fmt.Errorf("websocket: unexpected opcode %v", opcode)
+		}
+	}
+}
+
+// isControl reports whether an opcode is of a control frame: close, ping or
+// pong.
+func isControl(opcode byte) bool {
+	return opcode&0x8 != 0
+}
+
+// readFrame reads a frame. Frames from clients are always masked.
+func (c *Conn) readFrame() (final bool, opcode byte, payload []byte, err error) {
+	var header [2]byte
+	if _, err := io.ReadFull(c.reader, header[:]); err != nil {
+		return false, 0, nil, err
+	}
+
+	final = header[0]&0x80 != 0
+	opcode = header[0] & 0x0F
+	masked := header[1]&0x80 != 0
+	length := uint64(header[1] & 0x7F)
+
+	// Control frames must not be fragmented, and their payload must fit the
+	// 7-bit length.
+	if header[0]&0x70 != 0 || !masked || (isControl(opcode) && (!final || length > MAX_CONTROL_PAYLOAD_SIZE)) {
+		c.CloseWithStatus(CloseProtocolError, "")
+		return false, 0, nil, errors.New("websocket: invalid frame")
+	}
+
+	switch length {
+	case 126:
+		var extended [2]byte
+		if _, err := io.ReadFull(c.reader, extended[:]); err != nil {
+			return false, 0, nil, err
+		}
+		length = uint64(binary.BigEndian.Uint16(extended[:]))
+	case 127:
+		var extended [8]byte
+		if _, err := io.ReadFull(c.reader, extended[:]); err != nil {
+			return false, 0, nil, err
+		}
+		length = binary.BigEndian.Uint64(extended[:])
+	}
+
+	if length > MAX_MESSAGE_SIZE {
+		c.CloseWithStatus(CloseMessageTooBig, "")
+		return false, 0, nil, errors.New("websocket: message too big")
+	}
+
+	var mask [4]byte
+	if _, err := io.ReadFull(c.reader, mask[:]); err != nil {
+		return false, 0, nil, err
+	}
+
+	payload = make([]byte, length)
+	if _, err := io.ReadFull(c.reader, payload); err != nil {
+		return false, 0, nil, err
+	}
+	for i := range payload {
+		payload[i] ^= mask[i%4]
+	}
+
+	return final, opcode, payload, nil
+}
+
+// WriteText sends a text message.
+func (c *Conn) WriteText(text []byte) error {
+	return c.writeFrame(opText, text)
+}
+
+// WriteJSON sends a value as a JSON text message.
+func (c *Conn) WriteJSON(v interface{}) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	return c.WriteText(b)
+}
+
+// Ping sends a ping, which keeps proxies from closing an idle connection.
+func (c *Conn) Ping() error {
+	return c.writeFrame(opPing, nil)
+}
+
+// writeFrame writes an unfragmented, unmasked frame, as servers do.
+func (c *Conn) writeFrame(opcode byte, payload []byte) error {
+	c.writeMutex.Lock()
+	defer c.writeMutex.Unlock()
+
+	if c.closed {
+		return ErrClosed
+	}
+
+	header := []byte{0x80 | opcode}
+	switch length := len(payload); {
+	case length < 126:
+		header = append(header, byte(length))
+	case length <= 0xFFFF:
+		header = append(header, 126)
+		header = binary.BigEndian.AppendUint16(header, uint16(length))
+	default:
+		header = append(header, 127)
+		header = binary.BigEndian.AppendUint64(header, uint64(length))
+	}
+
+	c.conn.SetWriteDeadline(time.Now().Add(WRITE_TIMEOUT))
+	if _, err := c.conn.Write(append(header, payload...)); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// CloseWithStatus sends a close frame with a status code and reason, and
+// closes the connection. The reason is cut to fit a control frame.
+func (c *Conn) CloseWithStatus(status int, reason string) error {
+	payload := binary.BigEndian.AppendUint16(nil, uint16(status))
+	payload = append(payload, reason...)
+	if len(payload) > MAX_CONTROL_PAYLOAD_SIZE {
+		payload = payload[:MAX_CONTROL_PAYLOAD_SIZE]
+	}
+	c.writeFrame(opClose, payload)
+
+	return c.Close()
+}
+
+// Close closes the connection without a close frame.
+func (c *Conn) Close() error {
+	c.writeMutex.Lock()
+	defer c.writeMutex.Unlock()
+
+	if c.closed {
+		return nil
+	}
+	c.closed = true
+
+	return c.conn.Close()
+}
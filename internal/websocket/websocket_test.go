@@ -0,0 +1,272 @@
+package websocket
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// newTestConn returns a server connection and the client end of it.
+func newTestConn(t *testing.T) (*Conn, net.Conn) {
+	t.Helper()
+
+	server, client := net.Pipe()
+	t.Cleanup(func() {
+		server.Close()
+		client.Close()
+	})
+
+	return &Conn{conn: server, reader: bufio.NewReader(server)}, client
+}
+
+// sendFrame writes a masked frame from the client, as browsers do, unless
+// unmasked is set. It writes on its own goroutine, as the server may answer
+// before reading all of the frame.
+func sendFrame(client net.Conn, first byte, payload []byte, unmasked bool) {
+	frame := []byte{first}
+	maskBit := byte(0x80)
+	if unmasked {
+		maskBit = 0
+	}
+	switch length := len(payload); {
+	case length < 126:
+		frame = append(frame, maskBit|byte(length))
+	case length <= 0xFFFF:
+		frame = append(frame, maskBit|126)
+		frame = binary.BigEndian.AppendUint16(frame, uint16(length))
+	default:
+		frame = append(frame, maskBit|127)
+		frame = binary.BigEndian.AppendUint64(frame, uint64(length))
+	}
+
+	masked := append([]byte{}, payload...)
+	if !unmasked {
+		mask := []byte{0x12, 0x34, 0x56, 0x78}
+		frame = append(frame, mask...)
+		for i := range masked {
+			masked[i] ^= mask[i%4]
+		}
+	}
+
+	go client.Write(append(frame, masked...))
+}
+
+// receiveFrame reads a frame sent by the server.
+func receiveFrame(t *testing.T, client net.Conn) (first byte, payload []byte) {
+	t.Helper()
+
+	var header [2]byte
+	if _, err := io.ReadFull(client, header[:]); err != nil {
+		t.Fatalf("reading frame: %v", err)
+	}
+	if header[1]&0x80 != 0 {
+		t.Fatal("server frame is masked")
+	}
+
+	length := uint64(header[1] & 0x7F)
+	switch length {
+	case 126:
+		var extended [2]byte
+		io.ReadFull(client, extended[:])
+		length = uint64(binary.BigEndian.Uint16(extended[:]))
+	case 127:
+		var extended [8]byte
+		io.ReadFull(client, extended[:])
+		length = binary.BigEndian.Uint64(extended[:])
+	}
+
+	payload = make([]byte, length)
+	if _, err := io.ReadFull(client, payload); err != nil {
+		t.Fatalf("reading payload: %v", err)
+	}
+
+	return header[0], payload
+}
+
+type readResult struct {
+	message []byte
+	err     error
+}
+
+// readMessage reads a message on the server on its own goroutine.
+func readMessage(conn *Conn) <-chan readResult {
+	result := make(chan readResult, 1)
+	go func() {
+		message, err := conn.ReadMessage()
+		result <- readResult{message, err}
+	}()
+
+	return result
+}
+
+// expectClose checks that the server sends a close frame with the status.
+func expectClose(t *testing.T, client net.Conn, status int) {
+	t.Helper()
+
+	first, payload := receiveFrame(t, client)
+	if first != 0x80|opClose {
+		t.Fatalf("frame %#x, want a close frame", first)
+	}
+	if len(payload) < 2 || int(binary.BigEndian.Uint16(payload)) != status {
+		t.Errorf("close payload %v, want status %v", payload, status)
+	}
+	if len(payload) > MAX_CONTROL_PAYLOAD_SIZE {
+		t.Errorf("close payload of %v bytes", len(payload))
+	}
+}
+
+func TestAcceptKey(t *testing.T) {
+	// The example of RFC 6455.
+	if got := acceptKey("dGhlIHNhbXBsZSBub25jZQ=="); got != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+		t.Errorf("acceptKey = %v", got)
+	}
+}
+
+func TestUpgrade(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := Upgrade(w, r)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		message, err := conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		conn.WriteText(message)
+	}))
+	defer server.Close()
+
+	client, err := net.Dial("tcp", server.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	client.Write([]byte("GET / HTTP/1.1\r\nHost: localhost\r\nConnection: keep-alive, Upgrade\r\nUpgrade: websocket\r\n" +
+		"Sec-WebSocket-Version: 13\r\nSec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\n\r\n"))
+
+	reader := bufio.NewReader(client)
+	resp, err := http.ReadResponse(reader, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols || resp.Header.Get("Sec-WebSocket-Accept") != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+		t.Fatalf("handshake response %v %v", resp.Status, resp.Header)
+	}
+
+	sendFrame(client, 0x80|opText, []byte(`{"action":"subscribe"}`), false)
+	var header [2]byte
+	io.ReadFull(reader, header[:])
+	echo := make([]byte, header[1])
+	io.ReadFull(reader, echo)
+	if header[0] != 0x80|opText || string(echo) != `{"action":"subscribe"}` {
+		t.Errorf("echo %#x %q", header[0], echo)
+	}
+}
+
+func TestUpgradeRejectsOtherVersions(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set("Connection", "Upgrade")
+	r.Header.Set("Upgrade", "websocket")
+	r.Header.Set("Sec-WebSocket-Version", "8")
+	r.Header.Set("Sec-WebSocket-Key", "dGhlIHNhbXBsZSBub25jZQ==")
+	w := httptest.NewRecorder()
+
+	if _, err := Upgrade(w, r); err == nil || w.Code != http.StatusUpgradeRequired {
+		t.Errorf("Upgrade = %v, %v, want 426", err, w.Code)
+	}
+}
+
+func TestReadMessage(t *testing.T) {
+	conn, client := newTestConn(t)
+
+	result := readMessage(conn)
+	// Pings are answered with the same payload and pongs are skipped,
+	// before the message is returned.
+	sendFrame(client, 0x80|opPing, []byte("ping"), false)
+	if first, payload := receiveFrame(t, client); first != 0x80|opPong || string(payload) != "ping" {
+		t.Errorf("answer to ping %#x %q, want pong", first, payload)
+	}
+	sendFrame(client, 0x80|opPong, nil, false)
+
+	message := bytes.Repeat([]byte("a"), 300)
+	sendFrame(client, 0x80|opText, message, false)
+	if r := <-result; r.err != nil || !bytes.Equal(r.message, message) {
+		t.Errorf("ReadMessage = %q, %v", r.message, r.err)
+	}
+}
+
+func TestReadMessageClose(t *testing.T) {
+	conn, client := newTestConn(t)
+
+	result := readMessage(conn)
+	sendFrame(client, 0x80|opClose, binary.BigEndian.AppendUint16(nil, CloseNormal), false)
+	expectClose(t, client, CloseNormal)
+	if r := <-result; !errors.Is(r.err, ErrClosed) {
+		t.Errorf("ReadMessage error = %v, want ErrClosed", r.err)
+	}
+}
+
+func TestReadMessageInvalidFrames(t *testing.T) {
+	tests := []struct {
+		name     string
+		first    byte
+		payload  []byte
+		unmasked bool
+		status   int
+	}{
+		{"unmasked", 0x80 | opText, []byte("hi"), true, CloseProtocolError},
+		{"reserved bit", 0x80 | 0x40 | opText, []byte("hi"), false, CloseProtocolError},
+		{"fragmented ping", opPing, []byte("hi"), false, CloseProtocolError},
+		{"fragmented close", opClose, nil, false, CloseProtocolError},
+		{"ping over 125 bytes", 0x80 | opPing, bytes.Repeat([]byte("a"), 126), false, CloseProtocolError},
+		{"close over 125 bytes", 0x80 | opClose, bytes.Repeat([]byte("a"), 200), false, CloseProtocolError},
+		{"unknown opcode", 0x80 | 0x3, nil, false, CloseProtocolError},
+		{"fragmented message", opText, []byte("hi"), false, CloseUnsupportedData},
+		{"message too big", 0x80 | opBinary, make([]byte, MAX_MESSAGE_SIZE+1), false, CloseMessageTooBig},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn, client := newTestConn(t)
+
+			result := readMessage(conn)
+			sendFrame(client, tt.first, tt.payload, tt.unmasked)
+			expectClose(t, client, tt.status)
+			if r := <-result; r.err == nil {
+				t.Errorf("ReadMessage = %q, want an error", r.message)
+			}
+		})
+	}
+}
+
+func TestWriteFrameLengths(t *testing.T) {
+	for _, length := range []int{0, 125, 126, 0xFFFF, 0x10000} {
+		conn, client := newTestConn(t)
+
+		payload := bytes.Repeat([]byte("a"), length)
+		go conn.WriteText(payload)
+		first, got := receiveFrame(t, client)
+		if first != 0x80|opText || !bytes.Equal(got, payload) {
+			t.Errorf("frame of %v bytes read as %#x with %v bytes", length, first, len(got))
+		}
+	}
+}
+
+func TestCloseWithStatusLongReason(t *testing.T) {
+	conn, client := newTestConn(t)
+
+	go conn.CloseWithStatus(CloseGoingAway, string(bytes.Repeat([]byte("a"), 200)))
+	expectClose(t, client, CloseGoingAway)
+
+	if err := conn.WriteText([]byte("late")); !errors.Is(err, ErrClosed) {
+		t.Errorf("write after close = %v, want ErrClosed", err)
+	}
+}
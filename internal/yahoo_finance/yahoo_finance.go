@@ -0,0 +1,337 @@
+// Package yahoo_finance fetches quotes and historical prices from the Yahoo
+// Finance chart API.
+package yahoo_finance
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/go-retryablehttp"
+)
+
+const DefaultChartURL = "https://query1.finance.yahoo.com/v8/finance/chart"
+
+// Bar is the trading of a security over a day.
+type Bar struct {
+	// Trading day, at midnight UTC.
+	Date          time.Time
+	Open          float64
+	High          float64
+	Low           float64
+	Close         float64
+	AdjustedClose float64
+	Volume        int64
+}
+
+type YahooFinance struct {
+	ChartURL string
+	HTTP     *http.Client
+}
+
+func NewYahooFinance() *YahooFinance {
+	client := retryablehttp.NewClient()
+	client.Logger = nil
+
+	return &YahooFinance{
+		ChartURL: DefaultChartURL,
+		HTTP:     client.StandardClient(),
+	}
+}
+
+// GetDailyBars returns the daily bars of a ticker from from to to, inclusive,
+// oldest first. Days without trades are left out.
+func (yf *YahooFinance) GetDailyBars(ticker string, from, to time.Time) ([]Bar, error) {
+	// Yahoo separates share classes with a dash, e.g. BRK-B.
+	symbol := strings.ReplaceAll(strings.ToUpper(ticker), ".", "-")
+	url := fmt.Sprintf("%v/%v?period1=%v&period2=%v&interval=1d&events=history", yf.ChartURL, symbol, from.Unix(), to.AddDate(0, 0, 1).Unix())
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	// The API rejects requests without a browser-like user agent.
+	req.Header.Set("User-Agent", "Mozilla/5.0")
+
+	res, err := yf.HTTP.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	type quote struct {
+		Open   []*float64 `json:"open"`
+		High   []*float64 `json:"high"`
+		Low    []*float64 `json:"low"`
+		Close  []*float64 `json:"close"`
+		Volume []*int64   `json:"volume"`
+	}
+	type adjustedClose struct {
+		AdjustedClose []*float64 `json:"adjclose"`
+	}
+	type result struct {
+		Meta struct {
+			ExchangeTimezoneName string `json:"exchangeTimezoneName"`
+		} `json:"meta"`
+		Timestamp  []int64 `json:"timestamp"`
+		Indicators struct {
+			Quote         []quote         `json:"quote"`
+			AdjustedClose []adjustedClose `json:"adjclose"`
+		} `json:"indicators"`
+	}
+	type dto struct {
+		Chart struct {
+			Result []result `json:"result"`
+			Error  *struct {
+				Code        string `json:"code"`
+				Description string `json:"description"`
+			} `json:"error"`
+		} `json:"chart"`
+	}
+
+	var d dto
+	if err := json.Unmarshal(body, &d); err != nil {
+		return nil, fmt.Errorf("unexpected response with status %v: %w", res.StatusCode, err)
+	}
+	if d.Chart.Error != nil {
+		return nil, fmt.Errorf("failed to get prices of %v: %v", ticker, d.Chart.Error.Description)
+	}
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to get prices of %v: status %v", ticker, res.StatusCode)
+	}
+	if len(d.Chart.Result) == 0 || len(d.Chart.Result[0].Indicators.Quote) == 0 {
+		return nil, nil
+	}
+
+	r := d.Chart.Result[0]
+	q := r.Indicators.Quote[0]
+	var adjusted []*float64
+	if len(r.Indicators.AdjustedClose) > 0 {
+		adjusted = r.Indicators.AdjustedClose[0].AdjustedClose
+	}
+
+	// Timestamps are of the market open, so the trading day is the date in the
+	// exchange's time zone.
+	location, err := time.LoadLocation(r.Meta.ExchangeTimezoneName)
+	if err != nil {
+		location = time.UTC
+	}
+
+	value := func(values []*float64, i int) float64 {
+		if i < len(values) && values[i] != nil {
+			return *values[i]
+		}
+		return 0
+	}
+
+	var bars []Bar
+	for i, timestamp := range r.Timestamp {
+		if i >= len(q.Close) || q.Close[i] == nil {
+			continue
+		}
+
+		local := time.Unix(timestamp, 0).In(location)
+		bar := Bar{
+			Date:          time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, time.UTC),
+			Open:          value(q.Open, i),
+			High:          value(q.High, i),
+			Low:           value(q.Low, i),
+			Close:         *q.Close[i],
+			AdjustedClose: value(adjusted, i),
+		}
+		if bar.AdjustedClose == 0 {
+			bar.AdjustedClose = bar.Close
+		}
+		if i < len(q.Volume) && q.Volume[i] != nil {
+			bar.Volume = *q.Volume[i]
+		}
+
+		bars = append(bars, bar)
+	}
+
+	return bars, nil
+}
+
+// Quote is the latest trade of a security.
+type Quote struct {
+	Currency string
+	Price    float64
+	// Change since the previous close, in percent.
+	Change float64
+	Volume int64
+	AsOf   time.Time
+}
+
+// GetQuote returns the latest quote of a ticker.
+func (yf *YahooFinance) GetQuote(ticker string) (*Quote, error) {
+	symbol := strings.ReplaceAll(strings.ToUpper(ticker), ".", "-")
+	req, err := http.NewRequest("GET", fmt.Sprintf("%v/%v?range=1d&interval=1d", yf.ChartURL, symbol), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", "Mozilla/5.0")
+
+	res, err := yf.HTTP.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	type meta struct {
+		Currency           string   `json:"currency"`
+		RegularMarketPrice *float64 `json:"regularMarketPrice"`
+		ChartPreviousClose float64  `json:"chartPreviousClose"`
+		PreviousClose      float64  `json:"previousClose"`
+		RegularMarketTime  int64    `json:"regularMarketTime"`
+		RegularMarketVol   int64    `json:"regularMarketVolume"`
+	}
+	type dto struct {
+		Chart struct {
+			Result []struct {
+				Meta meta `json:"meta"`
+			} `json:"result"`
+			Error *struct {
+				Code        string `json:"code"`
+				Description string `json:"description"`
+			} `json:"error"`
+		} `json:"chart"`
+	}
+
+	var d dto
+	if err := json.Unmarshal(body, &d); err != nil {
+		return nil, fmt.Errorf("unexpected response with status %v: %w", res.StatusCode, err)
+	}
+	if d.Chart.Error != nil {
+		return nil, fmt.Errorf("failed to get quote of %v: %v", ticker, d.Chart.Error.Description)
+	}
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to get quote of %v: status %v", ticker, res.StatusCode)
+	}
+	if len(d.Chart.Result) == 0 || d.Chart.Result[0].Meta.RegularMarketPrice == nil {
+		return nil, fmt.Errorf("no quote of %v", ticker)
+	}
+
+	m := d.Chart.Result[0].Meta
+	quote := &Quote{
+		Currency: m.Currency,
+		Price:    *m.RegularMarketPrice,
+		Volume:   m.RegularMarketVol,
+		AsOf:     time.Unix(m.RegularMarketTime, 0),
+	}
+
+	previousClose := m.PreviousClose
+	if previousClose == 0 {
+		previousClose = m.ChartPreviousClose
+	}
+	if previousClose != 0 {
+		quote.Change = (quote.Price - previousClose) / previousClose * 100
+	}
+
+	return quote, nil
+}
+
+// Most tickers the spark endpoint accepts at once.
+const MAX_QUOTES_PER_REQUEST = 20
+
+// GetQuotes returns the latest quotes of up to MAX_QUOTES_PER_REQUEST tickers,
+// keyed by upper-case ticker. Tickers without quotes are left out.
+func (yf *YahooFinance) GetQuotes(tickers []string) (map[string]*Quote, error) {
+	if len(tickers) > MAX_QUOTES_PER_REQUEST {
+		return nil, fmt.Errorf("at most %v tickers can be quoted at once", MAX_QUOTES_PER_REQUEST)
+	}
+
+	// Map Yahoo symbols back to our tickers.
+	symbols := map[string]string{}
+	for _, ticker := range tickers {
+		symbols[strings.ReplaceAll(strings.ToUpper(ticker), ".", "-")] = strings.ToUpper(ticker)
+	}
+	var symbolList []string
+	for symbol := range symbols {
+		symbolList = append(symbolList, symbol)
+	}
+
+	sparkURL := strings.TrimSuffix(yf.ChartURL, "/chart") + "/spark"
+	req, err := http.NewRequest("GET", fmt.Sprintf("%v?symbols=%v&range=1d&interval=1d", sparkURL, strings.Join(symbolList, ",")), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", "Mozilla/5.0")
+
+	res, err := yf.HTTP.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to get quotes: status %v", res.StatusCode)
+	}
+
+	type meta struct {
+		Currency           string   `json:"currency"`
+		RegularMarketPrice *float64 `json:"regularMarketPrice"`
+		ChartPreviousClose float64  `json:"chartPreviousClose"`
+		PreviousClose      float64  `json:"previousClose"`
+		RegularMarketTime  int64    `json:"regularMarketTime"`
+		RegularMarketVol   int64    `json:"regularMarketVolume"`
+	}
+	type dto struct {
+		Spark struct {
+			Result []struct {
+				Symbol   string `json:"symbol"`
+				Response []struct {
+					Meta meta `json:"meta"`
+				} `json:"response"`
+			} `json:"result"`
+		} `json:"spark"`
+	}
+
+	var d dto
+	if err := json.Unmarshal(body, &d); err != nil {
+		return nil, fmt.Errorf("unexpected response: %w", err)
+	}
+
+	quotes := map[string]*Quote{}
+	for _, result := range d.Spark.Result {
+		ticker, ok := symbols[result.Symbol]
+		if !ok || len(result.Response) == 0 || result.Response[0].Meta.RegularMarketPrice == nil {
+			continue
+		}
+
+		m := result.Response[0].Meta
+		quote := &Quote{
+			Currency: m.Currency,
+			Price:    *m.RegularMarketPrice,
+			Volume:   m.RegularMarketVol,
+			AsOf:     time.Unix(m.RegularMarketTime, 0),
+		}
+		previousClose := m.PreviousClose
+		if previousClose == 0 {
+			previousClose = m.ChartPreviousClose
+		}
+		if previousClose != 0 {
+			quote.Change = (quote.Price - previousClose) / previousClose * 100
+		}
+
+		quotes[ticker] = quote
+	}
+
+	return quotes, nil
+}
@@ -1,22 +1,61 @@
 package models
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"time"
+
 	"gorm.io/gorm"
 )
 
+// ACCESS_TOKEN_LIFETIME is how long an access token stays valid after it was
+// last used. Tokens in use are renewed, so only idle sessions expire.
+const ACCESS_TOKEN_LIFETIME = 30 * 24 * time.Hour
+
+// ACCESS_TOKEN_RENEWAL_INTERVAL is how often the use of a token is recorded, so
+// that not every request writes to the database.
+const ACCESS_TOKEN_RENEWAL_INTERVAL = time.Hour
+
+// ACCESS_TOKEN_PREFIX_LENGTH is the number of characters of a token kept in the
+// clear.
+const ACCESS_TOKEN_PREFIX_LENGTH = 8
+
 type AccessToken struct {
 	Generic
 
 	UserID uint `gorm:"index;not null" json:"user_id"`
 	User   User `json:"user"`
 
-	Token string `gorm:"unique_index" json:"token"`
+	// Tokens are stored as their SHA-256 hash, so that the database does not
+	// hold live sessions. The first characters of a token are kept to tell
+	// tokens apart for support.
+	TokenHash   string `gorm:"uniqueIndex" json:"-"`
+	TokenPrefix string `gorm:"index" json:"token_prefix"`
+	// Token is only set on a token just created, to hand it to the client.
+	Token string `gorm:"-" json:"token,omitempty"`
+	// Tokens created before they were hashed are stored in plain text until
+	// they are next used.
+	LegacyToken string `gorm:"column:token" json:"-"`
+	// The token is valid until ExpiresAt. It was last used to authorize a
+	// request around LastUsedAt.
+	ExpiresAt  time.Time `gorm:"index" json:"expires_at"`
+	LastUsedAt time.Time `json:"last_used_at"`
+	// Client that signed in, to tell sessions apart.
+	UserAgent string `json:"-"`
+	IPAddress string `json:"-"`
 }
 
-func CreateAccessToken(db *gorm.DB, userID uint, token string) (*AccessToken, error) {
+func CreateAccessToken(db *gorm.DB, userID uint, token, userAgent, ipAddress string) (*AccessToken, error) {
+	now := time.Now()
 	accessToken := &AccessToken{
-		UserID: userID,
-		Token:  token,
+		UserID:      userID,
+		TokenHash:   HashAccessToken(token),
+		TokenPrefix: accessTokenPrefix(token),
+		ExpiresAt:   now.Add(ACCESS_TOKEN_LIFETIME),
+		LastUsedAt:  now,
+		UserAgent:   userAgent,
+		IPAddress:   ipAddress,
 	}
 
 	if err := db.Create(accessToken).Error; err != nil {
@@ -32,5 +71,135 @@ func CreateAccessToken(db *gorm.DB, userID uint, token string) (*AccessToken, er
 		return nil, err
 	}
 
+	accessToken.Token = token
 	return accessToken, nil
 }
+
+// HashAccessToken returns the hex-encoded SHA-256 hash a token is stored as.
+func HashAccessToken(token string) string {
+	hash := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(hash[:])
+}
+
+func accessTokenPrefix(token string) string {
+	if len(token) < ACCESS_TOKEN_PREFIX_LENGTH {
+		return token
+	}
+
+	return token[:ACCESS_TOKEN_PREFIX_LENGTH]
+}
+
+func GetAccessToken(db *gorm.DB, accessTokenID uint) (*AccessToken, error) {
+	var accessToken AccessToken
+	err := db.Where("id = ?", accessTokenID).First(&accessToken).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+
+		return nil, err
+	}
+
+	return &accessToken, nil
+}
+
+// GetValidAccessToken returns the access token with the token value, or nil if
+// there is none or it expired. A token still stored in plain text is hashed.
+func GetValidAccessToken(db *gorm.DB, token string) (*AccessToken, error) {
+	var accessToken AccessToken
+	err := db.Where("token_hash = ? AND expires_at > ?", HashAccessToken(token), time.Now()).First(&accessToken).Error
+	if err == nil {
+		return &accessToken, nil
+	} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+
+	err = db.Where("token = ? AND COALESCE(token_hash, '') = '' AND expires_at > ?", token, time.Now()).First(&accessToken).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+
+		return nil, err
+	}
+
+	if err := hashLegacyAccessToken(db, &accessToken); err != nil {
+		return nil, err
+	}
+
+	return &accessToken, nil
+}
+
+// hashLegacyAccessToken replaces a token stored in plain text with its hash.
+func hashLegacyAccessToken(db *gorm.DB, accessToken *AccessToken) error {
+	accessToken.TokenHash = HashAccessToken(accessToken.LegacyToken)
+	accessToken.TokenPrefix = accessTokenPrefix(accessToken.LegacyToken)
+	accessToken.LegacyToken = ""
+
+	return db.Model(accessToken).Updates(map[string]interface{}{
+		"token_hash":   accessToken.TokenHash,
+		"token_prefix": accessToken.TokenPrefix,
+		"token":        "",
+	}).Error
+}
+
+// RenewAccessToken records the use of a token and extends its expiry, if it
+// was not recorded in the past ACCESS_TOKEN_RENEWAL_INTERVAL.
+func RenewAccessToken(db *gorm.DB, accessToken *AccessToken) error {
+	now := time.Now()
+	if now.Sub(accessToken.LastUsedAt) < ACCESS_TOKEN_RENEWAL_INTERVAL {
+		return nil
+	}
+
+	accessToken.LastUsedAt = now
+	accessToken.ExpiresAt = now.Add(ACCESS_TOKEN_LIFETIME)
+	return db.Model(accessToken).Updates(map[string]interface{}{
+		"last_used_at": accessToken.LastUsedAt,
+		"expires_at":   accessToken.ExpiresAt,
+	}).Error
+}
+
+// GetUserAccessTokens returns the valid access tokens of a user, most recently
+// used first.
+func GetUserAccessTokens(db *gorm.DB, userID uint) ([]AccessToken, error) {
+	var accessTokens []AccessToken
+	err := db.Where("user_id = ? AND expires_at > ?", userID, time.Now()).
+		Order("last_used_at DESC, id DESC").
+		Find(&accessTokens).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return accessTokens, nil
+}
+
+// RevokeAccessToken deletes an access token of a user. It reports whether the
+// user had the token.
+func RevokeAccessToken(db *gorm.DB, userID, accessTokenID uint) (bool, error) {
+	result := db.Unscoped().Where("id = ? AND user_id = ?", accessTokenID, userID).Delete(&AccessToken{})
+	return result.RowsAffected > 0, result.Error
+}
+
+// RevokeOtherAccessTokens deletes the access tokens of a user except one, and
+// returns how many were deleted.
+func RevokeOtherAccessTokens(db *gorm.DB, userID, keepAccessTokenID uint) (int64, error) {
+	result := db.Unscoped().Where("user_id = ? AND id <> ?", userID, keepAccessTokenID).Delete(&AccessToken{})
+	return result.RowsAffected, result.Error
+}
+
+// DeleteExpiredAccessTokens deletes the tokens that expired, and any deleted
+// before revocation deleted them for good. It returns how many were deleted.
+func DeleteExpiredAccessTokens(db *gorm.DB) (int64, error) {
+	result := db.Unscoped().Where("expires_at <= ? OR deleted_at IS NOT NULL", time.Now()).Delete(&AccessToken{})
+	return result.RowsAffected, result.Error
+}
+
+// SetMissingAccessTokenExpiry gives tokens issued before tokens expired a full
+// lifetime from now, so that their sessions carry on.
+func SetMissingAccessTokenExpiry(db *gorm.DB) error {
+	now := time.Now()
+	return db.Model(&AccessToken{}).Where("expires_at IS NULL").Updates(map[string]interface{}{
+		"expires_at":   now.Add(ACCESS_TOKEN_LIFETIME),
+		"last_used_at": now,
+	}).Error
+}
@@ -0,0 +1,184 @@
+package models
+
+import (
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type AlertKind string
+
+const (
+	// FilingAlert fires when the company files a document of FilingKind, or
+	// of any kind if FilingKind is empty.
+	FilingAlert AlertKind = "filing"
+	// PriceAlert fires when the price crosses Threshold in Direction.
+	PriceAlert AlertKind = "price"
+	// ChangeAlert fires when the price changes by at least Threshold percent
+	// since the previous close, up or down.
+	ChangeAlert AlertKind = "change"
+)
+
+type AlertDirection string
+
+const (
+	Above AlertDirection = "above"
+	Below AlertDirection = "below"
+)
+
+type AlertChannel string
+
+const (
+	// InAppChannel delivers notifications to the user's inbox.
+	InAppChannel AlertChannel = "in_app"
+)
+
+// Alert is a condition on a company's filings or price a user wants to be
+// notified of.
+type Alert struct {
+	Generic
+
+	UserID     uint           `gorm:"index;not null" json:"-"`
+	User       User           `json:"-"`
+	CompanyID  uint           `gorm:"index;not null" json:"company_id"`
+	Company    Company        `json:"-"`
+	Kind       AlertKind      `gorm:"not null" json:"kind"`
+	FilingKind SourceKind     `json:"filing_kind,omitempty"`
+	Direction  AlertDirection `json:"direction,omitempty"`
+	Threshold  float64        `json:"threshold,omitempty"`
+	Channel    AlertChannel   `gorm:"not null" json:"channel"`
+	// Price alerts fire once when their condition starts to hold, and again
+	// only after it stops holding. Triggered is whether it holds.
+	Triggered       bool       `gorm:"not null" json:"-"`
+	LastTriggeredAt *time.Time `json:"last_triggered_at"`
+}
+
+// Notification is a message to a user about an alert that fired.
+type Notification struct {
+	Generic
+
+	UserID    uint      `gorm:"index;not null" json:"-"`
+	User      User      `json:"-"`
+	AlertID   uint      `gorm:"uniqueIndex:idx_notifications_alert_document;not null" json:"alert_id"`
+	CompanyID uint      `gorm:"not null" json:"company_id"`
+	Kind      AlertKind `gorm:"not null" json:"kind"`
+	// Document filed, for filing alerts. A filing notifies of an alert at
+	// most once.
+	DocumentID *uint      `gorm:"uniqueIndex:idx_notifications_alert_document" json:"document_id"`
+	Text       string     `gorm:"not null" json:"text"`
+	ReadAt     *time.Time `json:"read_at"`
+}
+
+func CreateAlert(db *gorm.DB, alert *Alert) error {
+	return db.Create(alert).Error
+}
+
+func GetUserAlerts(db *gorm.DB, userID uint) ([]Alert, error) {
+	var alerts []Alert
+	err := db.Where("user_id = ?", userID).Order("id ASC").Find(&alerts).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return alerts, nil
+}
+
+func CountUserAlerts(db *gorm.DB, userID uint) (int64, error) {
+	var count int64
+	err := db.Model(&Alert{}).Where("user_id = ?", userID).Count(&count).Error
+	return count, err
+}
+
+// DeleteUserAlert deletes an alert of a user. It reports whether the user had
+// the alert.
+func DeleteUserAlert(db *gorm.DB, userID, alertID uint) (bool, error) {
+	result := db.Where("id = ? AND user_id = ?", alertID, userID).Delete(&Alert{})
+	return result.RowsAffected > 0, result.Error
+}
+
+// GetCompanyAlertsOfKinds returns the alerts of the kinds on a company.
+func GetCompanyAlertsOfKinds(db *gorm.DB, companyID uint, kinds []AlertKind) ([]Alert, error) {
+	var alerts []Alert
+	err := db.Where("company_id = ? AND kind IN ?", companyID, kinds).Find(&alerts).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return alerts, nil
+}
+
+// SetAlertTriggered records whether the condition of an alert holds, and when
+// it fired if it does.
+func SetAlertTriggered(db *gorm.DB, alert *Alert, triggered bool, at time.Time) error {
+	updates := map[string]interface{}{"triggered": triggered}
+	if triggered {
+		updates["last_triggered_at"] = at
+	}
+
+	return db.Model(alert).Updates(updates).Error
+}
+
+// SetAlertLastTriggeredAt records when an alert fired.
+func SetAlertLastTriggeredAt(db *gorm.DB, alert *Alert, at time.Time) error {
+	return db.Model(alert).Update("last_triggered_at", at).Error
+}
+
+// CreateNotification creates a notification for an alert. A notification of
+// the same alert and document that already exists is left as is.
+func CreateNotification(db *gorm.DB, notification *Notification) error {
+	return db.Clauses(clause.OnConflict{DoNothing: true}).Create(notification).Error
+}
+
+// GetUserNotificationsInverseChronological returns the notifications of a user,
+// newest first. If unread is set, only unread notifications are returned.
+func GetUserNotificationsInverseChronological(db *gorm.DB, userID uint, unread bool, offset, limit int) ([]Notification, error) {
+	query := db.Where("user_id = ?", userID)
+	if unread {
+		query = query.Where("read_at IS NULL")
+	}
+
+	var notifications []Notification
+	err := query.Order("created_at DESC, id DESC").Offset(offset).Limit(limit).Find(&notifications).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return notifications, nil
+}
+
+func CountUnreadNotifications(db *gorm.DB, userID uint) (int64, error) {
+	var count int64
+	err := db.Model(&Notification{}).Where("user_id = ? AND read_at IS NULL", userID).Count(&count).Error
+	return count, err
+}
+
+// MarkNotificationRead marks a notification of a user read, returning nil if the
+// user has no such notification.
+func MarkNotificationRead(db *gorm.DB, userID, notificationID uint) (*Notification, error) {
+	var notification Notification
+	err := db.Where("id = ? AND user_id = ?", notificationID, userID).First(&notification).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+
+		return nil, err
+	}
+
+	if notification.ReadAt == nil {
+		now := time.Now()
+		notification.ReadAt = &now
+		if err := db.Model(&notification).Update("read_at", now).Error; err != nil {
+			return nil, err
+		}
+	}
+
+	return &notification, nil
+}
+
+// MarkAllNotificationsRead marks all notifications of a user read.
+func MarkAllNotificationsRead(db *gorm.DB, userID uint) error {
+	return db.Model(&Notification{}).Where("user_id = ? AND read_at IS NULL", userID).Update("read_at", time.Now()).Error
+}
@@ -0,0 +1,204 @@
+package models
+
+import (
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// API_KEY_PREFIX starts every API key, so that keys are recognizable in
+// scripts and secret scanners.
+const API_KEY_PREFIX = "cofin_"
+
+// API_KEY_USE_INTERVAL is how often the use of a key is recorded.
+const API_KEY_USE_INTERVAL = time.Hour
+
+type APIScope string
+
+const (
+	// ScopeUserRead reads the user's profile.
+	ScopeUserRead APIScope = "user:read"
+	// ScopeWatchlistsRead reads the user's watchlists of companies.
+	ScopeWatchlistsRead APIScope = "watchlists:read"
+	// ScopeWatchlistsWrite changes the user's watchlists of companies.
+	ScopeWatchlistsWrite APIScope = "watchlists:write"
+	// ScopeAlertsRead reads the user's alerts and notifications.
+	ScopeAlertsRead APIScope = "alerts:read"
+	// ScopeAlertsWrite changes the user's alerts and marks notifications
+	// read.
+	ScopeAlertsWrite APIScope = "alerts:write"
+	// ScopeConversationsRead reads the user's conversations.
+	ScopeConversationsRead APIScope = "conversations:read"
+	// ScopeConversationsWrite sends messages in conversations, which counts
+	// against the user's message allowance.
+	ScopeConversationsWrite APIScope = "conversations:write"
+)
+
+// APIScopes are all scopes an API key can be granted.
+var APIScopes = []APIScope{
+	ScopeUserRead,
+	ScopeWatchlistsRead,
+	ScopeWatchlistsWrite,
+	ScopeAlertsRead,
+	ScopeAlertsWrite,
+	ScopeConversationsRead,
+	ScopeConversationsWrite,
+}
+
+func IsAPIScope(scope APIScope) bool {
+	for _, s := range APIScopes {
+		if s == scope {
+			return true
+		}
+	}
+
+	return false
+}
+
+// APIScopeList is a list of scopes, stored space-separated.
+type APIScopeList []APIScope
+
+func (l APIScopeList) Has(scope APIScope) bool {
+	for _, s := range l {
+		if s == scope {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (l *APIScopeList) Scan(value interface{}) error {
+	var s string
+	switch v := value.(type) {
+	case string:
+		s = v
+	case []byte:
+		s = string(v)
+	case nil:
+	default:
+		return fmt.Errorf("failed to scan scopes: %v", value)
+	}
+
+	*l = nil
+	for _, scope := range strings.Fields(s) {
+		*l = append(*l, APIScope(scope))
+	}
+	return nil
+}
+
+func (l APIScopeList) Value() (driver.Value, error) {
+	scopes := make([]string, len(l))
+	for i, scope := range l {
+		scopes[i] = string(scope)
+	}
+	return strings.Join(scopes, " "), nil
+}
+
+// APIKey lets a user's scripts call the API with a subset of the user's
+// permissions. Like access tokens, keys are stored as their SHA-256 hash.
+type APIKey struct {
+	Generic
+
+	UserID    uint         `gorm:"index;not null" json:"-"`
+	User      User         `json:"-"`
+	Name      string       `gorm:"not null" json:"name"`
+	KeyHash   string       `gorm:"uniqueIndex;not null" json:"-"`
+	KeyPrefix string       `gorm:"not null" json:"key_prefix"`
+	Scopes    APIScopeList `gorm:"type:text;not null" json:"scopes"`
+	// Key is only set on a key just created, to hand it to the user.
+	Key string `gorm:"-" json:"key,omitempty"`
+	// Keys without an expiry are valid until revoked.
+	ExpiresAt  *time.Time `json:"expires_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+}
+
+// CreateAPIKey creates an API key of a user from the key value.
+func CreateAPIKey(db *gorm.DB, userID uint, name, key string, scopes []APIScope, expiresAt *time.Time) (*APIKey, error) {
+	apiKey := &APIKey{
+		UserID:    userID,
+		Name:      name,
+		KeyHash:   HashAccessToken(key),
+		KeyPrefix: apiKeyPrefix(key),
+		Scopes:    scopes,
+		ExpiresAt: expiresAt,
+	}
+
+	if err := db.Omit("User").Create(apiKey).Error; err != nil {
+		return nil, err
+	}
+
+	apiKey.Key = key
+	return apiKey, nil
+}
+
+// apiKeyPrefix returns the start of a key kept in the clear, past the prefix
+// all keys share.
+func apiKeyPrefix(key string) string {
+	return accessTokenPrefix(strings.TrimPrefix(key, API_KEY_PREFIX))
+}
+
+// GetValidAPIKey returns the API key with the key value, or nil if there is
+// none or it expired.
+func GetValidAPIKey(db *gorm.DB, key string) (*APIKey, error) {
+	var apiKey APIKey
+	err := db.Where("key_hash = ? AND (expires_at IS NULL OR expires_at > ?)", HashAccessToken(key), time.Now()).
+		First(&apiKey).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+
+		return nil, err
+	}
+
+	return &apiKey, nil
+}
+
+// RecordAPIKeyUse records the use of a key, if it was not recorded in the past
+// API_KEY_USE_INTERVAL.
+func RecordAPIKeyUse(db *gorm.DB, apiKey *APIKey) error {
+	now := time.Now()
+	if apiKey.LastUsedAt != nil && now.Sub(*apiKey.LastUsedAt) < API_KEY_USE_INTERVAL {
+		return nil
+	}
+
+	apiKey.LastUsedAt = &now
+	return db.Model(apiKey).Update("last_used_at", now).Error
+}
+
+// GetUserAPIKeys returns the API keys of a user, including expired ones, in
+// order of creation.
+func GetUserAPIKeys(db *gorm.DB, userID uint) ([]APIKey, error) {
+	var apiKeys []APIKey
+	err := db.Where("user_id = ?", userID).Order("id ASC").Find(&apiKeys).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return apiKeys, nil
+}
+
+func CountUserAPIKeys(db *gorm.DB, userID uint) (int64, error) {
+	var count int64
+	err := db.Model(&APIKey{}).Where("user_id = ?", userID).Count(&count).Error
+	return count, err
+}
+
+// RevokeAPIKey deletes an API key of a user. It reports whether the user had
+// the key.
+func RevokeAPIKey(db *gorm.DB, userID, apiKeyID uint) (bool, error) {
+	result := db.Unscoped().Where("id = ? AND user_id = ?", apiKeyID, userID).Delete(&APIKey{})
+	return result.RowsAffected > 0, result.Error
+}
+
+// DeleteExpiredAPIKeys deletes the keys that expired, and returns how many
+// were deleted.
+func DeleteExpiredAPIKeys(db *gorm.DB) (int64, error) {
+	result := db.Unscoped().Where("expires_at <= ? OR deleted_at IS NOT NULL", time.Now()).Delete(&APIKey{})
+	return result.RowsAffected, result.Error
+}
@@ -0,0 +1,30 @@
+package models
+
+import (
+	"gorm.io/gorm"
+)
+
+type AuditAction string
+
+const (
+	// DataExportedAction records that a user downloaded their data.
+	DataExportedAction AuditAction = "data_exported"
+	// AccountDeletedAction records that a user deleted their account.
+	AccountDeletedAction AuditAction = "account_deleted"
+)
+
+// AuditEntry records an action on a user's account. Entries outlive the
+// account, so they hold no personal data beyond the user's ID.
+type AuditEntry struct {
+	Generic
+
+	UserID uint        `gorm:"index;not null" json:"user_id"`
+	Action AuditAction `gorm:"not null" json:"action"`
+}
+
+func CreateAuditEntry(db *gorm.DB, userID uint, action AuditAction) error {
+	return db.Create(&AuditEntry{
+		UserID: userID,
+		Action: action,
+	}).Error
+}
@@ -1,6 +1,7 @@
 package models
 
 import (
+	"encoding/json"
 	"errors"
 	"strings"
 	"time"
@@ -13,18 +14,36 @@ type Company struct {
 
 	// Company name.
 	Name string `gorm:"not null" json:"name"`
-	// Ticker symbol of the company. It is unique.
+	// Primary ticker symbol of the company. It is unique. A company with
+	// several share classes has a security for each of their tickers.
 	Ticker string `gorm:"unique_index" json:"ticker"`
 	// SEC company identifier. We can search by CIK, it is unique for US
-	// companies. Some (non-US companies) might not have it.
-	CIK string `gorm:"unique_index" json:"-"`
+	// companies. Some (non-US companies) might not have it. A company is the
+	// issuer of all securities listed under its CIK. It is stored without
+	// leading zeros.
+	CIK string `gorm:"uniqueIndex:idx_companies_cik,where:cik <> '' AND deleted_at IS NULL" json:"-"`
+	// The company this company was merged into, for deleted companies that
+	// duplicated its CIK. Chunks of their documents stay in their vector
+	// store namespace.
+	MergedIntoID *uint `gorm:"index" json:"-"`
+	// A company is inactive once all of its securities are delisted.
+	Active bool `gorm:"not null;default:true" json:"active"`
+	// Listed share classes of the company. Only loaded where noted.
+	Securities []Security `json:"securities,omitempty"`
 	// Last time we fetched the company's documents.
 	LastFetchedAt time.Time `json:"-"`
 
+	CompanyProfile
+
 	Currency    string  `json:"currency"`
 	Price       float64 `json:"price"`
 	Change      float64 `json:"change"`
 	TotalVolume float64 `json:"total_volume"`
+	// Market data provider the price came from, and the time of the quote.
+	PriceSource string     `json:"price_source"`
+	PriceAsOf   *time.Time `json:"price_as_of"`
+	// Valuation metrics at the current price. Only loaded where noted.
+	Valuation *Valuation `json:"valuation,omitempty"`
 }
 
 func GetCompanyByID(db *gorm.DB, companyID uint) (*Company, error) {
@@ -41,12 +60,98 @@ func GetCompanyByID(db *gorm.DB, companyID uint) (*Company, error) {
 	return &company, nil
 }
 
-// Get company by ticker.
+// QUOTE_UPDATES_CHANNEL is the Postgres notification channel on which quote
+// updates are published, so that every API server can push them to clients.
+const QUOTE_UPDATES_CHANNEL = "quote_updates"
+
+// QuoteUpdate is the payload of a notification of a company's new quote.
+type QuoteUpdate struct {
+	CompanyID   uint       `json:"company_id"`
+	Ticker      string     `json:"ticker"`
+	Currency    string     `json:"currency"`
+	Price       float64    `json:"price"`
+	Change      float64    `json:"change"`
+	TotalVolume float64    `json:"total_volume"`
+	PriceAsOf   *time.Time `json:"price_as_of"`
+}
+
+// NewQuoteUpdate returns the current quote of a company as an update.
+func NewQuoteUpdate(company *Company) QuoteUpdate {
+	return QuoteUpdate{
+		CompanyID:   company.ID,
+		Ticker:      company.Ticker,
+		Currency:    company.Currency,
+		Price:       company.Price,
+		Change:      company.Change,
+		TotalVolume: company.TotalVolume,
+		PriceAsOf:   company.PriceAsOf,
+	}
+}
+
+// UpdateCompanyQuote stores the latest quote set on the company and notifies
+// listeners on QUOTE_UPDATES_CHANNEL. The notification is delivered when the
+// update commits.
+func UpdateCompanyQuote(db *gorm.DB, company *Company) error {
+	payload, err := json.Marshal(NewQuoteUpdate(company))
+	if err != nil {
+		return err
+	}
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Model(company).
+			Select("currency", "price", "change", "total_volume", "price_source", "price_as_of").
+			Updates(company).Error
+		if err != nil {
+			return err
+		}
+
+		return tx.Exec("SELECT pg_notify(?, ?)", QUOTE_UPDATES_CHANNEL, string(payload)).Error
+	})
+}
+
+// Get company by ticker. Tickers of any of the company's securities match, as
+// do tickers the company traded under before a ticker change. Current tickers
+// take precedence over former ones, as tickers are reused.
 func GetCompanyByTicker(db *gorm.DB, ticker string) (*Company, error) {
 	ticker = strings.ToUpper(ticker)
 
 	var company Company
 	err := db.Where("ticker = ?", strings.ToUpper(ticker)).First(&company).Error
+	if err == nil {
+		return &company, nil
+	} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+
+	err = db.Joins("INNER JOIN securities ON securities.company_id = companies.id AND securities.deleted_at IS NULL").
+		Where("securities.ticker = ?", ticker).
+		Order("securities.active DESC, securities.updated_at DESC").
+		First(&company).Error
+	if err == nil {
+		return &company, nil
+	} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+
+	companyID, err := getCompanyIDByFormerTicker(db, ticker)
+	if err != nil || companyID == 0 {
+		return nil, err
+	}
+
+	return GetCompanyByID(db, companyID)
+}
+
+// Get company by CIK. Leading zeros are ignored, as providers differ in
+// whether they pad CIKs. CIKs are stored without them, so the lookup can use
+// the index on CIKs.
+func GetCompanyByCIK(db *gorm.DB, cik string) (*Company, error) {
+	cik = strings.TrimLeft(cik, "0")
+	if cik == "" {
+		return nil, nil
+	}
+
+	var company Company
+	err := db.Where("cik = ?", cik).First(&company).Error
 	if err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
 			return nil, nil
@@ -58,32 +163,97 @@ func GetCompanyByTicker(db *gorm.DB, ticker string) (*Company, error) {
 	return &company, nil
 }
 
+// CompanyProfile classifies a company. It is taken from exchange listings and
+// kept current on each fetch. Fields the listing does not provide are empty.
+type CompanyProfile struct {
+	Exchange     string `gorm:"index" json:"exchange"`
+	Category     string `json:"category"`
+	Sector       string `gorm:"index" json:"sector"`
+	Industry     string `gorm:"index" json:"industry"`
+	SIC          string `json:"sic"`
+	SICSector    string `json:"sic_sector"`
+	SICIndustry  string `json:"sic_industry"`
+	FAMASector   string `json:"fama_sector"`
+	FAMAIndustry string `json:"fama_industry"`
+	Location     string `gorm:"index" json:"location"`
+}
+
 // Create company.
-func CreateCompany(db *gorm.DB, name, ticker, cik string, lastFetchedAt time.Time) (*Company, error) {
+func CreateCompany(db *gorm.DB, name, ticker, cik string, lastFetchedAt time.Time, profile CompanyProfile) (*Company, error) {
 	var company = Company{
-		Name:          name,
-		Ticker:        strings.ToUpper(ticker),
-		CIK:           cik,
-		LastFetchedAt: lastFetchedAt,
+		Name:           name,
+		Ticker:         strings.ToUpper(ticker),
+		CIK:            strings.TrimLeft(cik, "0"),
+		LastFetchedAt:  lastFetchedAt,
+		CompanyProfile: profile,
+		Active:         true,
 	}
 
 	if err := db.Create(&company).Error; err != nil {
 		return nil, err
 	}
 
+	// Every company has a security for its primary ticker.
+	if _, err := CreateSecurity(db, &company, ListedSecurity{Ticker: ticker, Exchange: profile.Exchange}); err != nil {
+		return nil, err
+	}
+
 	return &company, nil
 }
 
-func FindCompanies(db *gorm.DB, query string, offset, limit int) ([]Company, error) {
-	var companies []Company
-	var err error
-	if len(query) > 0 {
-		q := "%" + query + "%"
-		err = db.Model(&Company{}).Select("companies.*").Joins("INNER JOIN documents ON documents.company_id = companies.id").Group("companies.id").Having("COUNT(documents.id) > 0 AND companies.last_fetched_at > ? AND (name ILIKE ? OR ticker ILIKE ?)", time.Time{}, q, q).Offset(offset).Limit(limit).Order("total_volume DESC").Find(&companies).Error
-	} else {
-		err = db.Model(&Company{}).Select("companies.*").Joins("INNER JOIN documents ON documents.company_id = companies.id").Group("companies.id").Having("COUNT(documents.id) > 0 AND companies.last_fetched_at > ?", time.Time{}).Offset(offset).Limit(limit).Order("total_volume DESC").Find(&companies).Error
+// UpdateCompanyProfile updates the company's profile. Empty fields of the
+// profile are left unchanged, so that a listing with less information does not
+// erase what we know.
+func UpdateCompanyProfile(db *gorm.DB, company *Company, profile CompanyProfile) error {
+	return db.Model(company).Updates(Company{CompanyProfile: profile}).Error
+}
+
+// CompanyFilter narrows down companies. Empty fields match all companies.
+type CompanyFilter struct {
+	// Matches names and tickers containing the query.
+	Query    string
+	Sector   string
+	Industry string
+	Exchange string
+	Location string
+}
+
+// The profile fields companies can be filtered and faceted by.
+var CompanyFacetFields = []string{"sector", "industry", "exchange", "location"}
+
+// FacetCount is the number of companies with a value of a profile field.
+type FacetCount struct {
+	Value string `json:"value"`
+	Count int    `json:"count"`
+}
+
+// filterCompanies applies the filter to a query of companies, leaving out the
+// facet field skip. Only companies with documents are included.
+func filterCompanies(db *gorm.DB, filter CompanyFilter, skip string) *gorm.DB {
+	query := db.Model(&Company{}).Where("EXISTS (SELECT 1 FROM documents WHERE documents.company_id = companies.id) AND companies.last_fetched_at > ?", time.Time{})
+	if len(filter.Query) > 0 {
+		q := "%" + filter.Query + "%"
+		query = query.Where("name ILIKE ? OR ticker ILIKE ?", q, q)
 	}
 
+	for field, value := range map[string]string{
+		"sector":   filter.Sector,
+		"industry": filter.Industry,
+		"exchange": filter.Exchange,
+		"location": filter.Location,
+	} {
+		if value != "" && field != skip {
+			query = query.Where("LOWER(companies."+field+") = LOWER(?)", value)
+		}
+	}
+
+	return query
+}
+
+func FindCompanies(db *gorm.DB, filter CompanyFilter, offset, limit int) ([]Company, error) {
+	var companies []Company
+	err := filterCompanies(db, filter, "").Offset(offset).Limit(limit).Order("total_volume DESC").Find(&companies).Error
+
 	if err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
 			return nil, nil
@@ -94,3 +264,26 @@ func FindCompanies(db *gorm.DB, query string, offset, limit int) ([]Company, err
 
 	return companies, nil
 }
+
+// GetCompanyFacets counts the companies matching the filter by each facet
+// field's values, most common first. A field's own filter is left out of its
+// counts, so that the counts show what selecting another value would match.
+func GetCompanyFacets(db *gorm.DB, filter CompanyFilter) (map[string][]FacetCount, error) {
+	facets := make(map[string][]FacetCount, len(CompanyFacetFields))
+	for _, field := range CompanyFacetFields {
+		var counts []FacetCount
+		err := filterCompanies(db, filter, field).
+			Select("companies." + field + " AS value, COUNT(*) AS count").
+			Where("companies." + field + " <> ''").
+			Group("companies." + field).
+			Order("count DESC, value ASC").
+			Scan(&counts).Error
+		if err != nil {
+			return nil, err
+		}
+
+		facets[field] = counts
+	}
+
+	return facets, nil
+}
@@ -0,0 +1,264 @@
+package models
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// CompanyMerge is a company merged into another company with the same CIK.
+type CompanyMerge struct {
+	FromID uint
+	IntoID uint
+	CIK    string
+	// Documents of the merged company that were deleted as copies of
+	// documents of the company merged into. Their chunks are left in the
+	// merged company's namespace for the caller to delete.
+	DuplicateDocumentIDs []uint
+}
+
+// Tables whose rows are moved to the company a company is merged into.
+var mergedCompanyTables = []string{
+	"ticker_changes",
+	"messages",
+	"alerts",
+	"notifications",
+	"documents",
+	"filing_ingestions",
+}
+
+// Tables whose rows are moved to the company a company is merged into, unless
+// the company already has a row with the same key columns. Rows that would be
+// duplicates are deleted.
+var mergedCompanyUniqueTables = []struct {
+	table string
+	key   []string
+}{
+	{"securities", []string{"ticker"}},
+	{"watchlist_entries", []string{"watchlist_id"}},
+	{"price_bars", []string{"date"}},
+	{"fundamentals", nil},
+	{"valuations", nil},
+}
+
+// MergeDuplicateCIKCompanies merges companies sharing a CIK into the earliest
+// of them. Companies used to be created per ticker, so the share classes of an
+// issuer could be separate companies. Their securities, documents, messages,
+// alerts and watchlist entries are moved to the company they are merged into,
+// and they are soft deleted. Share classes ingested the same filings, so
+// documents the company merged into already has are deleted instead. CIKs are
+// stored without leading zeros afterwards.
+//
+// It must run before the unique index on CIKs is migrated, which cannot be
+// created while there are duplicates.
+func MergeDuplicateCIKCompanies(db *gorm.DB) ([]CompanyMerge, error) {
+	migrator := db.Migrator()
+	if !migrator.HasTable(&Company{}) {
+		return nil, nil
+	}
+	if !migrator.HasColumn(&Company{}, "MergedIntoID") {
+		if err := migrator.AddColumn(&Company{}, "MergedIntoID"); err != nil {
+			return nil, err
+		}
+	}
+
+	var companies []Company
+	err := db.Select("id", "cik").Where("cik <> ''").Order("id ASC").Find(&companies).Error
+	if err != nil {
+		return nil, err
+	}
+
+	var merges []CompanyMerge
+	earliest := map[string]uint{}
+	for _, company := range companies {
+		cik := strings.TrimLeft(company.CIK, "0")
+		intoID, ok := earliest[cik]
+		if !ok {
+			earliest[cik] = company.ID
+			continue
+		}
+
+		merge := CompanyMerge{FromID: company.ID, IntoID: intoID, CIK: cik}
+		if err := db.Transaction(func(tx *gorm.DB) error {
+			return mergeCompany(tx, &merge)
+		}); err != nil {
+			return merges, fmt.Errorf("failed to merge company %v into %v: %w", merge.FromID, merge.IntoID, err)
+		}
+		merges = append(merges, merge)
+	}
+
+	err = db.Model(&Company{}).Where("cik LIKE '0%'").Update("cik", gorm.Expr("LTRIM(cik, '0')")).Error
+	if err != nil {
+		return merges, err
+	}
+
+	return merges, nil
+}
+
+// mergeCompany moves the rows of a company to the company it is merged into and
+// soft deletes it.
+func mergeCompany(tx *gorm.DB, merge *CompanyMerge) error {
+	migrator := tx.Migrator()
+
+	if err := deleteDuplicateDocuments(tx, merge); err != nil {
+		return err
+	}
+
+	// Ticker changes follow the security they are of, when the company
+	// merged into has a security with the same ticker.
+	if migrator.HasTable("ticker_changes") {
+		err := tx.Exec(`UPDATE ticker_changes SET security_id = kept.id
+			FROM securities AS merged, securities AS kept
+			WHERE ticker_changes.security_id = merged.id AND merged.company_id = ?
+			AND kept.company_id = ? AND kept.ticker = merged.ticker`, merge.FromID, merge.IntoID).Error
+		if err != nil {
+			return err
+		}
+	}
+
+	// Deleted rows are moved as well, as they count towards unique indexes.
+	for _, t := range mergedCompanyUniqueTables {
+		if !migrator.HasTable(t.table) {
+			continue
+		}
+
+		conditions := []string{"kept.company_id = ?"}
+		for _, column := range t.key {
+			conditions = append(conditions, fmt.Sprintf("kept.%v = %v.%v", column, t.table, column))
+		}
+		err := tx.Exec(fmt.Sprintf(
+			"DELETE FROM %v WHERE company_id = ? AND EXISTS (SELECT 1 FROM %v AS kept WHERE %v)",
+			t.table, t.table, strings.Join(conditions, " AND "),
+		), merge.FromID, merge.IntoID).Error
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, t := range mergedCompanyUniqueTables {
+		if err := moveCompanyRows(tx, t.table, *merge); err != nil {
+			return err
+		}
+	}
+	for _, table := range mergedCompanyTables {
+		if err := moveCompanyRows(tx, table, *merge); err != nil {
+			return err
+		}
+	}
+
+	// Companies merged earlier into the merged company, whose chunks are
+	// still in their namespaces, now belong to the company merged into.
+	err := tx.Unscoped().Model(&Company{}).Where("merged_into_id = ?", merge.FromID).Update("merged_into_id", merge.IntoID).Error
+	if err != nil {
+		return err
+	}
+
+	return tx.Model(&Company{}).Where("id = ?", merge.FromID).Updates(map[string]interface{}{
+		"merged_into_id": merge.IntoID,
+		"deleted_at":     time.Now(),
+	}).Error
+}
+
+// deleteDuplicateDocuments deletes the documents of a merged company that the
+// company it is merged into also has: documents with the same accession
+// number, or, for copies stored before accession numbers were recorded, of the
+// same kind filed at the same time from the same URL. Their sections are
+// deleted with them, and references to them are moved to the documents kept.
+func deleteDuplicateDocuments(tx *gorm.DB, merge *CompanyMerge) error {
+	migrator := tx.Migrator()
+	if !migrator.HasTable(&Document{}) {
+		return nil
+	}
+
+	conditions := []string{"kept.origin_url = merged.origin_url AND kept.kind = merged.kind AND kept.filed_at = merged.filed_at"}
+	if migrator.HasColumn(&Document{}, "AccessionNo") {
+		conditions = append(conditions, "kept.accession_no = merged.accession_no")
+	}
+
+	var duplicates []struct {
+		FromID uint
+		IntoID uint
+	}
+	err := tx.Raw(fmt.Sprintf(
+		`SELECT merged.id AS from_id, MIN(kept.id) AS into_id FROM documents AS merged
+		JOIN documents AS kept ON kept.company_id = ? AND (%v)
+		WHERE merged.company_id = ? GROUP BY merged.id ORDER BY merged.id`,
+		strings.Join(conditions, " OR "),
+	), merge.IntoID, merge.FromID).Scan(&duplicates).Error
+	if err != nil {
+		return err
+	}
+
+	// Columns referencing documents, moved to the document kept.
+	references := []struct {
+		model  interface{}
+		table  string
+		column string
+	}{
+		{&Document{}, "documents", "amends_id"},
+		{&Document{}, "documents", "superseded_by_id"},
+		{&FilingIngestion{}, "filing_ingestions", "document_id"},
+		{&Notification{}, "notifications", "document_id"},
+	}
+
+	for _, duplicate := range duplicates {
+		// An alert notifies of a filing once, so a notification of the copy
+		// is dropped if there is one of the document kept.
+		if migrator.HasColumn(&Notification{}, "document_id") {
+			err := tx.Exec(`DELETE FROM notifications WHERE document_id = ? AND EXISTS
+				(SELECT 1 FROM notifications AS kept WHERE kept.alert_id = notifications.alert_id AND kept.document_id = ?)`,
+				duplicate.FromID, duplicate.IntoID).Error
+			if err != nil {
+				return err
+			}
+		}
+
+		for _, r := range references {
+			if !migrator.HasColumn(r.model, r.column) {
+				continue
+			}
+
+			err := tx.Exec(fmt.Sprintf("UPDATE %v SET %v = ? WHERE %v = ?", r.table, r.column, r.column), duplicate.IntoID, duplicate.FromID).Error
+			if err != nil {
+				return err
+			}
+		}
+
+		if migrator.HasTable(&DocumentSection{}) {
+			if err := tx.Exec("DELETE FROM document_sections WHERE document_id = ?", duplicate.FromID).Error; err != nil {
+				return err
+			}
+		}
+
+		if err := tx.Exec("DELETE FROM documents WHERE id = ?", duplicate.FromID).Error; err != nil {
+			return err
+		}
+
+		merge.DuplicateDocumentIDs = append(merge.DuplicateDocumentIDs, duplicate.FromID)
+	}
+
+	return nil
+}
+
+// moveCompanyRows moves the rows of a table from a merged company to the
+// company it is merged into.
+func moveCompanyRows(tx *gorm.DB, table string, merge CompanyMerge) error {
+	if !tx.Migrator().HasTable(table) {
+		return nil
+	}
+
+	return tx.Exec(fmt.Sprintf("UPDATE %v SET company_id = ? WHERE company_id = ?", table), merge.IntoID, merge.FromID).Error
+}
+
+// GetMergedCompanyIDs returns the IDs of the companies merged into a company.
+func GetMergedCompanyIDs(db *gorm.DB, companyID uint) ([]uint, error) {
+	var ids []uint
+	err := db.Unscoped().Model(&Company{}).Where("merged_into_id = ?", companyID).Order("id ASC").Pluck("id", &ids).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return ids, nil
+}
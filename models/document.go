@@ -3,6 +3,7 @@ package models
 import (
 	"database/sql/driver"
 	"errors"
+	"strings"
 	"time"
 
 	"gorm.io/gorm"
@@ -16,6 +17,21 @@ type SourceKind string
 const (
 	Q10 SourceKind = "10-Q"
 	K10 SourceKind = "10-K"
+	// Amendments restate an earlier filing of the base kind, in full or in
+	// part. The amended document supersedes the original.
+	Q10A SourceKind = "10-Q/A"
+	K10A SourceKind = "10-K/A"
+	// Foreign private issuers file annual reports on 20-F, or 40-F if they are
+	// Canadian, and report material events and interim results on 6-K
+	// instead of filing 10-Qs.
+	F20  SourceKind = "20-F"
+	F40  SourceKind = "40-F"
+	K6   SourceKind = "6-K"
+	F20A SourceKind = "20-F/A"
+	F40A SourceKind = "40-F/A"
+	// Transcript is an earnings call transcript. Transcripts are not SEC
+	// filings and have no sections; they are indexed by speaker turn.
+	Transcript SourceKind = "TRANSCRIPT"
 )
 
 type Quarter uint8
@@ -40,6 +56,35 @@ func (st *SourceKind) String() string {
 	return string(*st)
 }
 
+// IsAmendment reports whether the kind is an amendment of another kind.
+func (st SourceKind) IsAmendment() bool {
+	return strings.HasSuffix(string(st), "/A")
+}
+
+// BaseKind returns the kind an amendment amends. Other kinds are returned
+// unchanged.
+func (st SourceKind) BaseKind() SourceKind {
+	return SourceKind(strings.TrimSuffix(string(st), "/A"))
+}
+
+// Kinds of annual reports, including their amendments.
+var AnnualKinds = []SourceKind{K10, F20, F40, K10A, F20A, F40A}
+
+// IsAnnual reports whether documents of the kind report on a fiscal year.
+func (st SourceKind) IsAnnual() bool {
+	switch st.BaseKind() {
+	case K10, F20, F40:
+		return true
+	default:
+		return false
+	}
+}
+
+// IsQuarterly reports whether documents of the kind report on a fiscal quarter.
+func (st SourceKind) IsQuarterly() bool {
+	return st.BaseKind() == Q10
+}
+
 // Document section (chapter).
 type Section string
 
@@ -77,6 +122,44 @@ const (
 	Q10MineSafety           Section = "part2item4"
 	Q10OtherInformation     Section = "part2item5"
 	Q10Exhibits             Section = "part2item6"
+
+	// 20-F sections. Items are qualified so that they do not collide with
+	// 10-K items of the same number.
+	F20IdentityOfDirectors           Section = "item1"
+	F20OfferStatistics               Section = "item2"
+	F20KeyInformation                Section = "item3"
+	F20InformationOnTheCompany       Section = "item4"
+	F20UnresolvedStaffComments       Section = "item4a"
+	F20OperatingAndFinancialReview   Section = "item5"
+	F20DirectorsAndEmployees         Section = "item6"
+	F20MajorShareholders             Section = "item7"
+	F20FinancialInformation          Section = "item8"
+	F20OfferAndListing               Section = "item9"
+	F20AdditionalInformation         Section = "item10"
+	F20MarketRisk                    Section = "item11"
+	F20OtherSecurities               Section = "item12"
+	F20Defaults                      Section = "item13"
+	F20MaterialModifications         Section = "item14"
+	F20ControlsAndProcedures         Section = "item15"
+	F20AuditCommitteeFinancialExpert Section = "item16a"
+	F20CodeOfEthics                  Section = "item16b"
+	F20PrincipalAccountantFees       Section = "item16c"
+	F20ListingStandardsExemptions    Section = "item16d"
+	F20PurchasesOfEquitySecurities   Section = "item16e"
+	F20ChangeInAccountant            Section = "item16f"
+	F20CorporateGovernance           Section = "item16g"
+	F20MineSafety                    Section = "item16h"
+	F20ForeignJurisdictions          Section = "item16i"
+	F20InsiderTradingPolicies        Section = "item16j"
+	F20Cybersecurity                 Section = "item16k"
+	F20FinancialStatements           Section = "item17"
+	F20FinancialStatementsIFRS       Section = "item18"
+	F20Exhibits                      Section = "item19"
+
+	// 40-Fs wrap the annual information form, MD&A and financial statements
+	// filed as exhibits, and 6-Ks have no items, so they are stored as a
+	// single section.
+	FullDocument Section = "document"
 )
 
 var (
@@ -115,8 +198,127 @@ var (
 		Q10OtherInformation,
 		Q10Exhibits,
 	}
+
+	F20Sections = []Section{
+		F20IdentityOfDirectors,
+		F20OfferStatistics,
+		F20KeyInformation,
+		F20InformationOnTheCompany,
+		F20UnresolvedStaffComments,
+		F20OperatingAndFinancialReview,
+		F20DirectorsAndEmployees,
+		F20MajorShareholders,
+		F20FinancialInformation,
+		F20OfferAndListing,
+		F20AdditionalInformation,
+		F20MarketRisk,
+		F20OtherSecurities,
+		F20Defaults,
+		F20MaterialModifications,
+		F20ControlsAndProcedures,
+		F20AuditCommitteeFinancialExpert,
+		F20CodeOfEthics,
+		F20PrincipalAccountantFees,
+		F20ListingStandardsExemptions,
+		F20PurchasesOfEquitySecurities,
+		F20ChangeInAccountant,
+		F20CorporateGovernance,
+		F20MineSafety,
+		F20ForeignJurisdictions,
+		F20InsiderTradingPolicies,
+		F20Cybersecurity,
+		F20FinancialStatements,
+		F20FinancialStatementsIFRS,
+		F20Exhibits,
+	}
 )
 
+// Human-readable section titles.
+var SectionTitles = map[Section]string{
+	K10Business:                         "Business",
+	K10RiskFactors:                      "Risk Factors",
+	K10UnresolvedStaffComments:          "Unresolved Staff Comments",
+	K10Properties:                       "Properties",
+	K10LegalProceedings:                 "Legal Proceedings",
+	K10MineSafetyDisclosures:            "Mine Safety Disclosures",
+	K10MarketForRegistrantsCommonEquity: "Market for Registrant's Common Equity, Related Stockholder Matters and Issuer Purchases of Equity Securities",
+	K10SelectedFinancialData:            "Selected Financial Data",
+	K10ManagementsDiscussion:            "Management's Discussion and Analysis of Financial Condition and Results of Operations",
+	K10QuantitativeAndQualitativeDisclosuresAboutMarketRisk: "Quantitative and Qualitative Disclosures About Market Risk",
+	K10FinancialStatementsAndSupplementaryData:              "Financial Statements and Supplementary Data",
+	K10ChangesInAndDisagreementsWithAccountants:             "Changes in and Disagreements with Accountants on Accounting and Financial Disclosure",
+	K10ControlsAndProcedures:                                "Controls and Procedures",
+	K10OtherInformation:                                     "Other Information",
+	K10DirectorsExecutiveOfficersAndCorporateGovernance:     "Directors, Executive Officers and Corporate Governance",
+	K10ExecutiveCompensation:                                "Executive Compensation",
+	K10SecurityOwnership:                                    "Security Ownership of Certain Beneficial Owners and Management and Related Stockholder Matters",
+	K10CertainRelationships:                                 "Certain Relationships and Related Transactions, and Director Independence",
+	K10PrincipalAccountantFeesAndServices:                   "Principal Accountant Fees and Services",
+
+	Q10FinancialStatements:  "Financial Statements",
+	Q10ManagementDiscussion: "Management's Discussion and Analysis of Financial Condition and Results of Operations",
+	Q10MarketRisk:           "Quantitative and Qualitative Disclosures About Market Risk",
+	Q10Controls:             "Controls and Procedures",
+	Q10LegalProceedings:     "Legal Proceedings",
+	Q10RiskFactors:          "Risk Factors",
+	Q10Unregistered:         "Unregistered Sales of Equity Securities and Use of Proceeds",
+	Q10Defaults:             "Defaults Upon Senior Securities",
+	Q10MineSafety:           "Mine Safety Disclosures",
+	Q10OtherInformation:     "Other Information",
+	Q10Exhibits:             "Exhibits",
+
+	F20IdentityOfDirectors:           "Identity of Directors, Senior Management and Advisers",
+	F20OfferStatistics:               "Offer Statistics and Expected Timetable",
+	F20KeyInformation:                "Key Information",
+	F20InformationOnTheCompany:       "Information on the Company",
+	F20UnresolvedStaffComments:       "Unresolved Staff Comments",
+	F20OperatingAndFinancialReview:   "Operating and Financial Review and Prospects",
+	F20DirectorsAndEmployees:         "Directors, Senior Management and Employees",
+	F20MajorShareholders:             "Major Shareholders and Related Party Transactions",
+	F20FinancialInformation:          "Financial Information",
+	F20OfferAndListing:               "The Offer and Listing",
+	F20AdditionalInformation:         "Additional Information",
+	F20MarketRisk:                    "Quantitative and Qualitative Disclosures About Market Risk",
+	F20OtherSecurities:               "Description of Securities Other than Equity Securities",
+	F20Defaults:                      "Defaults, Dividend Arrearages and Delinquencies",
+	F20MaterialModifications:         "Material Modifications to the Rights of Security Holders and Use of Proceeds",
+	F20ControlsAndProcedures:         "Controls and Procedures",
+	F20AuditCommitteeFinancialExpert: "Audit Committee Financial Expert",
+	F20CodeOfEthics:                  "Code of Ethics",
+	F20PrincipalAccountantFees:       "Principal Accountant Fees and Services",
+	F20ListingStandardsExemptions:    "Exemptions from the Listing Standards for Audit Committees",
+	F20PurchasesOfEquitySecurities:   "Purchases of Equity Securities by the Issuer and Affiliated Purchasers",
+	F20ChangeInAccountant:            "Change in Registrant's Certifying Accountant",
+	F20CorporateGovernance:           "Corporate Governance",
+	F20MineSafety:                    "Mine Safety Disclosure",
+	F20ForeignJurisdictions:          "Disclosure Regarding Foreign Jurisdictions that Prevent Inspections",
+	F20InsiderTradingPolicies:        "Insider Trading Policies",
+	F20Cybersecurity:                 "Cybersecurity",
+	F20FinancialStatements:           "Financial Statements",
+	F20FinancialStatementsIFRS:       "Financial Statements",
+	F20Exhibits:                      "Exhibits",
+
+	FullDocument: "Full Document",
+}
+
+// SectionsOfKind returns the sections a document of the kind is split into,
+// in the order they appear in the document. Amendments have the sections of
+// the kind they amend. Kinds without sections return nil.
+func SectionsOfKind(kind SourceKind) []Section {
+	switch kind.BaseKind() {
+	case K10:
+		return K10Sections
+	case Q10:
+		return Q10Sections
+	case F20:
+		return F20Sections
+	case F40, K6:
+		return []Section{FullDocument}
+	default:
+		return nil
+	}
+}
+
 // Documents are raw document inputs.
 type Document struct {
 	Generic
@@ -127,15 +329,58 @@ type Document struct {
 	Kind       SourceKind `gorm:"index;not null"`
 	OriginURL  string
 	RawContent string `json:"-"`
+	// End of the period the document reports on. Zero if unknown or not
+	// applicable.
+	PeriodOfReport time.Time
+	// The document this amendment amends, if the document is an amendment.
+	AmendsID *uint `gorm:"index"`
+	// The most recent amendment of this document, if there is one.
+	SupersededByID *uint `gorm:"index"`
+	// SEC accession number of the filing the document was created from. Nil
+	// for documents that are not SEC filings, such as transcripts.
+	AccessionNo *string `gorm:"uniqueIndex"`
+	// Whether the document was uploaded by an admin rather than fetched from
+	// a provider.
+	Uploaded bool `gorm:"not null;default:false"`
+}
+
+// IsSuperseded reports whether the document has been amended.
+func (d *Document) IsSuperseded() bool {
+	return d.SupersededByID != nil
+}
+
+// CreateDocument creates a document. accessionNo is empty for documents that
+// are not SEC filings.
+func CreateDocument(db *gorm.DB, company *Company, accessionNo string, filedAt time.Time, kind SourceKind, originURL, rawContent string, periodOfReport time.Time) (*Document, error) {
+	document := Document{
+		CompanyID:      company.ID,
+		FiledAt:        filedAt,
+		Kind:           kind,
+		OriginURL:      originURL,
+		RawContent:     rawContent,
+		PeriodOfReport: periodOfReport,
+	}
+	if accessionNo != "" {
+		document.AccessionNo = &accessionNo
+	}
+
+	if err := db.Create(&document).Error; err != nil {
+		return nil, err
+	}
+
+	return &document, nil
 }
 
-func CreateDocument(db *gorm.DB, company *Company, filedAt time.Time, kind SourceKind, originURL, rawContent string) (*Document, error) {
+// CreateTranscriptDocument creates a transcript document of a call held at
+// heldAt. uploaded is set for transcripts uploaded by an admin.
+func CreateTranscriptDocument(db *gorm.DB, company *Company, heldAt time.Time, originURL, rawContent string, uploaded bool) (*Document, error) {
 	document := Document{
 		CompanyID:  company.ID,
-		FiledAt:    filedAt,
-		Kind:       kind,
+		FiledAt:    heldAt,
+		Kind:       Transcript,
 		OriginURL:  originURL,
 		RawContent: rawContent,
+		Uploaded:   uploaded,
 	}
 
 	if err := db.Create(&document).Error; err != nil {
@@ -145,6 +390,39 @@ func CreateDocument(db *gorm.DB, company *Company, filedAt time.Time, kind Sourc
 	return &document, nil
 }
 
+// GetLatestFetchedTranscript returns the most recent transcript of the company
+// fetched from a provider, ignoring uploaded transcripts, or nil if there is
+// none.
+func GetLatestFetchedTranscript(db *gorm.DB, companyID uint) (*Document, error) {
+	var document Document
+	err := db.Omit("raw_content").Where("company_id = ? AND kind = ? AND NOT uploaded", companyID, Transcript).Order("filed_at DESC").First(&document).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+
+		return nil, err
+	}
+
+	return &document, nil
+}
+
+// GetTranscriptHeldAt returns the transcript of the company's call held at
+// heldAt, or nil if there is none.
+func GetTranscriptHeldAt(db *gorm.DB, companyID uint, heldAt time.Time) (*Document, error) {
+	var document Document
+	err := db.Omit("raw_content").Where("company_id = ? AND kind = ? AND filed_at = ?", companyID, Transcript, heldAt).First(&document).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+
+		return nil, err
+	}
+
+	return &document, nil
+}
+
 func GetCompanyDocumentsOfKindInverseChronological(db *gorm.DB, companyID uint, kind SourceKind) (*Document, error) {
 	var document Document
 	err := db.Where("company_id = ? AND kind = ?", companyID, kind).Order("filed_at DESC").First(&document).Error
@@ -159,6 +437,43 @@ func GetCompanyDocumentsOfKindInverseChronological(db *gorm.DB, companyID uint,
 	return &document, nil
 }
 
+// GetLatestCompanyDocumentOfKinds returns the most recently filed document of
+// the company of any of the kinds, or nil if there is none.
+func GetLatestCompanyDocumentOfKinds(db *gorm.DB, companyID uint, kinds []SourceKind) (*Document, error) {
+	var document Document
+	err := db.Omit("raw_content").Where("company_id = ? AND kind IN ?", companyID, kinds).Order("filed_at DESC").First(&document).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+
+		return nil, err
+	}
+
+	return &document, nil
+}
+
+// GetPreviousCompanyDocumentOfKind returns the document of the same company and
+// kind filed most recently before the given document, or nil if there is none.
+// Amendments are compared with the original filings they amend, so the kind of
+// an amendment is its base kind.
+func GetPreviousCompanyDocumentOfKind(db *gorm.DB, document *Document) (*Document, error) {
+	var previous Document
+	err := db.Omit("raw_content").
+		Where("company_id = ? AND kind = ? AND filed_at < ? AND id <> ?", document.CompanyID, document.Kind.BaseKind(), document.FiledAt, document.ID).
+		Order("filed_at DESC").
+		First(&previous).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+
+		return nil, err
+	}
+
+	return &previous, nil
+}
+
 func GetCompanyDocumentsInverseChronological(db *gorm.DB, companyID uint, offset, limit int) ([]Document, error) {
 	var documents []Document
 	err := db.Preload("Company").Where("company_id = ?", companyID).Order("filed_at DESC").Offset(offset).Limit(limit).Find(&documents).Error
@@ -186,3 +501,117 @@ func GetDocumentByID(db *gorm.DB, documentID uint) (*Document, error) {
 
 	return &document, nil
 }
+
+func GetDocumentByAccessionNo(db *gorm.DB, accessionNo string) (*Document, error) {
+	var document Document
+	err := db.Where("accession_no = ?", accessionNo).First(&document).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+
+		return nil, err
+	}
+
+	return &document, nil
+}
+
+// GetSkippedAccessionNos returns which of the given accession numbers are not
+// to be ingested when listed: filings ingested as a document or as a filing
+// that had no content, and filings that failed. Failed filings are left to
+// retries, which back off and give up after MAX_INGESTION_ATTEMPTS.
+func GetSkippedAccessionNos(db *gorm.DB, accessionNos []string) (map[string]bool, error) {
+	var ingested []string
+	err := db.Raw(
+		"SELECT accession_no FROM documents WHERE accession_no IN ? AND deleted_at IS NULL UNION SELECT accession_no FROM filing_ingestions WHERE accession_no IN ? AND state IN ? AND deleted_at IS NULL",
+		accessionNos, accessionNos, []IngestionState{IngestionDone, IngestionFailed},
+	).Scan(&ingested).Error
+	if err != nil {
+		return nil, err
+	}
+
+	result := make(map[string]bool, len(ingested))
+	for _, accessionNo := range ingested {
+		result[accessionNo] = true
+	}
+
+	return result, nil
+}
+
+// GetDocumentsWithoutAccessionNo returns up to limit documents of the given
+// kinds with IDs greater than afterID that have no accession number, in ID
+// order. Used to backfill accession numbers.
+func GetDocumentsWithoutAccessionNo(db *gorm.DB, kinds []SourceKind, afterID uint, limit int) ([]Document, error) {
+	var documents []Document
+	err := db.Omit("raw_content").
+		Where("id > ? AND kind IN ? AND accession_no IS NULL", afterID, kinds).
+		Order("id ASC").
+		Limit(limit).
+		Find(&documents).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return documents, nil
+}
+
+func SetDocumentAccessionNo(db *gorm.DB, document *Document, accessionNo string) error {
+	document.AccessionNo = &accessionNo
+	return db.Model(document).Update("accession_no", accessionNo).Error
+}
+
+// GetAmendedDocument finds the document an amendment amends: the document of
+// the amendment's base kind that reports on the same period. Only if the
+// period is unknown is the most recent document of the base kind filed before
+// the amendment returned instead. Returns nil if there is no such document, so
+// that an amendment stored before its original stays unlinked until the
+// original arrives.
+func GetAmendedDocument(db *gorm.DB, amendment *Document) (*Document, error) {
+	query := db.Where("company_id = ? AND kind = ? AND filed_at < ?", amendment.CompanyID, amendment.Kind.BaseKind(), amendment.FiledAt).Order("filed_at DESC")
+	if !amendment.PeriodOfReport.IsZero() {
+		query = query.Where("period_of_report = ?", amendment.PeriodOfReport)
+	}
+
+	var document Document
+	err := query.First(&document).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+
+		return nil, err
+	}
+
+	return &document, nil
+}
+
+// GetUnlinkedAmendments returns the amendments of a document that were stored
+// before it, oldest first: amendments of its kind that report on the same
+// period, were filed after it and amend no document. Returns none if the
+// document's period is unknown.
+func GetUnlinkedAmendments(db *gorm.DB, original *Document) ([]Document, error) {
+	if original.PeriodOfReport.IsZero() {
+		return nil, nil
+	}
+
+	var amendments []Document
+	err := db.Where("company_id = ? AND kind = ? AND period_of_report = ? AND filed_at > ? AND amends_id IS NULL",
+		original.CompanyID, original.Kind+"/A", original.PeriodOfReport, original.FiledAt).
+		Order("filed_at ASC").
+		Find(&amendments).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return amendments, nil
+}
+
+// SupersedeDocument links an amendment to the document it amends. The original
+// and any of its earlier amendments are marked as superseded by the new one.
+func SupersedeDocument(db *gorm.DB, original, amendment *Document) error {
+	if err := db.Model(amendment).Update("amends_id", original.ID).Error; err != nil {
+		return err
+	}
+
+	return db.Model(&Document{}).Where("(id = ? OR amends_id = ?) AND id <> ?", original.ID, original.ID, amendment.ID).Update("superseded_by_id", amendment.ID).Error
+}
@@ -0,0 +1,97 @@
+package models
+
+import (
+	"errors"
+
+	"gorm.io/gorm"
+)
+
+// DocumentSection is the text of a single section (Item) of a document.
+type DocumentSection struct {
+	Generic
+
+	DocumentID uint     `gorm:"uniqueIndex:idx_document_sections_document_code;not null" json:"document_id"`
+	Document   Document `json:"-"`
+	// Section code, e.g. "1A" for risk factors of a 10-K.
+	Code    Section `gorm:"uniqueIndex:idx_document_sections_document_code;not null" json:"code"`
+	Title   string  `json:"title"`
+	Ordinal int     `gorm:"not null" json:"ordinal"`
+	// Content is omitted when listing the sections of a document.
+	Content string `json:"content,omitempty"`
+	Length  int    `gorm:"not null" json:"length"`
+}
+
+func CreateDocumentSection(db *gorm.DB, document *Document, code Section, ordinal int, content string) (*DocumentSection, error) {
+	section := DocumentSection{
+		DocumentID: document.ID,
+		Code:       code,
+		Title:      SectionTitles[code],
+		Ordinal:    ordinal,
+		Content:    content,
+		Length:     len(content),
+	}
+
+	if err := db.Create(&section).Error; err != nil {
+		return nil, err
+	}
+
+	return &section, nil
+}
+
+// GetDocumentSections returns the sections of a document in document order,
+// without their content.
+func GetDocumentSections(db *gorm.DB, documentID uint) ([]DocumentSection, error) {
+	var sections []DocumentSection
+	err := db.Omit("content").Where("document_id = ?", documentID).Order("ordinal ASC").Find(&sections).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return sections, nil
+}
+
+// GetDocumentSectionsWithContent returns the sections of a document in
+// document order, with their content.
+func GetDocumentSectionsWithContent(db *gorm.DB, documentID uint) ([]DocumentSection, error) {
+	var sections []DocumentSection
+	err := db.Where("document_id = ?", documentID).Order("ordinal ASC").Find(&sections).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return sections, nil
+}
+
+// GetDocumentSection returns a section of a document with its content. Codes
+// are matched case-insensitively.
+func GetDocumentSection(db *gorm.DB, documentID uint, code Section) (*DocumentSection, error) {
+	var section DocumentSection
+	err := db.Where("document_id = ? AND LOWER(code) = LOWER(?)", documentID, code).First(&section).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+
+		return nil, err
+	}
+
+	return &section, nil
+}
+
+// GetDocumentsWithoutSections returns up to limit documents of the given kinds
+// with IDs greater than afterID that have no sections stored, in ID order. Used
+// to backfill sections.
+func GetDocumentsWithoutSections(db *gorm.DB, kinds []SourceKind, afterID uint, limit int) ([]Document, error) {
+	var documents []Document
+	err := db.Preload("Company").
+		Where("id > ? AND kind IN ?", afterID, kinds).
+		Where("NOT EXISTS (SELECT 1 FROM document_sections WHERE document_sections.document_id = documents.id)").
+		Order("id ASC").
+		Limit(limit).
+		Find(&documents).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return documents, nil
+}
@@ -0,0 +1,212 @@
+package models
+
+import (
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// IngestionState is the stage a filing is in on its way into the database and
+// the vector store.
+type IngestionState string
+
+const (
+	// Pending filings were listed by a run and are queued for ingestion but
+	// not attempted yet.
+	IngestionPending   IngestionState = "pending"
+	IngestionFetching  IngestionState = "fetching"
+	IngestionEmbedding IngestionState = "embedding"
+	IngestionDone      IngestionState = "done"
+	IngestionFailed    IngestionState = "failed"
+)
+
+// Filings that failed this many times are no longer retried.
+const MAX_INGESTION_ATTEMPTS = 5
+
+// IngestionRun is a single run of the document fetcher.
+type IngestionRun struct {
+	Generic
+
+	StartedAt  time.Time  `gorm:"not null" json:"started_at"`
+	FinishedAt *time.Time `json:"finished_at"`
+	Succeeded  int        `gorm:"not null;default:0" json:"succeeded"`
+	Failed     int        `gorm:"not null;default:0" json:"failed"`
+}
+
+// FilingIngestion tracks the ingestion of a single filing across runs.
+type FilingIngestion struct {
+	Generic
+
+	// The run that last attempted the filing.
+	IngestionRunID uint       `gorm:"index;not null" json:"ingestion_run_id"`
+	CompanyID      uint       `gorm:"index;not null" json:"company_id"`
+	Company        Company    `json:"-"`
+	AccessionNo    string     `gorm:"uniqueIndex;not null" json:"accession_no"`
+	Kind           SourceKind `gorm:"not null" json:"kind"`
+	// The filing as returned by the filings provider, kept so that the
+	// filing can be retried without listing it again.
+	Filing        JSON           `gorm:"type:jsonb" json:"-"`
+	State         IngestionState `gorm:"index;not null" json:"state"`
+	Attempts      int            `gorm:"not null;default:0" json:"attempts"`
+	LastError     string         `json:"last_error"`
+	StartedAt     *time.Time     `json:"started_at"`
+	FinishedAt    *time.Time     `json:"finished_at"`
+	NextAttemptAt *time.Time     `gorm:"index" json:"next_attempt_at"`
+	// The document created from the filing. Filings without content create
+	// no document.
+	DocumentID *uint `json:"document_id"`
+}
+
+func CreateIngestionRun(db *gorm.DB) (*IngestionRun, error) {
+	run := IngestionRun{
+		StartedAt: time.Now(),
+	}
+
+	if err := db.Create(&run).Error; err != nil {
+		return nil, err
+	}
+
+	return &run, nil
+}
+
+func FinishIngestionRun(db *gorm.DB, run *IngestionRun, succeeded, failed int) error {
+	now := time.Now()
+	run.FinishedAt = &now
+	run.Succeeded = succeeded
+	run.Failed = failed
+
+	return db.Model(run).Select("finished_at", "succeeded", "failed").Updates(run).Error
+}
+
+// QueueFilingIngestion records that a run listed a filing and queued it for
+// ingestion. The filing is pending until BeginFilingIngestion records the
+// attempt. Queueing a filing does not count as an attempt.
+func QueueFilingIngestion(db *gorm.DB, runID, companyID uint, accessionNo string, kind SourceKind, filing JSON) error {
+	ingestion := FilingIngestion{
+		IngestionRunID: runID,
+		CompanyID:      companyID,
+		AccessionNo:    accessionNo,
+		Kind:           kind,
+		Filing:         filing,
+		State:          IngestionPending,
+	}
+
+	return db.Clauses(clause.OnConflict{
+		Columns: []clause.Column{{Name: "accession_no"}},
+		DoUpdates: clause.Assignments(map[string]interface{}{
+			"ingestion_run_id": runID,
+			"filing":           filing,
+			"state":            IngestionPending,
+			"updated_at":       time.Now(),
+		}),
+	}).Create(&ingestion).Error
+}
+
+// BeginFilingIngestion records a new attempt at ingesting a filing, creating
+// the filing's record on the first attempt.
+func BeginFilingIngestion(db *gorm.DB, runID, companyID uint, accessionNo string, kind SourceKind, filing JSON) (*FilingIngestion, error) {
+	now := time.Now()
+	ingestion := FilingIngestion{
+		IngestionRunID: runID,
+		CompanyID:      companyID,
+		AccessionNo:    accessionNo,
+		Kind:           kind,
+		Filing:         filing,
+		State:          IngestionFetching,
+		Attempts:       1,
+		StartedAt:      &now,
+	}
+
+	err := db.Clauses(clause.OnConflict{
+		Columns: []clause.Column{{Name: "accession_no"}},
+		DoUpdates: clause.Assignments(map[string]interface{}{
+			"ingestion_run_id": runID,
+			"filing":           filing,
+			"state":            IngestionFetching,
+			"attempts":         gorm.Expr("filing_ingestions.attempts + 1"),
+			"started_at":       now,
+			"finished_at":      nil,
+			"next_attempt_at":  nil,
+			"updated_at":       now,
+		}),
+	}).Create(&ingestion).Error
+	if err != nil {
+		return nil, err
+	}
+
+	// Reload to get the attempt count of an existing record.
+	if err := db.Where("accession_no = ?", accessionNo).First(&ingestion).Error; err != nil {
+		return nil, err
+	}
+
+	return &ingestion, nil
+}
+
+func SetFilingIngestionState(db *gorm.DB, ingestion *FilingIngestion, state IngestionState) error {
+	ingestion.State = state
+	return db.Model(ingestion).Update("state", state).Error
+}
+
+// FinishFilingIngestion marks a filing as ingested. documentID is nil if the
+// filing had no content.
+func FinishFilingIngestion(db *gorm.DB, ingestion *FilingIngestion, documentID *uint) error {
+	now := time.Now()
+	ingestion.State = IngestionDone
+	ingestion.FinishedAt = &now
+	ingestion.LastError = ""
+	ingestion.DocumentID = documentID
+
+	return db.Model(ingestion).Select("state", "finished_at", "last_error", "document_id").Updates(ingestion).Error
+}
+
+// FailFilingIngestion marks a filing as failed. The filing is due for another
+// attempt after a backoff that doubles with every attempt, starting at an hour
+// and capped at a week.
+func FailFilingIngestion(db *gorm.DB, ingestion *FilingIngestion, cause error) error {
+	now := time.Now()
+	backoff := time.Hour << (ingestion.Attempts - 1)
+	if week := 7 * 24 * time.Hour; ingestion.Attempts > 8 || backoff > week {
+		backoff = week
+	}
+	nextAttemptAt := now.Add(backoff)
+
+	ingestion.State = IngestionFailed
+	ingestion.FinishedAt = &now
+	ingestion.LastError = cause.Error()
+	ingestion.NextAttemptAt = &nextAttemptAt
+
+	return db.Model(ingestion).Select("state", "finished_at", "last_error", "next_attempt_at").Updates(ingestion).Error
+}
+
+// GetRetriableFilingIngestions returns failed filings that are due for another
+// attempt and have not run out of attempts.
+func GetRetriableFilingIngestions(db *gorm.DB, limit int) ([]FilingIngestion, error) {
+	var ingestions []FilingIngestion
+	err := db.Preload("Company").
+		Where("state = ? AND next_attempt_at <= ? AND attempts < ?", IngestionFailed, time.Now(), MAX_INGESTION_ATTEMPTS).
+		Order("next_attempt_at ASC").
+		Limit(limit).
+		Find(&ingestions).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return ingestions, nil
+}
+
+// GetFailedFilingIngestions returns failed filings, most recently failed first.
+func GetFailedFilingIngestions(db *gorm.DB, offset, limit int) ([]FilingIngestion, error) {
+	var ingestions []FilingIngestion
+	err := db.Where("state = ?", IngestionFailed).Order("finished_at DESC").Offset(offset).Limit(limit).Find(&ingestions).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+
+		return nil, err
+	}
+
+	return ingestions, nil
+}
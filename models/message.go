@@ -110,3 +110,14 @@ func CountUserGenerations(db *gorm.DB, userID uint) (int64, error) {
 
 	return count, nil
 }
+
+// GetUserMessagesChronological returns all messages of a user's conversations,
+// oldest first.
+func GetUserMessagesChronological(db *gorm.DB, userID uint) ([]Message, error) {
+	var messages []Message
+	if err := db.Where("user_id = ?", userID).Order("created_at ASC, id ASC").Find(&messages).Error; err != nil {
+		return nil, err
+	}
+
+	return messages, nil
+}
@@ -0,0 +1,40 @@
+package models
+
+import (
+	"gorm.io/gorm"
+)
+
+// Migrate brings the database schema and data up to date. Every binary runs it
+// on start.
+//
+// The unique index on CIKs cannot be created while companies share a CIK.
+// Databases from before the index must be merged once with
+// "document_fetcher merge-ciks" first.
+func Migrate(db *gorm.DB) error {
+	err := db.Debug().AutoMigrate(
+		&User{},
+		&Company{},
+		&Document{},
+		&DocumentSection{},
+		&AccessToken{},
+		&Message{},
+		&IngestionRun{},
+		&FilingIngestion{},
+		&Security{},
+		&TickerChange{},
+		&PriceBar{},
+		&Fundamentals{},
+		&Valuation{},
+		&Watchlist{},
+		&WatchlistEntry{},
+		&Alert{},
+		&Notification{},
+		&APIKey{},
+		&AuditEntry{},
+	)
+	if err != nil {
+		return err
+	}
+
+	return SetMissingAccessTokenExpiry(db)
+}
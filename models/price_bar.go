@@ -0,0 +1,118 @@
+package models
+
+import (
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// PriceBar is the trading of a company's primary security over a day.
+type PriceBar struct {
+	Generic
+
+	CompanyID uint `gorm:"uniqueIndex:idx_price_bars_company_date;not null" json:"company_id"`
+	// Trading day, at midnight UTC.
+	Date  time.Time `gorm:"type:date;uniqueIndex:idx_price_bars_company_date;not null" json:"date"`
+	Open  float64   `json:"open"`
+	High  float64   `json:"high"`
+	Low   float64   `json:"low"`
+	Close float64   `json:"close"`
+	// Close adjusted for later splits and dividends, comparable across dates.
+	AdjustedClose float64 `json:"adjusted_close"`
+	Volume        int64   `json:"volume"`
+}
+
+// PriceInterval is the period a price bar returned by the API covers.
+type PriceInterval string
+
+const (
+	Daily   PriceInterval = "day"
+	Weekly  PriceInterval = "week"
+	Monthly PriceInterval = "month"
+)
+
+// SavePriceBars creates price bars, replacing existing bars of the same company
+// and date.
+func SavePriceBars(db *gorm.DB, bars []PriceBar) error {
+	if len(bars) == 0 {
+		return nil
+	}
+
+	return db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "company_id"}, {Name: "date"}},
+		DoUpdates: clause.AssignmentColumns([]string{"open", "high", "low", "close", "adjusted_close", "volume", "updated_at"}),
+	}).CreateInBatches(&bars, 500).Error
+}
+
+// GetPriceBars returns the daily price bars of a company from from to to,
+// inclusive, oldest first.
+func GetPriceBars(db *gorm.DB, companyID uint, from, to time.Time) ([]PriceBar, error) {
+	var bars []PriceBar
+	err := db.Where("company_id = ? AND date BETWEEN ? AND ?", companyID, from, to).Order("date ASC").Find(&bars).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return bars, nil
+}
+
+// GetLatestPriceBarDate returns the date of the company's latest price bar, or
+// nil if it has none.
+func GetLatestPriceBarDate(db *gorm.DB, companyID uint) (*time.Time, error) {
+	var bar PriceBar
+	err := db.Select("date").Where("company_id = ?", companyID).Order("date DESC").First(&bar).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+
+		return nil, err
+	}
+
+	return &bar.Date, nil
+}
+
+// AggregatePriceBars merges daily price bars, oldest first, into bars of the
+// interval. A weekly bar starts on Monday and a monthly bar on the first of the
+// month, and each is dated by its first trading day.
+func AggregatePriceBars(bars []PriceBar, interval PriceInterval) []PriceBar {
+	if interval == Daily {
+		return bars
+	}
+
+	periodStart := func(date time.Time) time.Time {
+		if interval == Weekly {
+			// Weekdays count from Sunday.
+			offset := (int(date.Weekday()) + 6) % 7
+			return date.AddDate(0, 0, -offset)
+		}
+
+		return time.Date(date.Year(), date.Month(), 1, 0, 0, 0, 0, date.Location())
+	}
+
+	aggregated := []PriceBar{}
+	var start time.Time
+	for _, bar := range bars {
+		if barStart := periodStart(bar.Date); len(aggregated) == 0 || !barStart.Equal(start) {
+			start = barStart
+			bar.ID = 0
+			aggregated = append(aggregated, bar)
+			continue
+		}
+
+		last := &aggregated[len(aggregated)-1]
+		if bar.High > last.High {
+			last.High = bar.High
+		}
+		if bar.Low < last.Low {
+			last.Low = bar.Low
+		}
+		last.Close = bar.Close
+		last.AdjustedClose = bar.AdjustedClose
+		last.Volume += bar.Volume
+	}
+
+	return aggregated
+}
@@ -0,0 +1,214 @@
+package models
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Security is a listed share class of a company. A company is the issuer and
+// has one security per ticker it trades under, e.g. Berkshire Hathaway trades
+// as BRK.A and BRK.B.
+type Security struct {
+	Generic
+
+	CompanyID uint    `gorm:"uniqueIndex:idx_securities_company_ticker;not null" json:"company_id"`
+	Company   Company `json:"-"`
+	Ticker    string  `gorm:"uniqueIndex:idx_securities_company_ticker;not null" json:"ticker"`
+	Exchange  string  `json:"exchange"`
+	// Delisted securities are kept inactive, so that their tickers still
+	// resolve to the company.
+	Active     bool       `gorm:"not null" json:"active"`
+	DelistedAt *time.Time `json:"delisted_at"`
+}
+
+// TickerChange records a change of a security's ticker, so that lookups by the
+// old ticker resolve to the company.
+type TickerChange struct {
+	Generic
+
+	SecurityID uint      `gorm:"index;not null" json:"security_id"`
+	CompanyID  uint      `gorm:"index;not null" json:"company_id"`
+	OldTicker  string    `gorm:"index;not null" json:"old_ticker"`
+	NewTicker  string    `gorm:"not null" json:"new_ticker"`
+	ChangedAt  time.Time `gorm:"not null" json:"changed_at"`
+}
+
+// ListedSecurity is a security as it appears in an exchange listing.
+type ListedSecurity struct {
+	Ticker   string
+	Exchange string
+	Delisted bool
+}
+
+func CreateSecurity(db *gorm.DB, company *Company, listed ListedSecurity) (*Security, error) {
+	security := Security{
+		CompanyID: company.ID,
+		Ticker:    strings.ToUpper(listed.Ticker),
+		Exchange:  listed.Exchange,
+		Active:    !listed.Delisted,
+	}
+	if listed.Delisted {
+		now := time.Now()
+		security.DelistedAt = &now
+	}
+
+	if err := db.Create(&security).Error; err != nil {
+		return nil, err
+	}
+
+	return &security, nil
+}
+
+func GetCompanySecurities(db *gorm.DB, companyID uint) ([]Security, error) {
+	var securities []Security
+	err := db.Where("company_id = ?", companyID).Order("id ASC").Find(&securities).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return securities, nil
+}
+
+// SyncSecurities brings the securities of a company in line with its current
+// listings. Listed tickers we do not know are added, and delisted ones are
+// marked inactive. A single new ticker appearing while a single active ticker
+// disappears is taken to be a ticker change. Securities missing from the
+// listings are left unchanged, as a listing may not cover all exchanges. The
+// company is active while any of its securities is, and its primary ticker is
+// that of an active security if it has one.
+func SyncSecurities(db *gorm.DB, company *Company, listed []ListedSecurity) error {
+	securities, err := GetCompanySecurities(db, company.ID)
+	if err != nil {
+		return err
+	}
+
+	byTicker := map[string]*Security{}
+	for i := range securities {
+		byTicker[securities[i].Ticker] = &securities[i]
+	}
+
+	matched := map[uint]bool{}
+	var added []ListedSecurity
+	for _, l := range listed {
+		l.Ticker = strings.ToUpper(l.Ticker)
+		security, ok := byTicker[l.Ticker]
+		if !ok {
+			added = append(added, l)
+			continue
+		}
+
+		matched[security.ID] = true
+		if err := updateListedSecurity(db, security, l); err != nil {
+			return err
+		}
+	}
+
+	var vanished []*Security
+	for i := range securities {
+		if securities[i].Active && !matched[securities[i].ID] {
+			vanished = append(vanished, &securities[i])
+		}
+	}
+
+	if len(added) == 1 && len(vanished) == 1 && !added[0].Delisted {
+		if err := ChangeTicker(db, company, vanished[0], added[0].Ticker); err != nil {
+			return err
+		}
+		if err := updateListedSecurity(db, vanished[0], added[0]); err != nil {
+			return err
+		}
+		added = nil
+	}
+
+	for _, l := range added {
+		security, err := CreateSecurity(db, company, l)
+		if err != nil {
+			return err
+		}
+		securities = append(securities, *security)
+	}
+
+	active := false
+	var primary, firstActive *Security
+	for i := range securities {
+		active = active || securities[i].Active
+		if securities[i].Ticker == company.Ticker {
+			primary = &securities[i]
+		}
+		if securities[i].Active && firstActive == nil {
+			firstActive = &securities[i]
+		}
+	}
+
+	// The primary ticker moves to a share class that is still listed when
+	// its own is delisted.
+	if (primary == nil || !primary.Active) && firstActive != nil {
+		company.Ticker = firstActive.Ticker
+		if err := db.Model(company).Update("ticker", company.Ticker).Error; err != nil {
+			return err
+		}
+	}
+
+	company.Active = active
+	return db.Model(company).Update("active", active).Error
+}
+
+// updateListedSecurity updates the exchange and listing status of a security.
+func updateListedSecurity(db *gorm.DB, security *Security, listed ListedSecurity) error {
+	security.Exchange = listed.Exchange
+	if listed.Delisted && security.Active {
+		now := time.Now()
+		security.Active = false
+		security.DelistedAt = &now
+	} else if !listed.Delisted {
+		security.Active = true
+		security.DelistedAt = nil
+	}
+
+	return db.Model(security).Select("exchange", "active", "delisted_at").Updates(security).Error
+}
+
+// ChangeTicker changes the ticker of a security and records the old one. The
+// company's primary ticker follows its security.
+func ChangeTicker(db *gorm.DB, company *Company, security *Security, ticker string) error {
+	ticker = strings.ToUpper(ticker)
+	change := TickerChange{
+		SecurityID: security.ID,
+		CompanyID:  company.ID,
+		OldTicker:  security.Ticker,
+		NewTicker:  ticker,
+		ChangedAt:  time.Now(),
+	}
+	if err := db.Create(&change).Error; err != nil {
+		return err
+	}
+
+	if company.Ticker == security.Ticker {
+		company.Ticker = ticker
+		if err := db.Model(company).Update("ticker", ticker).Error; err != nil {
+			return err
+		}
+	}
+
+	security.Ticker = ticker
+	return db.Model(security).Update("ticker", ticker).Error
+}
+
+// getCompanyIDByFormerTicker returns the ID of the company that most recently
+// traded under a ticker it no longer uses, or 0 if there is none.
+func getCompanyIDByFormerTicker(db *gorm.DB, ticker string) (uint, error) {
+	var change TickerChange
+	err := db.Where("old_ticker = ?", ticker).Order("changed_at DESC").First(&change).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return 0, nil
+		}
+
+		return 0, err
+	}
+
+	return change.CompanyID, nil
+}
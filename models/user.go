@@ -2,6 +2,7 @@ package models
 
 import (
 	"errors"
+	"fmt"
 
 	"gorm.io/gorm"
 )
@@ -16,6 +17,7 @@ type User struct {
 	FirebaseSubjectID         string `gorm:"unique" json:"-"`
 	StripeCustomerID          string `gorm:"unique" json:"-"`
 	IsSubscribed              bool   `gorm:"not null; default:false" json:"is_subscribed"`
+	IsAdmin                   bool   `gorm:"not null; default:false" json:"-"`
 	RemainingMessageAllowance int64  `gorm:"-" sql:"-" json:"remaining_message_allowance"`
 }
 
@@ -94,6 +96,51 @@ func CreateUser(db *gorm.DB, email, fullName, stripeCustomerID, firebaseSubjectI
 	return &user, nil
 }
 
+// DeleteUserAccount removes the data of a user and records the deletion. The
+// user's messages, sessions, API keys, watchlists, alerts and notifications are
+// deleted for good. The user row is anonymized and soft-deleted, so that
+// records pointing at it stay valid.
+func DeleteUserAccount(db *gorm.DB, user *User) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		watchlistIDs := tx.Model(&Watchlist{}).Unscoped().Select("id").Where("user_id = ?", user.ID)
+		if err := tx.Unscoped().Where("watchlist_id IN (?)", watchlistIDs).Delete(&WatchlistEntry{}).Error; err != nil {
+			return err
+		}
+
+		for _, model := range []interface{}{
+			&Message{},
+			&AccessToken{},
+			&APIKey{},
+			&Watchlist{},
+			&Notification{},
+			&Alert{},
+		} {
+			if err := tx.Unscoped().Where("user_id = ?", user.ID).Delete(model).Error; err != nil {
+				return err
+			}
+		}
+
+		// The columns are unique, so they are replaced with values unique to
+		// the user.
+		deleted := fmt.Sprintf("deleted-%v", user.ID)
+		err := tx.Model(user).Updates(map[string]interface{}{
+			"email":               deleted,
+			"full_name":           "",
+			"firebase_subject_id": deleted,
+			"stripe_customer_id":  deleted,
+			"is_subscribed":       false,
+		}).Error
+		if err != nil {
+			return err
+		}
+		if err := tx.Delete(user).Error; err != nil {
+			return err
+		}
+
+		return CreateAuditEntry(tx, user.ID, AccountDeletedAction)
+	})
+}
+
 // Set remaining message allowance counter on the user object.
 func setMessageAllowance(db *gorm.DB, user *User) error {
 	if user.IsSubscribed {
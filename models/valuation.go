@@ -0,0 +1,174 @@
+package models
+
+import (
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Fundamentals are the latest figures a company reported in its filings, in
+// USD. Income statement figures are trailing twelve months. Figures a company
+// did not report are nil.
+type Fundamentals struct {
+	Generic
+
+	CompanyID uint `gorm:"uniqueIndex;not null" json:"company_id"`
+	// Shares outstanding of all share classes together.
+	SharesOutstanding *float64 `json:"shares_outstanding"`
+	Revenue           *float64 `json:"revenue"`
+	GrossProfit       *float64 `json:"gross_profit"`
+	OperatingIncome   *float64 `json:"operating_income"`
+	NetIncome         *float64 `json:"net_income"`
+	// Depreciation and amortization, added to operating income to get
+	// EBITDA.
+	DepreciationAndAmortization *float64 `json:"depreciation_and_amortization"`
+	TotalDebt                   *float64 `json:"total_debt"`
+	Cash                        *float64 `json:"cash"`
+	StockholdersEquity          *float64 `json:"stockholders_equity"`
+	// End of the latest period reported on and the date it was filed.
+	PeriodEnd time.Time `json:"period_end"`
+	FiledAt   time.Time `json:"filed_at"`
+}
+
+// Valuation is a snapshot of valuation metrics of a company, computed from its
+// price and fundamentals. Metrics that cannot be computed, such as the P/E of a
+// company with losses, are nil.
+type Valuation struct {
+	Generic
+
+	CompanyID       uint     `gorm:"uniqueIndex;not null" json:"company_id"`
+	MarketCap       *float64 `json:"market_cap"`
+	EnterpriseValue *float64 `json:"enterprise_value"`
+	PriceToEarnings *float64 `json:"price_to_earnings"`
+	PriceToSales    *float64 `json:"price_to_sales"`
+	EVToEBITDA      *float64 `json:"ev_to_ebitda"`
+	GrossMargin     *float64 `json:"gross_margin"`
+	OperatingMargin *float64 `json:"operating_margin"`
+	NetMargin       *float64 `json:"net_margin"`
+	ReturnOnEquity  *float64 `json:"return_on_equity"`
+	// Price and fundamentals the metrics were computed from.
+	Price     float64   `json:"price"`
+	PriceAsOf time.Time `json:"price_as_of"`
+	PeriodEnd time.Time `json:"period_end"`
+}
+
+// SaveFundamentals creates or replaces the fundamentals of a company.
+func SaveFundamentals(db *gorm.DB, fundamentals *Fundamentals) error {
+	return db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "company_id"}},
+		UpdateAll: true,
+	}).Create(fundamentals).Error
+}
+
+func GetFundamentals(db *gorm.DB, companyID uint) (*Fundamentals, error) {
+	var fundamentals Fundamentals
+	err := db.Where("company_id = ?", companyID).First(&fundamentals).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+
+		return nil, err
+	}
+
+	return &fundamentals, nil
+}
+
+func GetValuation(db *gorm.DB, companyID uint) (*Valuation, error) {
+	var valuation Valuation
+	err := db.Where("company_id = ?", companyID).First(&valuation).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+
+		return nil, err
+	}
+
+	return &valuation, nil
+}
+
+// RefreshValuation recomputes and stores the valuation of a company from its
+// current price and stored fundamentals. It does nothing for companies without
+// fundamentals. Call it whenever either changes.
+func RefreshValuation(db *gorm.DB, company *Company) error {
+	fundamentals, err := GetFundamentals(db, company.ID)
+	if err != nil || fundamentals == nil {
+		return err
+	}
+
+	var activeSecurities int64
+	err = db.Model(&Security{}).Where("company_id = ? AND active", company.ID).Count(&activeSecurities).Error
+	if err != nil {
+		return err
+	}
+
+	valuation := ComputeValuation(company, fundamentals, activeSecurities > 1)
+	return db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "company_id"}},
+		UpdateAll: true,
+	}).Create(&valuation).Error
+}
+
+// ComputeValuation computes the valuation of a company from its price and
+// fundamentals. Fundamentals are in USD, so metrics involving the price are
+// only computed for prices in USD. They are not computed either for companies
+// with several listed share classes: the shares outstanding are of all
+// classes, while the price is of the primary one, and classes trade at
+// different prices.
+func ComputeValuation(company *Company, f *Fundamentals, severalClasses bool) Valuation {
+	valuation := Valuation{
+		CompanyID: company.ID,
+		Price:     company.Price,
+		PeriodEnd: f.PeriodEnd,
+	}
+	if company.PriceAsOf != nil {
+		valuation.PriceAsOf = *company.PriceAsOf
+	}
+
+	valuation.GrossMargin = ratio(f.GrossProfit, f.Revenue, false)
+	valuation.OperatingMargin = ratio(f.OperatingIncome, f.Revenue, false)
+	valuation.NetMargin = ratio(f.NetIncome, f.Revenue, false)
+	// Returns on negative equity are meaningless.
+	valuation.ReturnOnEquity = ratio(f.NetIncome, f.StockholdersEquity, true)
+
+	if company.Price <= 0 || f.SharesOutstanding == nil || (company.Currency != "" && company.Currency != "USD") || severalClasses {
+		return valuation
+	}
+
+	marketCap := company.Price * *f.SharesOutstanding
+	valuation.MarketCap = &marketCap
+	valuation.PriceToEarnings = ratio(&marketCap, f.NetIncome, true)
+	valuation.PriceToSales = ratio(&marketCap, f.Revenue, true)
+
+	if f.TotalDebt != nil || f.Cash != nil {
+		enterpriseValue := marketCap
+		if f.TotalDebt != nil {
+			enterpriseValue += *f.TotalDebt
+		}
+		if f.Cash != nil {
+			enterpriseValue -= *f.Cash
+		}
+		valuation.EnterpriseValue = &enterpriseValue
+
+		if f.OperatingIncome != nil && f.DepreciationAndAmortization != nil {
+			ebitda := *f.OperatingIncome + *f.DepreciationAndAmortization
+			valuation.EVToEBITDA = ratio(&enterpriseValue, &ebitda, true)
+		}
+	}
+
+	return valuation
+}
+
+// ratio divides a by b. It is nil if either is unknown, b is zero, or b is
+// negative and positive is set, e.g. as multiples of losses are meaningless.
+func ratio(a, b *float64, positive bool) *float64 {
+	if a == nil || b == nil || *b == 0 || (positive && *b < 0) {
+		return nil
+	}
+
+	r := *a / *b
+	return &r
+}
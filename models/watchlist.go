@@ -0,0 +1,250 @@
+package models
+
+import (
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Watchlist is a named list of companies a user follows. A user can have
+// several.
+type Watchlist struct {
+	Generic
+
+	UserID uint   `gorm:"index;not null" json:"user_id"`
+	User   User   `json:"-"`
+	Name   string `gorm:"not null" json:"name"`
+	// Companies of the list in order. Only loaded where noted.
+	Entries []WatchlistEntry `json:"entries"`
+}
+
+// WatchlistEntry is a company on a watchlist. A company is on a list at most
+// once.
+type WatchlistEntry struct {
+	Generic
+
+	WatchlistID uint    `gorm:"uniqueIndex:idx_watchlist_entries_watchlist_company;not null" json:"-"`
+	CompanyID   uint    `gorm:"uniqueIndex:idx_watchlist_entries_watchlist_company;not null" json:"company_id"`
+	Company     Company `json:"company"`
+	// Entries are listed in ascending position.
+	Position int `gorm:"not null" json:"position"`
+	// Filing time of the company's most recent document, if any. Loaded
+	// with the entries.
+	LatestFiledAt *time.Time `gorm:"-" json:"latest_filed_at"`
+}
+
+// ErrUnknownWatchlistCompanies is returned when companies to put on a watchlist
+// do not exist.
+var ErrUnknownWatchlistCompanies = errors.New("unknown companies")
+
+// CreateWatchlist creates a watchlist of the companies, in order.
+func CreateWatchlist(db *gorm.DB, userID uint, name string, companyIDs []uint) (*Watchlist, error) {
+	watchlist := Watchlist{
+		UserID: userID,
+		Name:   name,
+	}
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&watchlist).Error; err != nil {
+			return err
+		}
+
+		return setWatchlistEntries(tx, &watchlist, companyIDs)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return GetUserWatchlist(db, userID, watchlist.ID)
+}
+
+// GetUserWatchlists returns the watchlists of a user in order of creation,
+// with their entries loaded.
+func GetUserWatchlists(db *gorm.DB, userID uint) ([]Watchlist, error) {
+	var watchlists []Watchlist
+	err := db.Preload("Entries", orderWatchlistEntries).Preload("Entries.Company").
+		Where("user_id = ?", userID).
+		Order("id ASC").
+		Find(&watchlists).Error
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range watchlists {
+		if err := loadLatestFiledAt(db, watchlists[i].Entries); err != nil {
+			return nil, err
+		}
+	}
+
+	return watchlists, nil
+}
+
+func CountUserWatchlists(db *gorm.DB, userID uint) (int64, error) {
+	var count int64
+	err := db.Model(&Watchlist{}).Where("user_id = ?", userID).Count(&count).Error
+	return count, err
+}
+
+// GetUserWatchlist returns a watchlist of a user with its entries loaded, or
+// nil if the user has no such watchlist.
+func GetUserWatchlist(db *gorm.DB, userID, watchlistID uint) (*Watchlist, error) {
+	var watchlist Watchlist
+	err := db.Preload("Entries", orderWatchlistEntries).Preload("Entries.Company").
+		Where("id = ? AND user_id = ?", watchlistID, userID).
+		First(&watchlist).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+
+		return nil, err
+	}
+
+	if err := loadLatestFiledAt(db, watchlist.Entries); err != nil {
+		return nil, err
+	}
+
+	return &watchlist, nil
+}
+
+func orderWatchlistEntries(db *gorm.DB) *gorm.DB {
+	return db.Order("position ASC, id ASC")
+}
+
+// loadLatestFiledAt sets the filing time of the most recent document of each
+// entry's company.
+func loadLatestFiledAt(db *gorm.DB, entries []WatchlistEntry) error {
+	if len(entries) == 0 {
+		return nil
+	}
+
+	companyIDs := make([]uint, len(entries))
+	for i, entry := range entries {
+		companyIDs[i] = entry.CompanyID
+	}
+
+	var rows []struct {
+		CompanyID     uint
+		LatestFiledAt time.Time
+	}
+	err := db.Model(&Document{}).
+		Select("company_id, MAX(filed_at) AS latest_filed_at").
+		Where("company_id IN ?", companyIDs).
+		Group("company_id").
+		Scan(&rows).Error
+	if err != nil {
+		return err
+	}
+
+	latest := map[uint]time.Time{}
+	for _, row := range rows {
+		latest[row.CompanyID] = row.LatestFiledAt
+	}
+	for i := range entries {
+		if filedAt, ok := latest[entries[i].CompanyID]; ok {
+			entries[i].LatestFiledAt = &filedAt
+		}
+	}
+
+	return nil
+}
+
+// RenameWatchlist changes the name of a watchlist.
+func RenameWatchlist(db *gorm.DB, watchlist *Watchlist, name string) error {
+	return db.Model(watchlist).Update("name", name).Error
+}
+
+// ReplaceWatchlistEntries replaces the companies of a watchlist with the given
+// ones, in order.
+func ReplaceWatchlistEntries(db *gorm.DB, watchlist *Watchlist, companyIDs []uint) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		return setWatchlistEntries(tx, watchlist, companyIDs)
+	})
+}
+
+// setWatchlistEntries replaces the entries of a watchlist. Duplicate companies
+// keep their first position.
+func setWatchlistEntries(tx *gorm.DB, watchlist *Watchlist, companyIDs []uint) error {
+	var unique []uint
+	seen := map[uint]bool{}
+	for _, companyID := range companyIDs {
+		if !seen[companyID] {
+			seen[companyID] = true
+			unique = append(unique, companyID)
+		}
+	}
+
+	if len(unique) > 0 {
+		var count int64
+		if err := tx.Model(&Company{}).Where("id IN ?", unique).Count(&count).Error; err != nil {
+			return err
+		}
+		if int(count) != len(unique) {
+			return ErrUnknownWatchlistCompanies
+		}
+	}
+
+	// Entries are deleted for good, as the unique index covers deleted rows.
+	if err := tx.Unscoped().Where("watchlist_id = ?", watchlist.ID).Delete(&WatchlistEntry{}).Error; err != nil {
+		return err
+	}
+	if len(unique) == 0 {
+		return nil
+	}
+
+	entries := make([]WatchlistEntry, len(unique))
+	for i, companyID := range unique {
+		entries[i] = WatchlistEntry{
+			WatchlistID: watchlist.ID,
+			CompanyID:   companyID,
+			Position:    i,
+		}
+	}
+
+	return tx.Omit("Company").Create(&entries).Error
+}
+
+// AddWatchlistEntry adds a company to the end of a watchlist. A company
+// already on the list keeps its position.
+func AddWatchlistEntry(db *gorm.DB, watchlist *Watchlist, companyID uint) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		var count int64
+		err := tx.Model(&WatchlistEntry{}).Where("watchlist_id = ? AND company_id = ?", watchlist.ID, companyID).Count(&count).Error
+		if err != nil || count > 0 {
+			return err
+		}
+
+		var position int
+		err = tx.Model(&WatchlistEntry{}).Where("watchlist_id = ?", watchlist.ID).
+			Select("COALESCE(MAX(position) + 1, 0)").
+			Scan(&position).Error
+		if err != nil {
+			return err
+		}
+
+		return tx.Omit("Company").Create(&WatchlistEntry{
+			WatchlistID: watchlist.ID,
+			CompanyID:   companyID,
+			Position:    position,
+		}).Error
+	})
+}
+
+// RemoveWatchlistEntry removes a company from a watchlist. It reports whether
+// the company was on the list.
+func RemoveWatchlistEntry(db *gorm.DB, watchlist *Watchlist, companyID uint) (bool, error) {
+	result := db.Unscoped().Where("watchlist_id = ? AND company_id = ?", watchlist.ID, companyID).Delete(&WatchlistEntry{})
+	return result.RowsAffected > 0, result.Error
+}
+
+// DeleteWatchlist deletes a watchlist and its entries.
+func DeleteWatchlist(db *gorm.DB, watchlist *Watchlist) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Unscoped().Where("watchlist_id = ?", watchlist.ID).Delete(&WatchlistEntry{}).Error; err != nil {
+			return err
+		}
+
+		return tx.Delete(watchlist).Error
+	})
+}